@@ -660,6 +660,16 @@ func (r *RegionInfo) GetWriteRate() (bytesRate, keysRate float64) {
 	return 0, 0
 }
 
+// GetReadRate returns the read rate of the region.
+func (r *RegionInfo) GetReadRate() (bytesRate, keysRate float64) {
+	reportInterval := r.GetInterval()
+	interval := reportInterval.GetEndTimestamp() - reportInterval.GetStartTimestamp()
+	if interval >= statsReportMinInterval && interval <= statsReportMaxInterval {
+		return float64(r.readBytes) / float64(interval), float64(r.readKeys) / float64(interval)
+	}
+	return 0, 0
+}
+
 // GetLeader returns the leader of the region.
 func (r *RegionInfo) GetLeader() *metapb.Peer {
 	return r.leader
@@ -291,6 +291,29 @@ func TestRegionWriteRate(t *testing.T) {
 	}
 }
 
+func TestRegionReadRate(t *testing.T) {
+	re := require.New(t)
+	testCases := []struct {
+		bytes           uint64
+		keys            uint64
+		interval        uint64
+		expectBytesRate float64
+		expectKeysRate  float64
+	}{
+		{0, 0, 0, 0, 0},
+		{10, 3, 0, 0, 0},
+		{10, 3, 1, 0, 0},
+		{10, 3, 5, 2, 0.6},
+		{10, 3, 500, 0, 0},
+	}
+	for _, testCase := range testCases {
+		r := NewRegionInfo(&metapb.Region{Id: 100}, nil, SetReadBytes(testCase.bytes), SetReadKeys(testCase.keys), SetReportInterval(0, testCase.interval))
+		bytesRate, keysRate := r.GetReadRate()
+		re.Equal(testCase.expectBytesRate, bytesRate)
+		re.Equal(testCase.expectKeysRate, keysRate)
+	}
+}
+
 func TestNeedSync(t *testing.T) {
 	re := require.New(t)
 	RegionGuide := GenerateRegionGuideFunc(false)
@@ -410,7 +410,8 @@ func deleteOperatorByRegion(c *gin.Context) {
 
 // @Tags     operator
 // @Summary  lists the finished operators since the given timestamp in second.
-// @Param    from  query  integer  false  "From Unix timestamp"
+// @Param    from    query  integer  false  "From Unix timestamp"
+// @Param    object  query  bool     false  "Whether to return as versioned JSON object."
 // @Produce  json
 // @Success  200  {object}  []operator.OpRecord
 // @Failure  400  {string}  string  "The request is invalid."
@@ -423,12 +424,17 @@ func getOperatorRecords(c *gin.Context) {
 		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
+	_, objectFlag := c.GetQuery("object")
 	records, err := handler.GetRecords(from)
 	if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	c.IndentedJSON(http.StatusOK, records)
+	if objectFlag {
+		c.IndentedJSON(http.StatusOK, operator.VersionedRecords(records))
+	} else {
+		c.IndentedJSON(http.StatusOK, records)
+	}
 }
 
 // FIXME: details of input json body params
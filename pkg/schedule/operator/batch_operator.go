@@ -0,0 +1,103 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+// BatchOperator groups multiple operators which should be added, checked and
+// canceled together. The batch should be added to the controller by
+// Controller.AddBatchOperator, which admits all of the operators or none. Once
+// any started operator ends without success, the others are canceled with
+// BatchMemberFailed.
+type BatchOperator struct {
+	ops []*Operator
+}
+
+// NewBatchOperator creates a batch operator with the given operators.
+// The operators must not be started yet.
+func NewBatchOperator(ops ...*Operator) *BatchOperator {
+	b := &BatchOperator{ops: ops}
+	for _, op := range ops {
+		op := op
+		op.OnEnd(func(st OpStatus) {
+			// the operator which is not started is rejected by the admission,
+			// and the others are rejected along with it.
+			if st != SUCCESS && op.HasStarted() {
+				b.cancelOthers(op)
+			}
+		})
+	}
+	return b
+}
+
+func (b *BatchOperator) cancelOthers(failed *Operator) {
+	for _, op := range b.ops {
+		if op != failed {
+			_ = op.Cancel(BatchMemberFailed)
+		}
+	}
+}
+
+// Operators returns the operators in the batch.
+func (b *BatchOperator) Operators() []*Operator {
+	return b.ops
+}
+
+// Len returns the number of operators in the batch.
+func (b *BatchOperator) Len() int {
+	return len(b.ops)
+}
+
+// CheckSuccess returns true if all operators are succeeded.
+func (b *BatchOperator) CheckSuccess() bool {
+	success := len(b.ops) > 0
+	for _, op := range b.ops {
+		// Check every operator to update their status.
+		if !op.CheckSuccess() {
+			success = false
+		}
+	}
+	return success
+}
+
+// CheckTimeout returns true if any operator is timeout.
+func (b *BatchOperator) CheckTimeout() bool {
+	timeout := false
+	for _, op := range b.ops {
+		if op.CheckTimeout() || op.Status() == TIMEOUT {
+			timeout = true
+		}
+	}
+	return timeout
+}
+
+// Cancel cancels all operators in the batch. It returns true if any operator is canceled.
+func (b *BatchOperator) Cancel(reason CancelReasonType) bool {
+	canceled := false
+	for _, op := range b.ops {
+		if op.Cancel(reason) {
+			canceled = true
+		}
+	}
+	return canceled
+}
+
+// IsEnd returns true if all operators are at an end status.
+func (b *BatchOperator) IsEnd() bool {
+	for _, op := range b.ops {
+		if !op.IsEnd() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBatchOperator() *BatchOperator {
+	var ops []*Operator
+	for i := uint64(1); i <= 3; i++ {
+		ops = append(ops, NewTestOperator(i, &metapb.RegionEpoch{}, OpLeader, TransferLeader{FromStore: 1, ToStore: 2}))
+	}
+	return NewBatchOperator(ops...)
+}
+
+func TestBatchOperatorSuccess(t *testing.T) {
+	re := require.New(t)
+	batch := newTestBatchOperator()
+	re.Equal(3, batch.Len())
+	for _, op := range batch.Operators() {
+		re.True(op.Start())
+	}
+	re.False(batch.CheckSuccess())
+	re.False(batch.IsEnd())
+	for i, op := range batch.Operators() {
+		atomic.StoreInt32(&op.currentStep, 1)
+		if i < batch.Len()-1 {
+			re.False(batch.CheckSuccess())
+		}
+	}
+	re.True(batch.CheckSuccess())
+	re.True(batch.IsEnd())
+	re.False(batch.CheckTimeout())
+	re.False(batch.Cancel(AdminStop))
+}
+
+func TestBatchOperatorTimeoutAndCancel(t *testing.T) {
+	re := require.New(t)
+	batch := newTestBatchOperator()
+	for _, op := range batch.Operators() {
+		re.True(op.Start())
+	}
+	op := batch.Operators()[0]
+	op.SetStatusReachTime(STARTED, time.Now().Add(-op.Timeout()-time.Second))
+	re.True(batch.CheckTimeout())
+	re.True(batch.IsEnd())
+	re.False(batch.Cancel(AdminStop))
+	re.Equal(TIMEOUT, op.Status())
+	for _, op := range batch.Operators()[1:] {
+		re.Equal(CANCELED, op.Status())
+		re.Equal(BatchMemberFailed, op.GetCancelReason())
+	}
+
+	batch = newTestBatchOperator()
+	for _, op := range batch.Operators() {
+		re.True(op.Start())
+	}
+	re.True(batch.Operators()[1].Cancel(AdminStop))
+	re.True(batch.IsEnd())
+	re.Equal(AdminStop, batch.Operators()[1].GetCancelReason())
+	re.Equal(BatchMemberFailed, batch.Operators()[0].GetCancelReason())
+	re.Equal(BatchMemberFailed, batch.Operators()[2].GetCancelReason())
+
+	batch = newTestBatchOperator()
+	for _, op := range batch.Operators() {
+		re.True(op.Start())
+	}
+	re.True(batch.Cancel(AdminStop))
+	re.True(batch.IsEnd())
+	for _, op := range batch.Operators() {
+		re.Equal(CANCELED, op.Status())
+	}
+}
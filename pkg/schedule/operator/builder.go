@@ -55,12 +55,17 @@ type Builder struct {
 	targetPeers          peersMap
 	targetLeaderStoreID  uint64
 	targetLeaderStoreIDs []uint64 // This field is only used during multi-target evict leader, and will not be filtered during `Build`.
+	snapshotPriorities   map[uint64]SnapshotPriority
+	directDemotes        map[uint64]struct{}
 	err                  error
 
 	// skip check flags
 	skipOriginJointStateCheck bool
 	skipPlacementRulesCheck   bool
 
+	// validate flag
+	validateSteps bool
+
 	// build flags
 	useJointConsensus bool
 	removeLightPeer   bool
@@ -92,6 +97,11 @@ func SkipPlacementRulesCheck(b *Builder) {
 	b.skipPlacementRulesCheck = true
 }
 
+// ValidateSteps lets the builder check whether the built steps form a coherent sequence.
+func ValidateSteps(b *Builder) {
+	b.validateSteps = true
+}
+
 // NewBuilder creates a Builder.
 func NewBuilder(desc string, ci sche.SharedCluster, region *core.RegionInfo, opts ...BuilderOption) *Builder {
 	b := &Builder{
@@ -182,6 +192,28 @@ func (b *Builder) AddPeer(peer *metapb.Peer) *Builder {
 	return b
 }
 
+// AddPeerWithPriority records an add Peer operation with the snapshot priority in Builder.
+// The peer must be a voter, and it's added by a single AddPeerWithPriority step instead of
+// adding a learner and then promoting it, so it should only be used when the replicas need
+// to be rebuilt as soon as possible, such as after a store failure.
+func (b *Builder) AddPeerWithPriority(peer *metapb.Peer, priority SnapshotPriority) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if peer != nil && core.IsLearner(peer) {
+		b.err = errors.Errorf("cannot add peer %s with priority: is learner", peer)
+		return b
+	}
+	if b.AddPeer(peer); b.err != nil {
+		return b
+	}
+	if b.snapshotPriorities == nil {
+		b.snapshotPriorities = make(map[uint64]SnapshotPriority)
+	}
+	b.snapshotPriorities[peer.GetStoreId()] = priority
+	return b
+}
+
 // RemovePeer records a remove peer operation in Builder.
 func (b *Builder) RemovePeer(storeID uint64) *Builder {
 	if b.err != nil {
@@ -239,6 +271,20 @@ func (b *Builder) DemoteVoter(storeID uint64) *Builder {
 	return b
 }
 
+// DemoteVoterToLearner records a demote voter operation in Builder. When joint consensus
+// is not used, the voter will be demoted by a single DemoteVoterToLearner step instead of
+// removing it and adding a learner, so the demoted store keeps its replica data.
+func (b *Builder) DemoteVoterToLearner(storeID uint64) *Builder {
+	if b.DemoteVoter(storeID); b.err != nil {
+		return b
+	}
+	if b.directDemotes == nil {
+		b.directDemotes = make(map[uint64]struct{})
+	}
+	b.directDemotes[storeID] = struct{}{}
+	return b
+}
+
 // BecomeWitness records a switch to witness operation in Builder.
 func (b *Builder) BecomeWitness(storeID uint64) *Builder {
 	if b.err != nil {
@@ -408,8 +454,18 @@ func (b *Builder) Build(kind OpKind) (*Operator, error) {
 	if b.err != nil {
 		return nil, b.err
 	}
+	op := NewOperator(b.desc, brief, b.regionID, b.regionEpoch, kind, b.approximateSize, b.steps...)
+	if b.validateSteps {
+		if err := op.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return op, nil
+}
 
-	return NewOperator(b.desc, brief, b.regionID, b.regionEpoch, kind, b.approximateSize, b.steps...), nil
+func (b *Builder) isDirectDemote(storeID uint64) bool {
+	_, ok := b.directDemotes[storeID]
+	return ok
 }
 
 // Initialize intermediate states.
@@ -435,7 +491,8 @@ func (b *Builder) prepareBuild() (string, error) {
 
 	// Diff `originPeers` and `targetPeers` to initialize `toAdd`, `toRemove`, `toPromote`, `toDemote`,
 	// `toWitness`, `toNonWitness`, `toPromoteNonWitness`.
-	// Note: Use `toDemote` only when `useJointConsensus` is true. Otherwise use `toAdd`, `toRemove` instead.
+	// Note: Use `toDemote` only when `useJointConsensus` is true or the peer is demoted directly.
+	// Otherwise use `toAdd`, `toRemove` instead.
 	for _, o := range b.originPeers {
 		n := b.targetPeers[o.GetStoreId()]
 		if n == nil {
@@ -475,7 +532,7 @@ func (b *Builder) prepareBuild() (string, error) {
 			b.toPromote.Set(n)
 		} else if !isOriginPeerLearner && isTargetPeerLearner {
 			// voter -> learner
-			if b.useJointConsensus {
+			if b.useJointConsensus || b.isDirectDemote(o.GetStoreId()) {
 				b.toDemote.Set(n)
 			} else {
 				b.toRemove.Set(o)
@@ -486,7 +543,7 @@ func (b *Builder) prepareBuild() (string, error) {
 	for _, n := range b.targetPeers {
 		// old peer not exists, or target is learner while old one is voter.
 		o := b.originPeers[n.GetStoreId()]
-		if o == nil || (!b.useJointConsensus && !core.IsLearner(o) && core.IsLearner(n)) {
+		if o == nil || (!b.useJointConsensus && !b.isDirectDemote(n.GetStoreId()) && !core.IsLearner(o) && core.IsLearner(n)) {
 			if n.GetId() == 0 {
 				// Allocate peer ID if need.
 				id, err := b.AllocID()
@@ -573,7 +630,7 @@ func (b *Builder) buildStepsWithJointConsensus(kind OpKind) (OpKind, error) {
 	// Add all the peers as Learner first. Split `Add Voter` to `Add Learner + Promote`
 	for _, add := range b.toAdd.IDs() {
 		peer := b.toAdd[add]
-		if !core.IsLearner(peer) {
+		if _, ok := b.snapshotPriorities[peer.GetStoreId()]; !ok && !core.IsLearner(peer) {
 			b.execAddPeer(&metapb.Peer{
 				Id:        peer.GetId(),
 				StoreId:   peer.GetStoreId(),
@@ -731,6 +788,10 @@ func (b *Builder) buildStepsWithoutJointConsensus(kind OpKind) (OpKind, error) {
 			b.execTransferLeader(plan.leaderBeforeRemove, b.targetLeaderStoreIDs)
 			kind |= OpLeader
 		}
+		if plan.demote != nil {
+			b.execDemoteVoter(plan.demote)
+			kind |= OpRegion
+		}
 		if plan.remove != nil {
 			b.execRemovePeer(plan.remove)
 			kind |= OpRegion
@@ -782,6 +843,16 @@ func (b *Builder) execPromoteNonWitness(peer *metapb.Peer) {
 }
 
 func (b *Builder) execAddPeer(peer *metapb.Peer) {
+	if priority, ok := b.snapshotPriorities[peer.GetStoreId()]; ok && !core.IsLearner(peer) {
+		b.steps = append(b.steps, AddPeerWithPriority{
+			AddPeer:  AddPeer{ToStore: peer.GetStoreId(), PeerID: peer.GetId(), IsLightWeight: b.addLightPeer, IsWitness: peer.GetIsWitness()},
+			Priority: priority,
+		})
+		b.currentPeers.Set(peer)
+		b.peerAddStep[peer.GetStoreId()] = len(b.steps)
+		delete(b.toAdd, peer.GetStoreId())
+		return
+	}
 	b.steps = append(b.steps, AddLearner{ToStore: peer.GetStoreId(), PeerID: peer.GetId(), IsLightWeight: b.addLightPeer, IsWitness: peer.GetIsWitness(), SendStore: b.originLeaderStoreID})
 	if !core.IsLearner(peer) {
 		b.steps = append(b.steps, PromoteLearner{ToStore: peer.GetStoreId(), PeerID: peer.GetId(), IsWitness: peer.GetIsWitness()})
@@ -803,6 +874,12 @@ func (b *Builder) execRemovePeer(peer *metapb.Peer) {
 	delete(b.toRemove, removeStoreID)
 }
 
+func (b *Builder) execDemoteVoter(peer *metapb.Peer) {
+	b.steps = append(b.steps, DemoteVoterToLearner{StoreID: peer.GetStoreId()})
+	b.currentPeers.Set(peer)
+	delete(b.toDemote, peer.GetStoreId())
+}
+
 func (b *Builder) execChangePeerV2(needEnter bool, needTransferLeader bool) {
 	if len(b.toPromote)+len(b.toDemote) == 0 {
 		// No need to add empty enter / leave joint consensus step if no peer in `toPromote` and `toDemote`
@@ -119,6 +119,100 @@ func (suite *operatorBuilderTestSuite) TestRecord() {
 	re.True(builder.addLightPeer)
 }
 
+func (suite *operatorBuilderTestSuite) TestAddPeerWithPriority() {
+	re := suite.Require()
+	re.Error(suite.newBuilder().AddPeerWithPriority(&metapb.Peer{StoreId: 1}, SnapshotPriorityHigh).err)
+	re.Error(suite.newBuilder().AddPeerWithPriority(&metapb.Peer{StoreId: 4, Role: metapb.PeerRole_Learner}, SnapshotPriorityHigh).err)
+
+	for _, useJointConsensus := range []bool{false, true} {
+		builder := suite.newBuilder().AddPeerWithPriority(&metapb.Peer{Id: 14, StoreId: 4}, SnapshotPriorityHigh)
+		re.NoError(builder.err)
+		builder.useJointConsensus = useJointConsensus
+		op, err := builder.Build(0)
+		re.NoError(err)
+		re.Equal(1, op.Len())
+		re.Equal(AddPeerWithPriority{
+			AddPeer:  AddPeer{ToStore: 4, PeerID: 14},
+			Priority: SnapshotPriorityHigh,
+		}, op.Step(0))
+	}
+}
+
+func (suite *operatorBuilderTestSuite) TestDemoteVoterToLearner() {
+	re := suite.Require()
+	re.Error(suite.newBuilder().DemoteVoterToLearner(3).err)
+	re.Error(suite.newBuilder().DemoteVoterToLearner(4).err)
+
+	builder := suite.newBuilder().DemoteVoterToLearner(2)
+	re.NoError(builder.err)
+	builder.useJointConsensus = false
+	op, err := builder.Build(0)
+	re.NoError(err)
+	re.Equal(1, op.Len())
+	re.Equal(DemoteVoterToLearner{StoreID: 2}, op.Step(0))
+	re.Equal(OpRegion, op.Kind())
+
+	// the leader is transferred out before demoting.
+	builder = suite.newBuilder().DemoteVoterToLearner(1)
+	builder.useJointConsensus = false
+	op, err = builder.Build(0)
+	re.NoError(err)
+	re.Equal(2, op.Len())
+	re.Equal(TransferLeader{FromStore: 1, ToStore: 2}, op.Step(0))
+	re.Equal(DemoteVoterToLearner{StoreID: 1}, op.Step(1))
+
+	// without the direct demotion, the voter is removed and added back as a learner.
+	builder = suite.newBuilder().DemoteVoter(2)
+	builder.useJointConsensus = false
+	op, err = builder.Build(0)
+	re.NoError(err)
+	re.IsType(RemovePeer{}, op.Step(0))
+}
+
+func (suite *operatorBuilderTestSuite) TestBuildWitnessKind() {
+	re := suite.Require()
+	// OpWitness is not attached automatically, so the operator is still charged to the region limit.
+	op, err := suite.newBuilder().BecomeWitness(2).Build(0)
+	re.NoError(err)
+	re.Equal(OpRegion, op.Kind())
+
+	op, err = suite.newBuilder().BecomeWitness(2).Build(OpWitness)
+	re.NoError(err)
+	re.Equal(OpRegion|OpWitness, op.Kind())
+	re.Equal(OpRegion, op.SchedulerKind())
+}
+
+func (suite *operatorBuilderTestSuite) TestBuildAndMarshalBinary() {
+	re := suite.Require()
+	jointBuilder := suite.newBuilder().SetPeers(map[uint64]*metapb.Peer{
+		1: {StoreId: 1, Role: metapb.PeerRole_Learner},
+		3: {StoreId: 3},
+		4: {StoreId: 4, Id: 14},
+	}).SetLeader(3)
+	jointBuilder.useJointConsensus = true
+	priorityBuilder := suite.newBuilder().AddPeerWithPriority(&metapb.Peer{Id: 14, StoreId: 4}, SnapshotPriorityHigh).RemovePeer(2)
+	priorityBuilder.useJointConsensus = false
+	for _, builder := range []*Builder{
+		jointBuilder,
+		priorityBuilder,
+		suite.newBuilder().BecomeWitness(2),
+		suite.newBuilder().SetAddLightPeer().AddPeer(&metapb.Peer{Id: 14, StoreId: 4, IsWitness: true}),
+	} {
+		op, err := builder.Build(0)
+		re.NoError(err)
+		data, err := op.MarshalBinary()
+		re.NoError(err)
+		decoded := &Operator{}
+		re.NoError(decoded.UnmarshalBinary(data))
+		re.Equal(op.RegionEpoch(), decoded.RegionEpoch())
+		re.Equal(op.Kind(), decoded.Kind())
+		re.Equal(op.Len(), decoded.Len())
+		for i := 0; i < op.Len(); i++ {
+			re.True(op.Step(i).Equal(decoded.Step(i)), op.Step(i).String())
+		}
+	}
+}
+
 func (suite *operatorBuilderTestSuite) TestPrepareBuild() {
 	re := suite.Require()
 	// no voter.
@@ -540,7 +634,8 @@ func (suite *operatorBuilderTestSuite) TestBuild() {
 	for _, testCase := range testCases {
 		suite.T().Log(testCase.name)
 		region := core.NewRegionInfo(&metapb.Region{Id: 1, Peers: testCase.originPeers}, testCase.originPeers[0])
-		builder := NewBuilder("test", suite.cluster, region)
+		// The built steps are expected to form a coherent sequence.
+		builder := NewBuilder("test", suite.cluster, region, ValidateSteps)
 		builder.useJointConsensus = testCase.useJointConsensus
 		m := make(map[uint64]*metapb.Peer)
 		for _, p := range testCase.targetPeers {
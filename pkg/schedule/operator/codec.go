@@ -0,0 +1,723 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/tikv/pd/pkg/core/constant"
+)
+
+var (
+	stepTypesMu sync.RWMutex
+	// stepTypes records the step types which can be decoded, the key is the type name.
+	stepTypes = make(map[string]reflect.Type)
+)
+
+func init() {
+	for _, step := range []OpStep{
+		TransferLeader{},
+		TransferLeaderToCandidates{},
+		AddPeer{},
+		AddPeerWithPriority{},
+		AddLearner{},
+		PromoteLearner{},
+		DemoteVoterToLearner{},
+		RemovePeer{},
+		RemoveLearners{},
+		WaitUntilNotHot{},
+		MergeRegion{},
+		SplitRegion{},
+		BecomeWitness{},
+		BecomeNonWitness{},
+		BatchSwitchWitness{},
+		ChangePeerV2Enter{},
+		ChangePeerV2Leave{},
+	} {
+		RegisterStepType(step)
+	}
+}
+
+// RegisterStepType registers the type of the given step, so that the operator
+// containing it can be decoded. The step must be a struct value rather than a pointer.
+func RegisterStepType(step OpStep) {
+	typ := reflect.TypeOf(step)
+	stepTypesMu.Lock()
+	defer stepTypesMu.Unlock()
+	stepTypes[typ.Name()] = typ
+}
+
+func getStepType(name string) (reflect.Type, bool) {
+	stepTypesMu.RLock()
+	defer stepTypesMu.RUnlock()
+	typ, ok := stepTypes[name]
+	return typ, ok
+}
+
+type encodedStep struct {
+	Type string          `json:"type"`
+	Step json.RawMessage `json:"step"`
+}
+
+type encodedOperator struct {
+	ID               uint64                 `json:"id"`
+	Desc             string                 `json:"desc"`
+	Brief            string                 `json:"brief"`
+	RegionID         uint64                 `json:"region_id"`
+	RegionEpoch      *metapb.RegionEpoch    `json:"region_epoch,omitempty"`
+	Kind             OpKind                 `json:"kind"`
+	Level            constant.PriorityLevel `json:"level"`
+	ApproximateSize  int64                  `json:"approximate_size"`
+	Timeout          time.Duration          `json:"timeout"`
+	Deadline         int64                  `json:"deadline,omitempty"`
+	AdditionalInfos  map[string]string      `json:"additional_infos,omitempty"`
+	Source           string                 `json:"source,omitempty"`
+	Labels           map[string]string      `json:"labels,omitempty"`
+	GroupID          uint64                 `json:"group_id,omitempty"`
+	FreezeExempt     bool                   `json:"freeze_exempt"`
+	EpochCheckOnStep bool                   `json:"epoch_check_on_step,omitempty"`
+	ParallelGroups   [][]int                `json:"parallel_groups,omitempty"`
+	Steps            []encodedStep          `json:"steps"`
+}
+
+// Encode encodes the operator to bytes which can be decoded by DecodeOperator.
+// The status and the progress of the operator are not encoded.
+func (o *Operator) Encode() ([]byte, error) {
+	eo := encodedOperator{
+		ID:               o.id,
+		Desc:             o.desc,
+		Brief:            o.brief,
+		RegionID:         o.regionID,
+		RegionEpoch:      o.regionEpoch,
+		Kind:             o.kind,
+		Level:            o.level,
+		ApproximateSize:  o.ApproximateSize,
+		Timeout:          o.Timeout(),
+		Deadline:         atomic.LoadInt64(&o.deadline),
+		AdditionalInfos:  o.AdditionalInfos,
+		Source:           o.source,
+		Labels:           o.labels,
+		GroupID:          o.groupID,
+		FreezeExempt:     o.freezeExempt,
+		EpochCheckOnStep: o.epochCheckOnStep,
+		ParallelGroups:   o.parallelGroups,
+		Steps:            make([]encodedStep, 0, len(o.steps)),
+	}
+	for _, step := range o.steps {
+		name := reflect.TypeOf(step).Name()
+		if typ, ok := getStepType(name); !ok || typ != reflect.TypeOf(step) {
+			return nil, errors.Errorf("unregistered step type %T", step)
+		}
+		data, err := json.Marshal(step)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		eo.Steps = append(eo.Steps, encodedStep{Type: name, Step: data})
+	}
+	data, err := json.Marshal(eo)
+	return data, errors.WithStack(err)
+}
+
+// DecodeOperator decodes the operator encoded by Encode. The status of the
+// decoded operator is CREATED since its execution restarts, and it keeps the
+// ID of the encoded one.
+func DecodeOperator(data []byte) (*Operator, error) {
+	var eo encodedOperator
+	if err := json.Unmarshal(data, &eo); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	steps := make([]OpStep, 0, len(eo.Steps))
+	for _, es := range eo.Steps {
+		typ, ok := getStepType(es.Type)
+		if !ok {
+			return nil, errors.Errorf("unknown step type %s", es.Type)
+		}
+		step := reflect.New(typ)
+		if err := json.Unmarshal(es.Step, step.Interface()); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		steps = append(steps, step.Elem().Interface().(OpStep))
+	}
+	op := newOperator(eo.Desc, eo.Brief, eo.RegionID, eo.RegionEpoch, eo.Kind, eo.ApproximateSize, eo.Level, eo.Timeout, steps...)
+	if err := op.SetParallelGroups(eo.ParallelGroups); err != nil {
+		return nil, err
+	}
+	if eo.ID != 0 {
+		op.id = eo.ID
+		advanceOperatorID(eo.ID)
+	}
+	op.source = eo.Source
+	op.deadline = eo.Deadline
+	op.labels = eo.Labels
+	op.groupID = eo.GroupID
+	op.freezeExempt = eo.FreezeExempt
+	op.epochCheckOnStep = eo.EpochCheckOnStep
+	for k, v := range eo.AdditionalInfos {
+		op.AdditionalInfos[k] = v
+	}
+	return op, nil
+}
+
+// advanceOperatorID makes sure the IDs allocated later are greater than the given one.
+func advanceOperatorID(id uint64) {
+	for {
+		cur := atomic.LoadUint64(&operatorID)
+		if cur >= id || atomic.CompareAndSwapUint64(&operatorID, cur, id) {
+			return
+		}
+	}
+}
+
+const (
+	// binaryMagic is the leading byte of the binary format of the operator.
+	binaryMagic byte = 0xb7
+	// binaryVersion is the version of the binary format.
+	// NOTE: It must be bumped whenever the format is changed.
+	binaryVersion byte = 1
+)
+
+// The type codes of the steps in the binary format. The values must not be changed.
+const (
+	binaryTransferLeader byte = iota + 1
+	binaryTransferLeaderToCandidates
+	binaryAddPeer
+	binaryAddLearner
+	binaryPromoteLearner
+	binaryDemoteVoterToLearner
+	binaryRemovePeer
+	binaryRemoveLearners
+	binaryAddPeerWithPriority
+	binaryBecomeWitness
+	binaryBecomeNonWitness
+	binaryBatchSwitchWitness
+	binaryWaitUntilNotHot
+	binaryMergeRegion
+	binarySplitRegion
+	binaryChangePeerV2Enter
+	binaryChangePeerV2Leave
+)
+
+// The flags of the steps in the binary format.
+const (
+	binaryFlagLightWeight uint64 = 1 << iota
+	binaryFlagWitness
+	binaryFlagDownStore
+)
+
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *binaryWriter) varint(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *binaryWriter) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *binaryWriter) uvarints(vs []uint64) {
+	w.uvarint(uint64(len(vs)))
+	for _, v := range vs {
+		w.uvarint(v)
+	}
+}
+
+func (w *binaryWriter) bool(v bool) {
+	var b byte
+	if v {
+		b = 1
+	}
+	w.buf = append(w.buf, b)
+}
+
+func (w *binaryWriter) float64(v float64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(v))
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *binaryWriter) byteSlices(bs [][]byte) {
+	w.uvarint(uint64(len(bs)))
+	for _, b := range bs {
+		w.bytes(b)
+	}
+}
+
+func (w *binaryWriter) region(region *metapb.Region) error {
+	w.bool(region != nil)
+	if region == nil {
+		return nil
+	}
+	data, err := region.Marshal()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	w.bytes(data)
+	return nil
+}
+
+func (w *binaryWriter) promoteLearners(pls []PromoteLearner) {
+	w.uvarint(uint64(len(pls)))
+	for _, pl := range pls {
+		w.uvarint(pl.ToStore)
+		w.uvarint(pl.PeerID)
+		w.flags(false, pl.IsWitness, false)
+	}
+}
+
+func (w *binaryWriter) demoteVoters(dvs []DemoteVoter) {
+	w.uvarint(uint64(len(dvs)))
+	for _, dv := range dvs {
+		w.uvarint(dv.ToStore)
+		w.uvarint(dv.PeerID)
+		w.flags(false, dv.IsWitness, false)
+	}
+}
+
+func (w *binaryWriter) flags(lightWeight, witness, downStore bool) {
+	var flags uint64
+	if lightWeight {
+		flags |= binaryFlagLightWeight
+	}
+	if witness {
+		flags |= binaryFlagWitness
+	}
+	if downStore {
+		flags |= binaryFlagDownStore
+	}
+	w.uvarint(flags)
+}
+
+// binaryReader reads the binary format, the first error is kept and the following reads return zero.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) readByte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) == 0 {
+		r.err = errors.New("unexpected end of binary operator")
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errors.New("invalid uvarint in binary operator")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errors.New("invalid varint in binary operator")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) string() string {
+	return string(r.next(r.uvarint()))
+}
+
+// next returns the next n bytes, it returns nil if n is zero.
+func (r *binaryReader) next(n uint64) []byte {
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	if uint64(len(r.data)) < n {
+		r.err = errors.New("unexpected end of binary operator")
+		return nil
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// count reads the count of the following elements, each of which takes at least one byte.
+func (r *binaryReader) count() uint64 {
+	n := r.uvarint()
+	if r.err == nil && n > uint64(len(r.data)) {
+		r.err = errors.New("unexpected end of binary operator")
+	}
+	if r.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (r *binaryReader) uvarints() []uint64 {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+	vs := make([]uint64, n)
+	for i := range vs {
+		vs[i] = r.uvarint()
+	}
+	return vs
+}
+
+func (r *binaryReader) bool() bool {
+	switch b := r.readByte(); b {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		if r.err == nil {
+			r.err = errors.Errorf("invalid bool %d in binary operator", b)
+		}
+		return false
+	}
+}
+
+func (r *binaryReader) float64() float64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// bytes returns a copy of the next bytes, it returns nil if the length is zero.
+func (r *binaryReader) bytes() []byte {
+	b := r.next(r.uvarint())
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (r *binaryReader) byteSlices() [][]byte {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+	bs := make([][]byte, n)
+	for i := range bs {
+		bs[i] = r.bytes()
+	}
+	return bs
+}
+
+func (r *binaryReader) region() *metapb.Region {
+	if !r.bool() {
+		return nil
+	}
+	data := r.bytes()
+	if r.err != nil {
+		return nil
+	}
+	region := &metapb.Region{}
+	if err := region.Unmarshal(data); err != nil {
+		r.err = errors.WithStack(err)
+		return nil
+	}
+	return region
+}
+
+func (r *binaryReader) promoteLearners() []PromoteLearner {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+	pls := make([]PromoteLearner, n)
+	for i := range pls {
+		pls[i] = PromoteLearner{ToStore: r.uvarint(), PeerID: r.uvarint()}
+		_, pls[i].IsWitness, _ = r.flags()
+	}
+	return pls
+}
+
+func (r *binaryReader) demoteVoters() []DemoteVoter {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+	dvs := make([]DemoteVoter, n)
+	for i := range dvs {
+		dvs[i] = DemoteVoter{ToStore: r.uvarint(), PeerID: r.uvarint()}
+		_, dvs[i].IsWitness, _ = r.flags()
+	}
+	return dvs
+}
+
+// flags returns whether the light weight, the witness and the down store flags are set.
+func (r *binaryReader) flags() (lightWeight, witness, downStore bool) {
+	flags := r.uvarint()
+	return flags&binaryFlagLightWeight != 0, flags&binaryFlagWitness != 0, flags&binaryFlagDownStore != 0
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. It encodes the summary of the operator in a
+// compact format led by a magic byte and a version byte, which consists of the region, the kind,
+// the freeze exemption, the priority level, the size, the timeout and the steps. All the step types defined in this
+// package are supported, and the status is not encoded.
+func (o *Operator) MarshalBinary() ([]byte, error) {
+	w := &binaryWriter{buf: make([]byte, 0, 32+8*len(o.steps))}
+	w.buf = append(w.buf, binaryMagic, binaryVersion)
+	w.string(o.desc)
+	w.uvarint(o.regionID)
+	w.bool(o.regionEpoch != nil)
+	if o.regionEpoch != nil {
+		w.uvarint(o.regionEpoch.GetConfVer())
+		w.uvarint(o.regionEpoch.GetVersion())
+	}
+	w.uvarint(uint64(o.kind))
+	w.bool(o.freezeExempt)
+	w.uvarint(uint64(o.level))
+	w.varint(o.ApproximateSize)
+	w.varint(int64(o.Timeout()))
+	w.uvarint(uint64(len(o.steps)))
+	for _, step := range o.steps {
+		switch s := step.(type) {
+		case TransferLeader:
+			w.buf = append(w.buf, binaryTransferLeader)
+			w.uvarint(s.FromStore)
+			w.uvarint(s.ToStore)
+			w.uvarints(s.ToStores)
+		case TransferLeaderToCandidates:
+			w.buf = append(w.buf, binaryTransferLeaderToCandidates)
+			w.uvarint(s.FromStore)
+			w.uvarints(s.ToStores)
+		case AddPeer:
+			w.buf = append(w.buf, binaryAddPeer)
+			w.uvarint(s.ToStore)
+			w.uvarint(s.PeerID)
+			w.flags(s.IsLightWeight, s.IsWitness, false)
+		case AddLearner:
+			w.buf = append(w.buf, binaryAddLearner)
+			w.uvarint(s.ToStore)
+			w.uvarint(s.PeerID)
+			w.uvarint(s.SendStore)
+			w.flags(s.IsLightWeight, s.IsWitness, false)
+		case PromoteLearner:
+			w.buf = append(w.buf, binaryPromoteLearner)
+			w.uvarint(s.ToStore)
+			w.uvarint(s.PeerID)
+			w.flags(false, s.IsWitness, false)
+		case DemoteVoterToLearner:
+			w.buf = append(w.buf, binaryDemoteVoterToLearner)
+			w.uvarint(s.StoreID)
+		case RemovePeer:
+			w.buf = append(w.buf, binaryRemovePeer)
+			w.uvarint(s.FromStore)
+			w.uvarint(s.PeerID)
+			w.flags(s.IsLightWeight, false, s.IsDownStore)
+		case RemoveLearners:
+			w.buf = append(w.buf, binaryRemoveLearners)
+			w.uvarints(s.StoreIDs)
+		case AddPeerWithPriority:
+			w.buf = append(w.buf, binaryAddPeerWithPriority)
+			w.uvarint(s.ToStore)
+			w.uvarint(s.PeerID)
+			w.flags(s.IsLightWeight, s.IsWitness, false)
+			w.uvarint(uint64(s.Priority))
+		case BecomeWitness:
+			w.buf = append(w.buf, binaryBecomeWitness)
+			w.uvarint(s.PeerID)
+			w.uvarint(s.StoreID)
+		case BecomeNonWitness:
+			w.buf = append(w.buf, binaryBecomeNonWitness)
+			w.uvarint(s.PeerID)
+			w.uvarint(s.StoreID)
+			w.uvarint(s.SendStore)
+		case BatchSwitchWitness:
+			w.buf = append(w.buf, binaryBatchSwitchWitness)
+			w.uvarint(uint64(len(s.ToWitnesses)))
+			for _, bw := range s.ToWitnesses {
+				w.uvarint(bw.PeerID)
+				w.uvarint(bw.StoreID)
+			}
+			w.uvarint(uint64(len(s.ToNonWitnesses)))
+			for _, bn := range s.ToNonWitnesses {
+				w.uvarint(bn.PeerID)
+				w.uvarint(bn.StoreID)
+				w.uvarint(bn.SendStore)
+			}
+		case WaitUntilNotHot:
+			w.buf = append(w.buf, binaryWaitUntilNotHot)
+			w.varint(int64(s.MaxWait))
+			w.float64(s.MaxBytesRate)
+		case MergeRegion:
+			w.buf = append(w.buf, binaryMergeRegion)
+			if err := w.region(s.FromRegion); err != nil {
+				return nil, err
+			}
+			if err := w.region(s.ToRegion); err != nil {
+				return nil, err
+			}
+			w.bool(s.IsPassive)
+		case SplitRegion:
+			w.buf = append(w.buf, binarySplitRegion)
+			w.bytes(s.StartKey)
+			w.bytes(s.EndKey)
+			w.uvarint(uint64(s.Policy))
+			w.byteSlices(s.SplitKeys)
+		case ChangePeerV2Enter:
+			w.buf = append(w.buf, binaryChangePeerV2Enter)
+			w.promoteLearners(s.PromoteLearners)
+			w.demoteVoters(s.DemoteVoters)
+		case ChangePeerV2Leave:
+			w.buf = append(w.buf, binaryChangePeerV2Leave)
+			w.promoteLearners(s.PromoteLearners)
+			w.demoteVoters(s.DemoteVoters)
+		default:
+			return nil, errors.Errorf("step type %T is not supported by the binary format", step)
+		}
+	}
+	return w.buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It decodes the operator encoded by
+// MarshalBinary into a new operator with CREATED status, so the receiver must not be in use.
+func (o *Operator) UnmarshalBinary(data []byte) error {
+	r := &binaryReader{data: data}
+	if magic := r.readByte(); r.err == nil && magic != binaryMagic {
+		return errors.Errorf("invalid magic byte %#x of binary operator", magic)
+	}
+	if version := r.readByte(); r.err == nil && version != binaryVersion {
+		return errors.Errorf("unsupported version %d of binary operator", version)
+	}
+	desc := r.string()
+	regionID := r.uvarint()
+	var epoch *metapb.RegionEpoch
+	if r.bool() {
+		epoch = &metapb.RegionEpoch{ConfVer: r.uvarint(), Version: r.uvarint()}
+	}
+	kind := OpKind(r.uvarint())
+	freezeExempt := r.bool()
+	level := constant.PriorityLevel(r.uvarint())
+	size := r.varint()
+	timeout := time.Duration(r.varint())
+	count := r.count()
+	var steps []OpStep
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		switch code := r.readByte(); code {
+		case binaryTransferLeader:
+			steps = append(steps, TransferLeader{FromStore: r.uvarint(), ToStore: r.uvarint(), ToStores: r.uvarints()})
+		case binaryTransferLeaderToCandidates:
+			steps = append(steps, TransferLeaderToCandidates{FromStore: r.uvarint(), ToStores: r.uvarints()})
+		case binaryAddPeer:
+			s := AddPeer{ToStore: r.uvarint(), PeerID: r.uvarint()}
+			s.IsLightWeight, s.IsWitness, _ = r.flags()
+			steps = append(steps, s)
+		case binaryAddLearner:
+			s := AddLearner{ToStore: r.uvarint(), PeerID: r.uvarint(), SendStore: r.uvarint()}
+			s.IsLightWeight, s.IsWitness, _ = r.flags()
+			steps = append(steps, s)
+		case binaryPromoteLearner:
+			s := PromoteLearner{ToStore: r.uvarint(), PeerID: r.uvarint()}
+			_, s.IsWitness, _ = r.flags()
+			steps = append(steps, s)
+		case binaryDemoteVoterToLearner:
+			steps = append(steps, DemoteVoterToLearner{StoreID: r.uvarint()})
+		case binaryRemovePeer:
+			s := RemovePeer{FromStore: r.uvarint(), PeerID: r.uvarint()}
+			s.IsLightWeight, _, s.IsDownStore = r.flags()
+			steps = append(steps, s)
+		case binaryRemoveLearners:
+			steps = append(steps, RemoveLearners{StoreIDs: r.uvarints()})
+		case binaryAddPeerWithPriority:
+			s := AddPeerWithPriority{AddPeer: AddPeer{ToStore: r.uvarint(), PeerID: r.uvarint()}}
+			s.IsLightWeight, s.IsWitness, _ = r.flags()
+			s.Priority = SnapshotPriority(r.uvarint())
+			steps = append(steps, s)
+		case binaryBecomeWitness:
+			steps = append(steps, BecomeWitness{PeerID: r.uvarint(), StoreID: r.uvarint()})
+		case binaryBecomeNonWitness:
+			steps = append(steps, BecomeNonWitness{PeerID: r.uvarint(), StoreID: r.uvarint(), SendStore: r.uvarint()})
+		case binaryBatchSwitchWitness:
+			var s BatchSwitchWitness
+			for n := r.count(); n > 0; n-- {
+				s.ToWitnesses = append(s.ToWitnesses, BecomeWitness{PeerID: r.uvarint(), StoreID: r.uvarint()})
+			}
+			for n := r.count(); n > 0; n-- {
+				s.ToNonWitnesses = append(s.ToNonWitnesses, BecomeNonWitness{PeerID: r.uvarint(), StoreID: r.uvarint(), SendStore: r.uvarint()})
+			}
+			steps = append(steps, s)
+		case binaryWaitUntilNotHot:
+			steps = append(steps, WaitUntilNotHot{MaxWait: time.Duration(r.varint()), MaxBytesRate: r.float64()})
+		case binaryMergeRegion:
+			steps = append(steps, MergeRegion{FromRegion: r.region(), ToRegion: r.region(), IsPassive: r.bool()})
+		case binarySplitRegion:
+			steps = append(steps, SplitRegion{
+				StartKey:  r.bytes(),
+				EndKey:    r.bytes(),
+				Policy:    pdpb.CheckPolicy(r.uvarint()),
+				SplitKeys: r.byteSlices(),
+			})
+		case binaryChangePeerV2Enter:
+			steps = append(steps, ChangePeerV2Enter{PromoteLearners: r.promoteLearners(), DemoteVoters: r.demoteVoters()})
+		case binaryChangePeerV2Leave:
+			steps = append(steps, ChangePeerV2Leave{PromoteLearners: r.promoteLearners(), DemoteVoters: r.demoteVoters()})
+		default:
+			if r.err == nil {
+				r.err = errors.Errorf("unknown step type code %d of binary operator", code)
+			}
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.data) != 0 {
+		return errors.Errorf("%d trailing bytes of binary operator", len(r.data))
+	}
+	o.Reset()
+	o.desc, o.regionID, o.regionEpoch, o.kind, o.level = desc, regionID, epoch, kind, level
+	o.freezeExempt = freezeExempt
+	o.ApproximateSize, o.timeout = size, timeout
+	o.steps = append(o.steps, steps...)
+	o.stepsTime = append(o.stepsTime, make([]int64, len(steps))...)
+	o.stepsDispatched = append(o.stepsDispatched, make([]int32, len(steps))...)
+	o.stepsAttempts = append(o.stepsAttempts, make([]int32, len(steps))...)
+	o.cost = stepsCost(size, o.steps)
+	return nil
+}
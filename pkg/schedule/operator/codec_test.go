@@ -0,0 +1,230 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/pkg/core"
+	"github.com/tikv/pd/pkg/core/constant"
+)
+
+type unregisteredStep struct {
+	RemovePeer
+}
+
+func TestEncodeAndDecodeOperator(t *testing.T) {
+	re := require.New(t)
+	steps := []OpStep{
+		TransferLeader{FromStore: 1, ToStore: 2, ToStores: []uint64{2, 3}},
+		TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2, 3}},
+		AddPeer{ToStore: 4, PeerID: 4, IsLightWeight: true},
+		AddPeerWithPriority{AddPeer: AddPeer{ToStore: 5, PeerID: 5}, Priority: SnapshotPriorityHigh},
+		AddLearner{ToStore: 6, PeerID: 6, SendStore: 1},
+		PromoteLearner{ToStore: 6, PeerID: 6},
+		RemovePeer{FromStore: 6, PeerID: 6, IsDownStore: true},
+		MergeRegion{FromRegion: &metapb.Region{Id: 1}, ToRegion: &metapb.Region{Id: 2}, IsPassive: true},
+		SplitRegion{StartKey: []byte("a"), EndKey: []byte("z"), Policy: pdpb.CheckPolicy_USEKEY, SplitKeys: [][]byte{[]byte("m")}},
+		BatchSwitchWitness{
+			ToWitnesses:    []BecomeWitness{{PeerID: 2, StoreID: 2}},
+			ToNonWitnesses: []BecomeNonWitness{{PeerID: 3, StoreID: 3, SendStore: 1}},
+		},
+		ChangePeerV2Enter{
+			PromoteLearners: []PromoteLearner{{ToStore: 4, PeerID: 4}},
+			DemoteVoters:    []DemoteVoter{{ToStore: 2, PeerID: 2}},
+		},
+		ChangePeerV2Leave{
+			PromoteLearners: []PromoteLearner{{ToStore: 4, PeerID: 4}},
+			DemoteVoters:    []DemoteVoter{{ToStore: 2, PeerID: 2}},
+		},
+	}
+	op := NewOperator("test", "test", 1, &metapb.RegionEpoch{ConfVer: 2, Version: 3}, OpRegion|OpLeader|OpMerge, 100, steps...)
+	op.SetPriorityLevel(constant.High)
+	op.AdditionalInfos["foo"] = "bar"
+	op.SetSource("balance-region-scheduler")
+	op.SetLabel("tenant", "t1")
+	op.SetDeadline(time.Now().Add(time.Hour))
+	op.ExtendTimeout(time.Minute)
+	op.SetGroupID(42)
+	op.SetFreezeExempt(true)
+	op.SetEpochCheckOnStep(true)
+	re.NoError(op.SetParallelGroups([][]int{{2, 3}}))
+	re.True(op.Start())
+	op.Check(core.NewRegionInfo(&metapb.Region{Id: 1}, nil))
+
+	data, err := op.Encode()
+	re.NoError(err)
+	decoded, err := DecodeOperator(data)
+	re.NoError(err)
+	re.Equal(CREATED, decoded.Status())
+	re.Equal(op.GetID(), decoded.GetID())
+	re.Equal(op.Desc(), decoded.Desc())
+	re.Equal(op.brief, decoded.brief)
+	re.Equal(op.RegionID(), decoded.RegionID())
+	re.Equal(op.RegionEpoch(), decoded.RegionEpoch())
+	re.Equal(op.Kind(), decoded.Kind())
+	re.Equal(op.GetPriorityLevel(), decoded.GetPriorityLevel())
+	re.Equal(op.ApproximateSize, decoded.ApproximateSize)
+	re.Equal(op.Timeout(), decoded.Timeout())
+	re.Equal(op.AdditionalInfos, decoded.AdditionalInfos)
+	re.Equal(op.Source(), decoded.Source())
+	re.Equal(op.labels, decoded.labels)
+	re.True(op.GetDeadline().Equal(decoded.GetDeadline()))
+	re.Equal(op.GetGroupID(), decoded.GetGroupID())
+	re.True(decoded.IsFreezeExempt())
+	re.True(decoded.IsEpochCheckOnStep())
+	re.Equal(op.GetParallelGroups(), decoded.GetParallelGroups())
+	re.Equal(op.Len(), decoded.Len())
+	for i := 0; i < op.Len(); i++ {
+		re.Equal(op.Step(i), decoded.Step(i))
+	}
+
+	// the IDs allocated later are greater than the decoded one.
+	re.Greater(NewTestOperator(1, &metapb.RegionEpoch{}, OpRegion).GetID(), decoded.GetID())
+	// the freeze exemption is restored rather than recomputed by the kind.
+	op = NewTestOperator(1, &metapb.RegionEpoch{}, OpAdmin)
+	op.SetFreezeExempt(false)
+	data, err = op.Encode()
+	re.NoError(err)
+	decoded, err = DecodeOperator(data)
+	re.NoError(err)
+	re.False(decoded.IsFreezeExempt())
+	// the invalid parallel groups.
+	_, err = DecodeOperator([]byte(`{"parallel_groups":[[0]],"steps":[]}`))
+	re.Error(err)
+
+	// unknown step type.
+	op = NewTestOperator(1, &metapb.RegionEpoch{}, OpRegion, unregisteredStep{})
+	_, err = op.Encode()
+	re.Error(err)
+	_, err = DecodeOperator([]byte(`{"steps":[{"type":"unknownStep","step":{}}]}`))
+	re.Error(err)
+	_, err = DecodeOperator([]byte("invalid"))
+	re.Error(err)
+
+	RegisterStepType(unregisteredStep{})
+	defer func() {
+		stepTypesMu.Lock()
+		delete(stepTypes, "unregisteredStep")
+		stepTypesMu.Unlock()
+	}()
+	data, err = op.Encode()
+	re.NoError(err)
+	decoded, err = DecodeOperator(data)
+	re.NoError(err)
+	re.Equal(unregisteredStep{}, decoded.Step(0))
+}
+
+func TestMarshalAndUnmarshalBinary(t *testing.T) {
+	re := require.New(t)
+	steps := []OpStep{
+		TransferLeader{FromStore: 1, ToStore: 2, ToStores: []uint64{2, 3}},
+		TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2, 3}},
+		AddPeer{ToStore: 4, PeerID: 4, IsLightWeight: true},
+		AddLearner{ToStore: 6, PeerID: 6, SendStore: 1, IsWitness: true},
+		PromoteLearner{ToStore: 6, PeerID: 6},
+		DemoteVoterToLearner{StoreID: 2},
+		RemovePeer{FromStore: 6, PeerID: 6, IsDownStore: true},
+		RemoveLearners{StoreIDs: []uint64{7, 8}},
+		AddPeerWithPriority{AddPeer: AddPeer{ToStore: 9, PeerID: 9, IsWitness: true}, Priority: SnapshotPriorityHigh},
+		BecomeWitness{PeerID: 2, StoreID: 2},
+		BecomeNonWitness{PeerID: 3, StoreID: 3, SendStore: 1},
+		BatchSwitchWitness{
+			ToWitnesses:    []BecomeWitness{{PeerID: 2, StoreID: 2}},
+			ToNonWitnesses: []BecomeNonWitness{{PeerID: 3, StoreID: 3, SendStore: 1}},
+		},
+		WaitUntilNotHot{MaxWait: time.Minute, MaxBytesRate: 1.5},
+		MergeRegion{FromRegion: &metapb.Region{Id: 1, StartKey: []byte("a")}, ToRegion: &metapb.Region{Id: 2}, IsPassive: true},
+		SplitRegion{StartKey: []byte("a"), EndKey: []byte("z"), Policy: pdpb.CheckPolicy_USEKEY, SplitKeys: [][]byte{[]byte("m"), []byte("n")}},
+		ChangePeerV2Enter{
+			PromoteLearners: []PromoteLearner{{ToStore: 4, PeerID: 4, IsWitness: true}},
+			DemoteVoters:    []DemoteVoter{{ToStore: 2, PeerID: 2}},
+		},
+		ChangePeerV2Leave{
+			PromoteLearners: []PromoteLearner{{ToStore: 4, PeerID: 4, IsWitness: true}},
+			DemoteVoters:    []DemoteVoter{{ToStore: 2, PeerID: 2}},
+		},
+		DemoteVoterToLearner{StoreID: 3},
+	}
+	op := NewOperator("test", "test", 1, &metapb.RegionEpoch{ConfVer: 2, Version: 3}, OpRegion|OpLeader|OpMerge|OpSplit, 100, steps...)
+	op.SetPriorityLevel(constant.High)
+	re.True(op.Start())
+
+	data, err := op.MarshalBinary()
+	re.NoError(err)
+	re.Equal(binaryMagic, data[0])
+	re.Equal(binaryVersion, data[1])
+	encoded, err := op.Encode()
+	re.NoError(err)
+	re.Less(len(data), len(encoded))
+
+	decoded := &Operator{}
+	re.NoError(decoded.UnmarshalBinary(data))
+	re.Equal(CREATED, decoded.Status())
+	re.NotEqual(op.GetID(), decoded.GetID())
+	re.Equal(op.Desc(), decoded.Desc())
+	re.Equal(op.RegionID(), decoded.RegionID())
+	re.Equal(op.RegionEpoch(), decoded.RegionEpoch())
+	re.Equal(op.Kind(), decoded.Kind())
+	re.Equal(op.GetPriorityLevel(), decoded.GetPriorityLevel())
+	re.Equal(op.ApproximateSize, decoded.ApproximateSize)
+	re.Equal(op.Timeout(), decoded.Timeout())
+	re.Equal(steps, decoded.steps)
+	re.True(decoded.Start())
+	re.NotNil(decoded.Check(core.NewRegionInfo(&metapb.Region{Id: 1}, nil)))
+
+	// the nil epoch and the nil regions of the merge step are kept.
+	nilData, err := NewTestOperator(1, nil, OpMerge, MergeRegion{}).MarshalBinary()
+	re.NoError(err)
+	nilDecoded := &Operator{}
+	re.NoError(nilDecoded.UnmarshalBinary(nilData))
+	re.Nil(nilDecoded.RegionEpoch())
+	re.Equal(MergeRegion{}, nilDecoded.Step(0))
+
+	// the freeze exemption is kept.
+	for _, exempt := range []bool{true, false} {
+		adminOp := NewTestOperator(1, &metapb.RegionEpoch{}, OpAdmin|OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+		re.True(adminOp.IsFreezeExempt())
+		adminOp.SetFreezeExempt(exempt)
+		adminData, err := adminOp.MarshalBinary()
+		re.NoError(err)
+		adminDecoded := &Operator{}
+		re.NoError(adminDecoded.UnmarshalBinary(adminData))
+		re.Equal(exempt, adminDecoded.IsFreezeExempt())
+	}
+	re.False(decoded.IsFreezeExempt())
+
+	// the unsupported step.
+	op = NewTestOperator(1, &metapb.RegionEpoch{}, OpRegion, unregisteredStep{})
+	_, err = op.MarshalBinary()
+	re.Error(err)
+
+	// the invalid data.
+	re.Error(decoded.UnmarshalBinary(nil))
+	re.Error(decoded.UnmarshalBinary([]byte{0, binaryVersion}))
+	re.Error(decoded.UnmarshalBinary([]byte{binaryMagic, binaryVersion + 1}))
+	re.Error(decoded.UnmarshalBinary(data[:len(data)-1]))
+	re.Error(decoded.UnmarshalBinary(append(data, 0)))
+	// the last step is DemoteVoterToLearner, the type code is followed by the store ID.
+	unknown := append([]byte(nil), data...)
+	unknown[len(unknown)-2] = 0xff
+	re.Error(decoded.UnmarshalBinary(unknown))
+	// the decoded operator is not changed by the invalid data.
+	re.Equal(steps, decoded.steps)
+}
@@ -85,6 +85,28 @@ func CreateTransferLeaderOperator(desc string, ci sche.SharedCluster, region *co
 		Build(kind)
 }
 
+// CreateTransferLeaderToCandidatesOperator creates an operator that transfers the leader from a source store
+// to one of the candidate stores, the target is decided at execution time.
+func CreateTransferLeaderToCandidatesOperator(desc string, region *core.RegionInfo, sourceStoreID uint64, candidateStoreIDs []uint64, kind OpKind) (*Operator, error) {
+	if region.GetLeader().GetStoreId() != sourceStoreID {
+		return nil, errors.Errorf("the leader of region %d is not on store %d", region.GetID(), sourceStoreID)
+	}
+	candidates := make([]uint64, 0, len(candidateStoreIDs))
+	for _, storeID := range candidateStoreIDs {
+		peer := region.GetStoreVoter(storeID)
+		if storeID == sourceStoreID || peer == nil || peer.GetIsWitness() {
+			continue
+		}
+		candidates = append(candidates, storeID)
+	}
+	if len(candidates) == 0 {
+		return nil, errors.Errorf("no valid candidate to transfer leader of region %d", region.GetID())
+	}
+	step := TransferLeaderToCandidates{FromStore: sourceStoreID, ToStores: candidates}
+	brief := fmt.Sprintf("transfer leader: store %d to one of %v", sourceStoreID, candidates)
+	return NewOperator(desc, brief, region.GetID(), region.GetRegionEpoch(), kind|OpLeader, region.GetApproximateSize(), step), nil
+}
+
 // CreateForceTransferLeaderOperator creates an operator that transfers the leader from a source store to a target store forcible.
 func CreateForceTransferLeaderOperator(desc string, ci sche.SharedCluster, region *core.RegionInfo, sourceStoreID uint64, targetStoreID uint64, kind OpKind) (*Operator, error) {
 	return NewBuilder(desc, ci, region, SkipOriginJointStateCheck, SkipPlacementRulesCheck).
@@ -443,6 +443,27 @@ func (suite *createOperatorTestSuite) TestCreateTransferLeaderOperator() {
 	}
 }
 
+func (suite *createOperatorTestSuite) TestCreateTransferLeaderToCandidatesOperator() {
+	re := suite.Require()
+	peers := []*metapb.Peer{
+		{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+		{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+		{Id: 3, StoreId: 3, Role: metapb.PeerRole_Voter},
+		{Id: 4, StoreId: 4, Role: metapb.PeerRole_Learner},
+	}
+	region := core.NewRegionInfo(&metapb.Region{Id: 1, Peers: peers}, peers[0])
+	op, err := CreateTransferLeaderToCandidatesOperator("test", region, 1, []uint64{1, 2, 3, 4, 5}, 0)
+	re.NoError(err)
+	re.Equal(OpLeader, op.Kind())
+	re.Len(op.steps, 1)
+	re.Equal(TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2, 3}}, op.Step(0))
+
+	_, err = CreateTransferLeaderToCandidatesOperator("test", region, 2, []uint64{3}, 0)
+	re.Error(err)
+	_, err = CreateTransferLeaderToCandidatesOperator("test", region, 1, []uint64{1, 4}, 0)
+	re.Error(err)
+}
+
 func (suite *createOperatorTestSuite) TestCreateLeaveJointStateOperator() {
 	re := suite.Require()
 	type testCase struct {
@@ -15,6 +15,8 @@
 package operator
 
 import (
+	"math"
+
 	"github.com/tikv/pd/pkg/core"
 	"github.com/tikv/pd/pkg/core/constant"
 	"github.com/tikv/pd/pkg/core/storelimit"
@@ -32,6 +34,17 @@ func NewOpInfluence() *OpInfluence {
 	}
 }
 
+// AccumulateUnfinishedInfluence adds the influence of the unfinished steps of the operators
+// into opInfluence. The region of each operator is got by the given function, and the
+// operator is skipped if its region is nil.
+func AccumulateUnfinishedInfluence(ops []*Operator, opInfluence OpInfluence, regions func(uint64) *core.RegionInfo) {
+	for _, op := range ops {
+		if region := regions(op.RegionID()); region != nil {
+			op.UnfinishedInfluence(opInfluence, region)
+		}
+	}
+}
+
 // Add adds another influence.
 func (m *OpInfluence) Add(other *OpInfluence) {
 	for id, v := range other.StoresInfluence {
@@ -39,6 +52,63 @@ func (m *OpInfluence) Add(other *OpInfluence) {
 	}
 }
 
+func (m *OpInfluence) clone() *OpInfluence {
+	c := &OpInfluence{StoresInfluence: make(map[uint64]*StoreInfluence, len(m.StoresInfluence))}
+	for id, s := range m.StoresInfluence {
+		cs := *s
+		if s.StepCost != nil {
+			cs.StepCost = make(map[storelimit.Type]int64, len(s.StepCost))
+			for k, v := range s.StepCost {
+				cs.StepCost[k] = v
+			}
+		}
+		c.StoresInfluence[id] = &cs
+	}
+	return c
+}
+
+// influenceTolerance is the relative tolerance of the size and the step cost when matching influences.
+const influenceTolerance = 0.01
+
+// matchDelta checks whether the influence matches the delta from before to after. The counts
+// must be the same, and the sizes and the step costs can differ within influenceTolerance.
+func (m OpInfluence) matchDelta(before, after OpInfluence) bool {
+	stores := make(map[uint64]struct{})
+	for _, inf := range []OpInfluence{m, before, after} {
+		for id := range inf.StoresInfluence {
+			stores[id] = struct{}{}
+		}
+	}
+	for id := range stores {
+		s, b, a := m.storeInfluence(id), before.storeInfluence(id), after.storeInfluence(id)
+		if s.RegionCount != a.RegionCount-b.RegionCount ||
+			s.LeaderCount != a.LeaderCount-b.LeaderCount ||
+			s.WitnessCount != a.WitnessCount-b.WitnessCount ||
+			!withinTolerance(s.RegionSize, a.RegionSize-b.RegionSize) ||
+			!withinTolerance(s.LeaderSize, a.LeaderSize-b.LeaderSize) {
+			return false
+		}
+		for _, v := range storelimit.TypeNameValue {
+			if !withinTolerance(s.GetStepCost(v), a.GetStepCost(v)-b.GetStepCost(v)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// storeInfluence is like GetStoreInfluence, but it doesn't create the missing one.
+func (m OpInfluence) storeInfluence(id uint64) *StoreInfluence {
+	if storeInfluence, ok := m.StoresInfluence[id]; ok {
+		return storeInfluence
+	}
+	return &StoreInfluence{}
+}
+
+func withinTolerance(expected, actual int64) bool {
+	return math.Abs(float64(expected-actual)) <= math.Abs(float64(expected))*influenceTolerance
+}
+
 // GetStoreInfluence get storeInfluence of specific store.
 func (m OpInfluence) GetStoreInfluence(id uint64) *StoreInfluence {
 	storeInfluence, ok := m.StoresInfluence[id]
@@ -27,6 +27,8 @@ const OpWaiting = "waiting"
 type OpKind uint32
 
 // Flags for operators.
+// The lower bit has the higher order, and the lowest bit of the kind is the scheduler kind of the operator.
+// NOTE: The values are exposed by the HTTP API, so the new flag must be appended before opMax.
 const (
 	// Initiated by admin.
 	OpAdmin OpKind = 1 << iota
@@ -74,6 +76,7 @@ var nameToFlag = map[string]OpKind{
 	"replica":        OpReplica,
 	"merge":          OpMerge,
 	"range":          OpRange,
+	"witness":        OpWitness,
 	"witness-leader": OpWitnessLeader,
 }
 
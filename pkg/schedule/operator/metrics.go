@@ -70,6 +70,60 @@ var (
 			Buckets:   []float64{0.5, 1, 2, 4, 8, 16, 20, 40, 60, 90, 120, 180, 240, 300, 480, 600, 720, 900, 1200, 1800, 3600},
 		}, []string{"type"})
 
+	operatorKindDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "pd",
+			Subsystem: "schedule",
+			Name:      "finish_operators_duration_by_kind_seconds",
+			Help:      "Bucketed histogram of processing time (s) of ended operator by kind.",
+			Buckets:   []float64{0.5, 1, 2, 4, 8, 16, 20, 40, 60, 90, 120, 180, 240, 300, 480, 600, 720, 900, 1200, 1800, 3600},
+		}, []string{"kind"})
+
+	// operatorStepDurationByLabels and operatorKindDurationByLabels are only observed by the operators
+	// with const labels, and the labels are encoded into the "labels" label.
+	operatorStepDurationByLabels = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "pd",
+			Subsystem: "schedule",
+			Name:      "finish_operator_steps_duration_by_labels_seconds",
+			Help:      "Bucketed histogram of processing time (s) of finished operator step by the const labels of operator.",
+			Buckets:   []float64{0.5, 1, 2, 4, 8, 16, 20, 40, 60, 90, 120, 180, 240, 300, 480, 600, 720, 900, 1200, 1800, 3600},
+		}, []string{"type", "labels"})
+
+	operatorKindDurationByLabels = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "pd",
+			Subsystem: "schedule",
+			Name:      "finish_operators_duration_by_kind_labels_seconds",
+			Help:      "Bucketed histogram of processing time (s) of ended operator by kind and the const labels of operator.",
+			Buckets:   []float64{0.5, 1, 2, 4, 8, 16, 20, 40, 60, 90, 120, 180, 240, 300, 480, 600, 720, 900, 1200, 1800, 3600},
+		}, []string{"kind", "labels"})
+
+	operatorQueueTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "pd",
+			Subsystem: "schedule",
+			Name:      "operator_queue_duration_seconds",
+			Help:      "Bucketed histogram of the time (s) from an operator is created to it is started.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16), // 1ms~32s
+		}, []string{"kind"})
+
+	operatorLongStepsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd",
+			Subsystem: "schedule",
+			Name:      "long_steps_operators_count",
+			Help:      "Counter of created operators whose count of steps exceeds the threshold.",
+		}, []string{"type"})
+
+	operatorCanceledCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pd",
+			Subsystem: "schedule",
+			Name:      "canceled_operators_count",
+			Help:      "Counter of canceled operators by reason.",
+		}, []string{"reason"})
+
 	operatorSizeHist = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "pd",
@@ -86,6 +140,12 @@ func init() {
 	prometheus.MustRegister(OperatorExceededStoreLimitCounter)
 	prometheus.MustRegister(operatorCounter)
 	prometheus.MustRegister(operatorDuration)
+	prometheus.MustRegister(operatorKindDuration)
+	prometheus.MustRegister(operatorStepDurationByLabels)
+	prometheus.MustRegister(operatorKindDurationByLabels)
 	prometheus.MustRegister(operatorSizeHist)
+	prometheus.MustRegister(operatorCanceledCounter)
+	prometheus.MustRegister(operatorLongStepsCounter)
+	prometheus.MustRegister(operatorQueueTime)
 	prometheus.MustRegister(storeLimitCostCounter)
 }
@@ -15,18 +15,27 @@
 package operator
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/tikv/pd/pkg/core"
 	"github.com/tikv/pd/pkg/core/constant"
+	"github.com/tikv/pd/pkg/utils/logutil"
+	"github.com/tikv/pd/pkg/utils/syncutil"
 )
 
 const (
@@ -34,8 +43,37 @@ const (
 	// after it, the operator will be considered expired.
 	OperatorExpireTime = 3 * time.Second
 	cancelReason       = "cancel-reason"
+	// timeoutExtendedTimes and timeoutExtended record the history of ExtendTimeout.
+	timeoutExtendedTimes = "timeout-extended-times"
+	timeoutExtended      = "timeout-extended"
+	// timeoutChanged records the change of the timeout by SetTimeout after the operator is started.
+	timeoutChanged = "timeout-changed"
+	// replacedByRegion and replacedByKind record the operator which replaces this one.
+	replacedByRegion = "replaced-by-region"
+	replacedByKind   = "replaced-by-kind"
+	// stepsCount records the count of steps if it exceeds LongStepsThreshold.
+	stepsCount = "steps-count"
 )
 
+// LongStepsThreshold is the count of steps that a sane operator should not exceed.
+// The operator with more steps is counted by the metrics when it is created.
+var LongStepsThreshold = 10
+
+var (
+	// StepAttemptInterval is the expected duration of an attempt of a step. The attempts of the
+	// current step are increased if Check finds it unfinished after each interval.
+	StepAttemptInterval = 20 * time.Second
+	// MaxStepAttempts is the max attempts of a step. The operator is canceled with StepRetryExhausted
+	// if the step is still unfinished after the attempts are exceeded. Zero means no limit.
+	MaxStepAttempts = 0
+	// AgeBoostThreshold is the elapsed time after which the priority level of an operator is boosted
+	// by one level, so that the long-queued operators are not starved. Zero means no boost.
+	AgeBoostThreshold time.Duration
+)
+
+// operatorID is used to allocate the ID of operators, which increases monotonically.
+var operatorID uint64
+
 // CancelReasonType is the type of cancel reason.
 type CancelReasonType string
 
@@ -64,13 +102,56 @@ var (
 	ExceedWaitLimit CancelReasonType = "exceed wait limit"
 	// RelatedMergeRegion is the cancel reason when the operator is cancelled by related merge region.
 	RelatedMergeRegion CancelReasonType = "related merge region"
+	// StoreCapacityExceeded is the cancel reason when the target store of the operator runs out of space.
+	StoreCapacityExceeded CancelReasonType = "store capacity exceeded"
+	// DependencyFailed is the cancel reason when the operator which this operator depends on is not succeeded.
+	DependencyFailed CancelReasonType = "dependency failed"
+	// SoftCanceled is the cancel reason when the operator is canceled by SoftCancel after the in-flight step finished.
+	SoftCanceled CancelReasonType = "soft canceled"
+	// StepRetryExhausted is the cancel reason when a step is still unfinished after the max attempts.
+	StepRetryExhausted CancelReasonType = "step retry exhausted"
+	// ContextCanceled is the cancel reason when the context bound by WithContext is done.
+	ContextCanceled CancelReasonType = "context canceled"
+	// BatchMemberFailed is the cancel reason when another operator in the same batch ends without success.
+	BatchMemberFailed CancelReasonType = "batch member failed"
 	// Unknown is the cancel reason when the operator is cancelled by an unknown reason.
 	Unknown CancelReasonType = "unknown"
 )
 
+// cancelReasonLabels is the closed set of the cancel reasons used as the metrics label.
+var cancelReasonLabels = map[CancelReasonType]struct{}{
+	RegionNotFound:        {},
+	EpochNotMatch:         {},
+	AlreadyExist:          {},
+	AdminStop:             {},
+	NotInRunningState:     {},
+	Timeout:               {},
+	Expired:               {},
+	NotInCreateStatus:     {},
+	StaleStatus:           {},
+	ExceedStoreLimit:      {},
+	ExceedWaitLimit:       {},
+	RelatedMergeRegion:    {},
+	StoreCapacityExceeded: {},
+	DependencyFailed:      {},
+	SoftCanceled:          {},
+	StepRetryExhausted:    {},
+	ContextCanceled:       {},
+	BatchMemberFailed:     {},
+	Unknown:               {},
+}
+
+func cancelReasonLabel(reason CancelReasonType) string {
+	if _, ok := cancelReasonLabels[reason]; ok {
+		return string(reason)
+	}
+	return string(Unknown)
+}
+
 // Operator contains execution steps generated by scheduler.
 // NOTE: This type is exported by HTTP API. Please pay more attention when modifying it.
 type Operator struct {
+	id               uint64
 	desc             string
 	brief            string
 	regionID         uint64
@@ -78,7 +159,11 @@ type Operator struct {
 	kind             OpKind
 	steps            []OpStep
 	stepsTime        []int64 // step finish time
+	stepsDispatched  []int32 // whether the step has been returned by Check
+	stepsAttempts    []int32
 	currentStep      int32
+	softCanceled     int32
+	pausedByFreeze   int32
 	status           OpStatusTracker
 	level            constant.PriorityLevel
 	Counters         []prometheus.Counter
@@ -86,7 +171,23 @@ type Operator struct {
 	AdditionalInfos  map[string]string
 	ApproximateSize  int64
 	timeout          time.Duration
+	cost             int   // snapshot-equivalent tokens consumed when admitted, see Cost
+	deadline         int64 // unix nano of the wall-clock deadline, zero means no deadline
 	influence        *OpInfluence
+	plannedInfluence *OpInfluence
+	cancelReason     atomic.Value // Store as CancelReasonType, set along with the CANCELED status
+	dependency       *Operator
+	source           string
+	groupID          uint64
+	freezeExempt     bool
+	epochCheckOnStep bool // whether to check the region epoch before each step, see CheckEpochBeforeStep
+	parallelGroups   [][]int
+	labels           map[string]string
+	constLabels      string       // encoded const labels of metrics, see AttachConstLabels
+	confVerCache     atomic.Value // Store as *confVerCache
+
+	callbackMu     syncutil.RWMutex
+	onStepFinished []func(stepIndex int, step OpStep)
 }
 
 // NewOperator creates a new operator.
@@ -95,11 +196,83 @@ func NewOperator(desc, brief string, regionID uint64, regionEpoch *metapb.Region
 	if kind&OpAdmin != 0 {
 		level = constant.Urgent
 	}
+	return newOperator(desc, brief, regionID, regionEpoch, kind, approximateSize, level,
+		stepsTimeout(approximateSize, steps), steps...)
+}
+
+func stepsTimeout(approximateSize int64, steps []OpStep) time.Duration {
 	maxDuration := float64(0)
 	for _, v := range steps {
 		maxDuration += v.Timeout(approximateSize).Seconds()
 	}
-	return &Operator{
+	return time.Duration(maxDuration) * time.Second
+}
+
+// stepsCost sums the rate limiting cost of the steps, see Operator.Cost.
+func stepsCost(approximateSize int64, steps []OpStep) int {
+	var cost int64
+	for _, v := range steps {
+		cost += stepCost(v, approximateSize)
+	}
+	return int(cost)
+}
+
+// stepCost returns the rate limiting cost of the step. The step sending a snapshot
+// costs the snapshot cost, which is at least 1, and the others only change the
+// metadata so they cost zero.
+func stepCost(step OpStep, approximateSize int64) int64 {
+	switch s := step.(type) {
+	case AddPeer:
+		if s.IsLightWeight || s.IsWitness {
+			return 0
+		}
+	case AddPeerWithPriority:
+		if s.IsLightWeight || s.IsWitness {
+			return 0
+		}
+	case AddLearner:
+		if s.IsLightWeight || s.IsWitness {
+			return 0
+		}
+	case BecomeNonWitness:
+	case BatchSwitchWitness:
+		var cost int64
+		for _, nw := range s.ToNonWitnesses {
+			cost += stepCost(nw, approximateSize)
+		}
+		return cost
+	default:
+		return 0
+	}
+	return snapshotStepCost(approximateSize)
+}
+
+// NewOperatorWithTimeouts creates a new operator, the non-zero timeout in stepTimeouts
+// overrides the timeout of the step with the same index.
+// The length of stepTimeouts must be zero or equal to the length of steps.
+func NewOperatorWithTimeouts(desc, brief string, regionID uint64, regionEpoch *metapb.RegionEpoch, kind OpKind, approximateSize int64, stepTimeouts []time.Duration, steps ...OpStep) (*Operator, error) {
+	if len(stepTimeouts) != 0 && len(stepTimeouts) != len(steps) {
+		return nil, errors.Errorf("the count of step timeouts %d does not match the count of steps %d", len(stepTimeouts), len(steps))
+	}
+	level := constant.Medium
+	if kind&OpAdmin != 0 {
+		level = constant.Urgent
+	}
+	var timeout time.Duration
+	for i, v := range steps {
+		if len(stepTimeouts) != 0 && stepTimeouts[i] != 0 {
+			timeout += stepTimeouts[i]
+			continue
+		}
+		timeout += v.Timeout(approximateSize)
+	}
+	return newOperator(desc, brief, regionID, regionEpoch, kind, approximateSize, level, timeout, steps...), nil
+}
+
+func newOperator(desc, brief string, regionID uint64, regionEpoch *metapb.RegionEpoch, kind OpKind, approximateSize int64,
+	level constant.PriorityLevel, timeout time.Duration, steps ...OpStep) *Operator {
+	op := &Operator{
+		id:              atomic.AddUint64(&operatorID, 1),
 		desc:            desc,
 		brief:           brief,
 		regionID:        regionID,
@@ -107,43 +280,317 @@ func NewOperator(desc, brief string, regionID uint64, regionEpoch *metapb.Region
 		kind:            kind,
 		steps:           steps,
 		stepsTime:       make([]int64, len(steps)),
+		stepsDispatched: make([]int32, len(steps)),
+		stepsAttempts:   make([]int32, len(steps)),
 		status:          NewOpStatusTracker(),
 		level:           level,
 		AdditionalInfos: make(map[string]string),
 		ApproximateSize: approximateSize,
-		timeout:         time.Duration(maxDuration) * time.Second,
+		timeout:         timeout,
+		cost:            stepsCost(approximateSize, steps),
+		freezeExempt:    kind&(OpAdmin|OpReplica) != 0,
+	}
+	if len(steps) > LongStepsThreshold {
+		operatorLongStepsCounter.WithLabelValues(desc).Inc()
+		op.SetAdditionalInfoInt(stepsCount, int64(len(steps)))
+	}
+	return op
+}
+
+// Reset resets the operator to an empty operator with CREATED status, so that it can be reused,
+// such as by a sync.Pool. The buffers of the steps and the additional infos are kept.
+// NOTE: It must be called only when the operator is at an end status and it is no longer
+// referenced by anyone, such as the operator controller, the records and other operators.
+func (o *Operator) Reset() {
+	clear(o.steps)
+	clear(o.AdditionalInfos)
+	steps, stepsTime, stepsDispatched, stepsAttempts := o.steps[:0], o.stepsTime[:0], o.stepsDispatched[:0], o.stepsAttempts[:0]
+	additionalInfos := o.AdditionalInfos
+	if additionalInfos == nil {
+		additionalInfos = make(map[string]string)
+	}
+	*o = Operator{
+		id:              atomic.AddUint64(&operatorID, 1),
+		steps:           steps,
+		stepsTime:       stepsTime,
+		stepsDispatched: stepsDispatched,
+		stepsAttempts:   stepsAttempts,
+		status:          NewOpStatusTracker(),
+		level:           constant.Medium,
+		AdditionalInfos: additionalInfos,
 	}
 }
 
 // Sync some attribute with the given timeout.
 func (o *Operator) Sync(other *Operator) {
-	o.timeout = other.timeout
+	atomic.StoreInt64((*int64)(&o.timeout), int64(other.Timeout()))
 	o.AdditionalInfos[string(RelatedMergeRegion)] = strconv.FormatUint(other.RegionID(), 10)
 	other.AdditionalInfos[string(RelatedMergeRegion)] = strconv.FormatUint(o.RegionID(), 10)
 }
 
+// MergeAdditionalInfos merges the additional infos of the given operator into this one.
+// The value of the given operator is preferred if a key exists in both of them.
+func (o *Operator) MergeAdditionalInfos(other *Operator) {
+	if other == nil || len(other.AdditionalInfos) == 0 {
+		return
+	}
+	if o.AdditionalInfos == nil {
+		o.AdditionalInfos = make(map[string]string, len(other.AdditionalInfos))
+	}
+	for k, v := range other.AdditionalInfos {
+		o.AdditionalInfos[k] = v
+	}
+}
+
+// Clone returns a deep copy of the operator, the status of the copy is reset to CREATED and
+// so is the progress, that is none of the steps is finished or dispatched. The dependency is
+// cloned as well. It's safe to check the copy concurrently with the original operator.
+func (o *Operator) Clone() *Operator {
+	steps := make([]OpStep, len(o.steps))
+	copy(steps, o.steps)
+	var planned *OpInfluence
+	if o.plannedInfluence != nil {
+		planned = o.plannedInfluence.clone()
+	}
+	var dependency *Operator
+	if o.dependency != nil {
+		dependency = o.dependency.Clone()
+	}
+	var parallelGroups [][]int
+	for _, group := range o.parallelGroups {
+		parallelGroups = append(parallelGroups, append([]int(nil), group...))
+	}
+	additionalInfos := make(map[string]string, len(o.AdditionalInfos))
+	for k, v := range o.AdditionalInfos {
+		additionalInfos[k] = v
+	}
+	var labels map[string]string
+	if len(o.labels) > 0 {
+		labels = make(map[string]string, len(o.labels))
+		for k, v := range o.labels {
+			labels[k] = v
+		}
+	}
+	clone := &Operator{
+		id:               atomic.AddUint64(&operatorID, 1),
+		desc:             o.desc,
+		brief:            o.brief,
+		regionID:         o.regionID,
+		regionEpoch:      o.regionEpoch,
+		kind:             o.kind,
+		steps:            steps,
+		stepsTime:        make([]int64, len(steps)),
+		stepsDispatched:  make([]int32, len(steps)),
+		stepsAttempts:    make([]int32, len(steps)),
+		status:           NewOpStatusTracker(),
+		level:            o.level,
+		Counters:         append([]prometheus.Counter(nil), o.Counters...),
+		FinishedCounters: append([]prometheus.Counter(nil), o.FinishedCounters...),
+		AdditionalInfos:  additionalInfos,
+		ApproximateSize:  o.ApproximateSize,
+		timeout:          o.Timeout(),
+		cost:             o.cost,
+		deadline:         atomic.LoadInt64(&o.deadline),
+		dependency:       dependency,
+		plannedInfluence: planned,
+		source:           o.source,
+		groupID:          o.groupID,
+		freezeExempt:     o.freezeExempt,
+		epochCheckOnStep: o.epochCheckOnStep,
+		parallelGroups:   parallelGroups,
+		labels:           labels,
+		constLabels:      o.constLabels,
+	}
+	if reason := o.GetCancelReason(); len(reason) != 0 {
+		clone.cancelReason.Store(reason)
+	}
+	return clone
+}
+
 func (o *Operator) String() string {
 	stepStrs := make([]string, len(o.steps))
 	for i := range o.steps {
 		stepStrs[i] = fmt.Sprintf("%d:{%s}", i, o.steps[i].String())
 	}
-	s := fmt.Sprintf("%s {%s} (kind:%s, region:%v(%v, %v), createAt:%s, startAt:%s, currentStep:%v, size:%d, steps:[%s], timeout:[%s])",
-		o.desc, o.brief, o.kind, o.regionID, o.regionEpoch.GetVersion(), o.regionEpoch.GetConfVer(), o.GetCreateTime(),
-		o.GetStartTime(), atomic.LoadInt32(&o.currentStep), o.ApproximateSize, strings.Join(stepStrs, ", "), o.timeout.String())
+	s := fmt.Sprintf("%s {%s} (kind:%s, region:%v(%v, %v), id:%d, createAt:%s, startAt:%s, currentStep:%v, size:%d, steps:[%s], timeout:[%s])",
+		o.desc, o.brief, o.kind, o.regionID, o.regionEpoch.GetVersion(), o.regionEpoch.GetConfVer(), o.id, o.GetCreateTime(),
+		o.GetStartTime(), atomic.LoadInt32(&o.currentStep), o.ApproximateSize, strings.Join(stepStrs, ", "), o.Timeout().String())
 	if o.CheckSuccess() {
 		s += " finished"
 	}
 	if o.CheckTimeout() {
 		s += " timeout"
 	}
+	if len(o.source) != 0 {
+		s += fmt.Sprintf(" source:%s", o.source)
+	}
+	if o.IsCanceled() {
+		if reason := o.GetCancelReason(); len(reason) != 0 {
+			s += fmt.Sprintf(" canceled(reason:%s)", reason)
+		} else {
+			s += " canceled"
+		}
+	}
 	return s
 }
 
+// ShortString returns a compact one-line description of the operator for high-frequency logging,
+// such as "op#123 region=5 kind=leader step=2/4 status=STARTED". Unlike String, it doesn't check
+// the status of the operator.
+func (o *Operator) ShortString() string {
+	return fmt.Sprintf("op#%d region=%d kind=%s step=%d/%d status=%s",
+		o.id, o.regionID, o.kind, atomic.LoadInt32(&o.currentStep), len(o.steps), strings.ToUpper(OpStatusToString(o.Status())))
+}
+
+// StatusReason returns a human-readable explanation of the current status, which
+// contains the cancel reason, the current step and the elapsed time.
+func (o *Operator) StatusReason() string {
+	st := o.Status()
+	now := time.Now()
+	if IsEndStatus(st) {
+		now = o.status.ReachTime()
+	}
+	currentStep := atomic.LoadInt32(&o.currentStep)
+	stepDesc := func() string {
+		if int(currentStep) >= len(o.steps) {
+			return fmt.Sprintf("step %d", currentStep)
+		}
+		return fmt.Sprintf("step %d (%s)", currentStep, o.steps[currentStep])
+	}
+	stepStartTime, _ := o.getCurrentTimeAndStep()
+	switch st {
+	case CREATED:
+		return fmt.Sprintf("created %v ago", now.Sub(o.GetCreateTime()).Round(time.Second))
+	case STARTED:
+		return fmt.Sprintf("running on %s for %v", stepDesc(), now.Sub(stepStartTime).Round(time.Second))
+	case PAUSED:
+		return fmt.Sprintf("paused on %s", stepDesc())
+	case SUCCESS:
+		return fmt.Sprintf("finished %d steps in %v", len(o.steps), now.Sub(o.GetStartTime()).Round(time.Second))
+	case CANCELED:
+		reason := o.GetCancelReason()
+		if len(reason) == 0 {
+			reason = Unknown
+		}
+		if reason == SoftCanceled {
+			return fmt.Sprintf("soft canceled before %s", stepDesc())
+		}
+		return fmt.Sprintf("canceled: %s", reason)
+	case REPLACED:
+		by := "a newer operator"
+		if kind, ok := o.AdditionalInfos[replacedByKind]; ok {
+			by = fmt.Sprintf("a newer %s operator", kind)
+		}
+		return fmt.Sprintf("replaced by %s on %s", by, stepDesc())
+	case EXPIRED:
+		return fmt.Sprintf("expired after %v without being started", now.Sub(o.GetCreateTime()).Round(time.Second))
+	case TIMEOUT:
+		return fmt.Sprintf("timed out on %s after %v", stepDesc(), now.Sub(stepStartTime).Round(time.Second))
+	}
+	return OpStatusToString(st)
+}
+
+// SetSource sets the source which generates the operator, such as the scheduler name.
+func (o *Operator) SetSource(source string) {
+	o.source = source
+}
+
+// Source returns the source which generates the operator.
+func (o *Operator) Source() string {
+	return o.source
+}
+
+// SetGroupID sets the group ID of the operator, which correlates the operators generated by
+// one scheduling decision, such as evacuating a store. The group ID is opaque and assigned by the caller.
+func (o *Operator) SetGroupID(groupID uint64) {
+	o.groupID = groupID
+}
+
+// GetGroupID returns the group ID of the operator, or zero if it is not set.
+func (o *Operator) GetGroupID() uint64 {
+	return o.groupID
+}
+
+// SetFreezeExempt sets whether the operator can be dispatched during a change freeze.
+func (o *Operator) SetFreezeExempt(exempt bool) {
+	o.freezeExempt = exempt
+}
+
+// IsFreezeExempt returns whether the operator can be dispatched during a change freeze. It is
+// true by default for the operators initiated by admin or replica checker for failure recovery.
+func (o *Operator) IsFreezeExempt() bool {
+	return o.freezeExempt
+}
+
+// FilterByGroup returns the operators which belong to the given group.
+func FilterByGroup(ops []*Operator, groupID uint64) []*Operator {
+	var res []*Operator
+	for _, op := range ops {
+		if op != nil && op.GetGroupID() == groupID {
+			res = append(res, op)
+		}
+	}
+	return res
+}
+
+// CancelForRegion cancels the operators of the given region with the reason, such as when the region
+// is removed or merged away. The operators which have ended are skipped. It returns the number of
+// canceled operators.
+func CancelForRegion(ops []*Operator, regionID uint64, reason CancelReasonType) int {
+	canceled := 0
+	for _, op := range ops {
+		if op == nil || op.RegionID() != regionID || op.IsEnd() {
+			continue
+		}
+		if op.Cancel(reason) {
+			canceled++
+		}
+	}
+	return canceled
+}
+
+// SetLabel sets the label of the operator. Unlike AdditionalInfos, the labels are used for
+// policy filtering, such as rate-limiting the operators of a tenant.
+// It should not be called concurrently with GetLabel.
+func (o *Operator) SetLabel(key, value string) {
+	if o.labels == nil {
+		o.labels = make(map[string]string)
+	}
+	o.labels[key] = value
+}
+
+// GetLabel returns the label of the operator, and false if the label doesn't exist.
+func (o *Operator) GetLabel(key string) (string, bool) {
+	value, ok := o.labels[key]
+	return value, ok
+}
+
+// AttachConstLabels attaches the const labels to the duration metrics of the operator and its steps,
+// such as the cluster ID. The labels are encoded as "k1=v1,k2=v2" sorted by the keys into the "labels"
+// label of the dedicated histograms, so the callers don't need to pre-build the metrics for every
+// combination. Empty labels detach the const labels.
+// NOTE: each distinct label set creates new series for every step type and operator kind, so the
+// values must be bounded, such as the cluster or the tenant, rather than the region or the operator.
+// It should be called before the operator is started.
+func (o *Operator) AttachConstLabels(labels prometheus.Labels) {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	o.constLabels = strings.Join(pairs, ",")
+}
+
 // Brief returns the operator's short brief.
 func (o *Operator) Brief() string {
 	return o.brief
 }
 
+// SetBrief sets the brief for the operator.
+func (o *Operator) SetBrief(brief string) {
+	o.brief = brief
+}
+
 // MarshalJSON serializes custom types to JSON.
 func (o *Operator) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + o.String() + `"`), nil
@@ -162,26 +609,91 @@ type OpObject struct {
 
 // ToJSONObject serializes Operator as JSON object.
 func (o *Operator) ToJSONObject() *OpObject {
-	var status OpStatus
-	if o.CheckSuccess() {
-		status = SUCCESS
-	} else if o.CheckTimeout() {
-		status = TIMEOUT
-	} else {
-		status = o.Status()
-	}
-
 	return &OpObject{
 		Desc:        o.desc,
 		Brief:       o.brief,
 		RegionID:    o.regionID,
 		RegionEpoch: o.regionEpoch,
 		Kind:        o.kind,
-		Timeout:     o.timeout.String(),
-		Status:      status,
+		Timeout:     o.Timeout().String(),
+		Status:      o.jsonStatus(),
+	}
+}
+
+func (o *Operator) jsonStatus() OpStatus {
+	if o.CheckSuccess() {
+		return SUCCESS
+	} else if o.CheckTimeout() {
+		return TIMEOUT
+	}
+	return o.Status()
+}
+
+// OpStepObject is used to return OpStep as a json object for API.
+type OpStepObject struct {
+	Type string `json:"type"`
+	Desc string `json:"desc"`
+}
+
+// OpStructuredObject is used to return Operator as a structured json object for API.
+// Unlike OpObject, it contains the steps of the operator.
+type OpStructuredObject struct {
+	ID              uint64              `json:"id"`
+	Desc            string              `json:"desc"`
+	Brief           string              `json:"brief"`
+	RegionID        uint64              `json:"region_id"`
+	RegionEpoch     *metapb.RegionEpoch `json:"region_epoch,omitempty"`
+	Kind            string              `json:"kind"`
+	Status          string              `json:"status"`
+	CurrentStep     int32               `json:"current_step"`
+	ApproximateSize int64               `json:"approximate_size"`
+	Timeout         string              `json:"timeout"`
+	Source          string              `json:"source,omitempty"`
+	Labels          map[string]string   `json:"labels,omitempty"`
+	Steps           []OpStepObject      `json:"steps"`
+}
+
+// ToStructuredJSONObject converts Operator to a structured JSON object.
+// Unlike ToJSONObject, it reads the current status without checking the timeout or the
+// expiration, so the operator is not changed by the conversion.
+func (o *Operator) ToStructuredJSONObject() *OpStructuredObject {
+	steps := make([]OpStepObject, 0, len(o.steps))
+	for _, step := range o.steps {
+		steps = append(steps, OpStepObject{
+			Type: reflect.TypeOf(step).Name(),
+			Desc: step.String(),
+		})
+	}
+	return &OpStructuredObject{
+		ID:              o.id,
+		Desc:            o.desc,
+		Brief:           o.brief,
+		RegionID:        o.regionID,
+		RegionEpoch:     o.regionEpoch,
+		Kind:            o.kind.String(),
+		Status:          OpStatusToString(o.Status()),
+		CurrentStep:     atomic.LoadInt32(&o.currentStep),
+		ApproximateSize: o.ApproximateSize,
+		Timeout:         o.Timeout().String(),
+		Source:          o.source,
+		Labels:          o.labels,
+		Steps:           steps,
 	}
 }
 
+// MarshalStructuredJSON serializes Operator to a JSON object instead of a string.
+// It is an opt-in alternative of MarshalJSON, which is kept for compatibility.
+// NOTE: The JSON is one-way and can't be decoded back to an operator, use MarshalBinary for that.
+func (o *Operator) MarshalStructuredJSON() ([]byte, error) {
+	return json.Marshal(o.ToStructuredJSONObject())
+}
+
+// GetID returns the operator's ID, which is unique in the process, except that
+// the operator decoded by DecodeOperator keeps the ID of the encoded one.
+func (o *Operator) GetID() uint64 {
+	return o.id
+}
+
 // Desc returns the operator's short description.
 func (o *Operator) Desc() string {
 	return o.desc
@@ -197,6 +709,15 @@ func (o *Operator) AttachKind(kind OpKind) {
 	o.kind |= kind
 }
 
+// ClearKind clears the given operator kind of the operator. The priority level is
+// recomputed if OpAdmin is cleared, like the operator is created without OpAdmin.
+func (o *Operator) ClearKind(kind OpKind) {
+	if o.IsAdmin() && kind&OpAdmin != 0 {
+		o.level = constant.Medium
+	}
+	o.kind &^= kind
+}
+
 // RegionID returns the region that operator is targeted.
 func (o *Operator) RegionID() uint64 {
 	return o.regionID
@@ -207,6 +728,57 @@ func (o *Operator) RegionEpoch() *metapb.RegionEpoch {
 	return o.regionEpoch
 }
 
+// EpochMatches checks whether the version and the conf version of the epoch attached to the operator
+// match the current epoch of the region. It returns true if no epoch is attached, which means no check.
+func (o *Operator) EpochMatches(region *core.RegionInfo) bool {
+	if o.regionEpoch == nil {
+		return true
+	}
+	if region == nil {
+		return false
+	}
+	epoch := region.GetRegionEpoch()
+	return epoch.GetVersion() == o.regionEpoch.GetVersion() && epoch.GetConfVer() == o.regionEpoch.GetConfVer()
+}
+
+// SetEpochCheckOnStep sets whether Check validates the region epoch by CheckEpochBeforeStep
+// before advancing the steps. It is disabled by default.
+func (o *Operator) SetEpochCheckOnStep(enable bool) {
+	o.epochCheckOnStep = enable
+}
+
+// IsEpochCheckOnStep returns whether Check validates the region epoch before advancing the steps.
+func (o *Operator) IsEpochCheckOnStep() bool {
+	return o.epochCheckOnStep
+}
+
+// CheckEpochBeforeStep checks whether the epoch of the region still matches the attached one,
+// and cancels the operator with EpochNotMatch if not. Unlike EpochMatches, the confver consumed
+// by the steps of the operator is allowed, and the version is not checked if the operator
+// merges or splits the region. It returns true if the epoch matches.
+func (o *Operator) CheckEpochBeforeStep(region *core.RegionInfo) bool {
+	if o.regionEpoch == nil || region == nil {
+		return true
+	}
+	latest := region.GetRegionEpoch()
+	matched := latest.GetConfVer() >= o.regionEpoch.GetConfVer() &&
+		latest.GetConfVer()-o.regionEpoch.GetConfVer() <= o.ConfVerChanged(region)
+	if matched && latest.GetVersion() != o.regionEpoch.GetVersion() {
+		matched = o.ContainsStepFunc(func(step OpStep) bool {
+			switch step.(type) {
+			case MergeRegion, SplitRegion:
+				return true
+			default:
+				return false
+			}
+		})
+	}
+	if !matched {
+		_ = o.Cancel(EpochNotMatch)
+	}
+	return matched
+}
+
 // Kind returns operator's kind.
 func (o *Operator) Kind() OpKind {
 	return o.kind
@@ -222,6 +794,11 @@ func (o *Operator) SchedulerKind() OpKind {
 	return o.kind & (-o.kind)
 }
 
+// IsAdmin returns true if the operator is created by the admin.
+func (o *Operator) IsAdmin() bool {
+	return o.kind&OpAdmin != 0
+}
+
 // Status returns operator status.
 func (o *Operator) Status() OpStatus {
 	return o.status.Status()
@@ -244,26 +821,125 @@ func (o *Operator) CheckAndGetStatus() OpStatus {
 	}
 }
 
+// OnEnd registers a callback which is called once when the operator reaches an end status.
+// It's safe to be called by multiple goroutine concurrently.
+func (o *Operator) OnEnd(f func(OpStatus)) {
+	o.status.OnEnd(f)
+}
+
+// OnTimeout registers a callback which is called once when the operator is timeout, with the
+// stalled step and its index. The step is nil if the timeout is not caused by a step.
+// It's safe to be called by multiple goroutine concurrently.
+func (o *Operator) OnTimeout(f func(step OpStep, index int)) {
+	if f == nil {
+		return
+	}
+	o.OnEnd(func(st OpStatus) {
+		if st != TIMEOUT {
+			return
+		}
+		_, step := o.getCurrentTimeAndStep()
+		f(step, int(atomic.LoadInt32(&o.currentStep)))
+	})
+}
+
 // GetReachTimeOf returns the time when operator reaches the given status.
 func (o *Operator) GetReachTimeOf(st OpStatus) time.Time {
 	return o.status.ReachTimeOf(st)
 }
 
+// DwellTime returns how long the operator stayed in the given status.
+func (o *Operator) DwellTime(st OpStatus) time.Duration {
+	return o.status.DwellTime(st)
+}
+
 // GetCreateTime gets the create time of operator.
 func (o *Operator) GetCreateTime() time.Time {
 	return o.status.ReachTimeOf(CREATED)
 }
 
+// QueueTime returns the duration from being created to being started. If the operator has
+// not started, it is the duration since it was created or until it ended.
+func (o *Operator) QueueTime() time.Duration {
+	if o.HasStarted() {
+		return o.GetStartTime().Sub(o.GetCreateTime())
+	}
+	if o.IsEnd() {
+		return o.status.ReachTime().Sub(o.GetCreateTime())
+	}
+	return time.Since(o.GetCreateTime())
+}
+
 // ElapsedTime returns duration since it was created.
 func (o *Operator) ElapsedTime() time.Duration {
 	return time.Since(o.GetCreateTime())
 }
 
 // Start sets the operator to STARTED status, returns whether succeeded.
+// It returns false if the operator has ended, such as being canceled concurrently.
 func (o *Operator) Start() bool {
+	if !o.status.To(STARTED) {
+		return false
+	}
+	// the operator which is resumed from PAUSED is not queued.
+	if o.GetReachTimeOf(PAUSED).IsZero() {
+		operatorQueueTime.WithLabelValues(o.SchedulerKind().String()).Observe(o.QueueTime().Seconds())
+	}
+	return true
+}
+
+// Pause sets the operator to PAUSED status, returns whether succeeded.
+// The paused operator will not advance and the paused duration is not counted for timeout.
+func (o *Operator) Pause() bool {
+	return o.status.To(PAUSED)
+}
+
+// Resume sets the paused operator back to STARTED status, returns whether succeeded.
+func (o *Operator) Resume() bool {
+	if o.Status() != PAUSED {
+		return false
+	}
 	return o.status.To(STARTED)
 }
 
+// pauseByFreeze pauses the operator because of the change freeze, see Controller.SetFreeze.
+func (o *Operator) pauseByFreeze() bool {
+	if !o.Pause() {
+		return false
+	}
+	atomic.StoreInt32(&o.pausedByFreeze, 1)
+	return true
+}
+
+// resumeFromFreeze resumes the operator paused by the change freeze.
+func (o *Operator) resumeFromFreeze() bool {
+	if !atomic.CompareAndSwapInt32(&o.pausedByFreeze, 1, 0) {
+		return false
+	}
+	return o.Resume()
+}
+
+func (o *Operator) isPausedByFreeze() bool {
+	return atomic.LoadInt32(&o.pausedByFreeze) == 1 && o.IsPaused()
+}
+
+// IsPaused returns whether operator is paused.
+func (o *Operator) IsPaused() bool {
+	return o.Status() == PAUSED
+}
+
+// IsQueued returns true if the operator is created but not started, and it is not expired yet.
+// Unlike CheckExpired, it doesn't update the status.
+func (o *Operator) IsQueued() bool {
+	if o.Status() != CREATED {
+		return false
+	}
+	if o.IsAdmin() {
+		return true
+	}
+	return !o.exceedDeadline() && time.Since(o.GetCreateTime()) < OperatorExpireTime
+}
+
 // HasStarted returns whether operator has started.
 func (o *Operator) HasStarted() bool {
 	return !o.GetStartTime().IsZero()
@@ -274,6 +950,15 @@ func (o *Operator) GetStartTime() time.Time {
 	return o.status.ReachTimeOf(STARTED)
 }
 
+// GetFinishTime gets the time when the operator reaches an end status, such as SUCCESS,
+// CANCELED or TIMEOUT. It returns zero if the operator is not ended.
+func (o *Operator) GetFinishTime() time.Time {
+	if !o.IsEnd() {
+		return time.Time{}
+	}
+	return o.status.ReachTime()
+}
+
 // RunningTime returns duration since it started.
 func (o *Operator) RunningTime() time.Duration {
 	if o.HasStarted() {
@@ -287,20 +972,71 @@ func (o *Operator) IsEnd() bool {
 	return o.status.IsEnd()
 }
 
+// IsReplaced checks if the operator is replaced by another one.
+func (o *Operator) IsReplaced() bool {
+	return o.Status() == REPLACED
+}
+
+// IsCanceled checks if the operator is canceled.
+func (o *Operator) IsCanceled() bool {
+	return o.Status() == CANCELED
+}
+
+// AllStepsDone checks if all steps are finished without updating the status.
+func (o *Operator) AllStepsDone() bool {
+	return atomic.LoadInt32(&o.currentStep) >= int32(len(o.steps))
+}
+
 // CheckSuccess checks if all steps are finished, and update the status.
 func (o *Operator) CheckSuccess() bool {
-	if atomic.LoadInt32(&o.currentStep) >= int32(len(o.steps)) {
+	if o.AllStepsDone() {
 		return o.status.To(SUCCESS) || o.Status() == SUCCESS
 	}
 	return false
 }
 
-// Cancel marks the operator canceled.
-func (o *Operator) Cancel(reason ...CancelReasonType) bool {
-	if _, ok := o.AdditionalInfos[cancelReason]; !ok && len(reason) != 0 {
-		o.AdditionalInfos[cancelReason] = string(reason[0])
+// Cancel marks the operator canceled with the given reason. The reason is recorded only if the
+// operator is canceled by this call, and it's safe to be called concurrently with Check.
+func (o *Operator) Cancel(reason CancelReasonType) bool {
+	if !o.status.ToWith(CANCELED, func() { o.cancelReason.Store(reason) }) {
+		return false
+	}
+	operatorCanceledCounter.WithLabelValues(cancelReasonLabel(reason)).Inc()
+	return true
+}
+
+// WithContext binds the operator to the context, the operator is canceled with ContextCanceled
+// when the context is done. The watching goroutine exits once the operator ends.
+func (o *Operator) WithContext(ctx context.Context) {
+	if o.IsEnd() {
+		return
+	}
+	ended := make(chan struct{})
+	o.status.OnEnd(func(OpStatus) { close(ended) })
+	go func() {
+		defer logutil.LogPanic()
+		select {
+		case <-ctx.Done():
+			_ = o.Cancel(ContextCanceled)
+		case <-ended:
+		}
+	}()
+}
+
+// GetCancelReason returns the reason why the operator is canceled.
+func (o *Operator) GetCancelReason() CancelReasonType {
+	reason, _ := o.cancelReason.Load().(CancelReasonType)
+	return reason
+}
+
+// Retryable returns whether it makes sense to regenerate the operator after it fails.
+// The admin operators should not be retried automatically, and it is pointless to retry
+// the operator which is canceled since the region is gone.
+func (o *Operator) Retryable() bool {
+	if o.IsAdmin() {
+		return false
 	}
-	return o.status.To(CANCELED)
+	return o.GetCancelReason() != RegionNotFound
 }
 
 // Replace marks the operator replaced.
@@ -308,92 +1044,1135 @@ func (o *Operator) Replace() bool {
 	return o.status.To(REPLACED)
 }
 
+// ReplacedBy records the region and kind of the operator which replaces this one in the additional infos.
+func (o *Operator) ReplacedBy(other *Operator) {
+	if other == nil {
+		return
+	}
+	o.SetAdditionalInfoInt(replacedByRegion, int64(other.RegionID()))
+	o.setAdditionalInfo(replacedByKind, other.Kind().String())
+}
+
 // CheckExpired checks if the operator is expired, and update the status.
+// The operator which is not started before the deadline is also expired.
+// The admin operator never expires since it may wait in the queue for a long time.
 func (o *Operator) CheckExpired() bool {
+	if o.IsAdmin() {
+		return false
+	}
+	if o.exceedDeadline() {
+		return o.status.CheckExpired(0)
+	}
 	return o.status.CheckExpired(OperatorExpireTime)
 }
 
 // CheckTimeout returns true if the operator is timeout, and update the status.
+// The operator is timeout once the timeout or the deadline is exceeded, whichever comes first.
+// The paused duration is not counted in the timeout, but the paused operator is still timeout
+// once the deadline is exceeded.
 func (o *Operator) CheckTimeout() bool {
 	if o.CheckSuccess() {
 		return false
 	}
-	return o.status.CheckTimeout(o.timeout)
+	if o.exceedDeadline() {
+		return o.status.TimeoutNow()
+	}
+	return o.status.CheckTimeout(o.Timeout())
 }
 
-// Len returns the operator's steps count.
-func (o *Operator) Len() int {
-	return len(o.steps)
+// TimeoutStatus returns the inputs of the timeout decision without changing the status: whether the
+// operator is timed out or would be timed out by CheckTimeout, the running duration excluding the
+// paused one, and the timeout budget, which is zero if the deadline is exceeded.
+func (o *Operator) TimeoutStatus() (timedOut bool, elapsed, budget time.Duration) {
+	elapsed = o.status.DwellTime(STARTED)
+	budget = o.Timeout()
+	if o.exceedDeadline() {
+		budget = 0
+	}
+	switch o.Status() {
+	case TIMEOUT:
+		timedOut = true
+	case STARTED:
+		timedOut = !o.AllStepsDone() && elapsed >= budget
+	}
+	return timedOut, elapsed, budget
 }
 
-// Step returns the i-th step.
-func (o *Operator) Step(i int) OpStep {
-	if i >= 0 && i < len(o.steps) {
-		return o.steps[i]
+// SetDeadline sets the wall-clock deadline of the operator, which works along with the timeout.
+// The zero time means no deadline.
+func (o *Operator) SetDeadline(t time.Time) {
+	var deadline int64
+	if !t.IsZero() {
+		deadline = t.UnixNano()
 	}
-	return nil
+	atomic.StoreInt64(&o.deadline, deadline)
 }
 
-// ContainNonWitnessStep returns true if it contains the target OpStep
-func (o *Operator) ContainNonWitnessStep() bool {
-	for _, step := range o.steps {
-		switch step.(type) {
-		case BecomeNonWitness:
-			return true
-		default:
-		}
+// GetDeadline returns the wall-clock deadline of the operator, or zero if it is not set.
+func (o *Operator) GetDeadline() time.Time {
+	if deadline := atomic.LoadInt64(&o.deadline); deadline != 0 {
+		return time.Unix(0, deadline)
 	}
-	return false
+	return time.Time{}
 }
 
-// getCurrentTimeAndStep returns the start time of the i-th step.
-// opStep is nil if the i-th step is not found.
-func (o *Operator) getCurrentTimeAndStep() (startTime time.Time, opStep OpStep) {
-	startTime = o.GetStartTime()
-	currentStep := atomic.LoadInt32(&o.currentStep)
-	if int(currentStep) < len(o.steps) {
-		opStep = o.steps[currentStep]
-		// we should use the finished time of the previous step if the first step is finished.
-		// the start time of the first step is the start time of the operator.
-		if currentStep > 0 {
-			startTime = time.Unix(0, atomic.LoadInt64(&(o.stepsTime[currentStep-1])))
-		}
-	}
+func (o *Operator) exceedDeadline() bool {
+	deadline := atomic.LoadInt64(&o.deadline)
+	return deadline != 0 && time.Now().UnixNano() >= deadline
+}
+
+// Timeout returns the timeout of the operator.
+func (o *Operator) Timeout() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&o.timeout)))
+}
+
+// SetTimeout sets the timeout of the operator. If the operator has been started,
+// the change is recorded in the additional infos.
+func (o *Operator) SetTimeout(d time.Duration) {
+	old := time.Duration(atomic.SwapInt64((*int64)(&o.timeout), int64(d)))
+	if o.HasStarted() && old != d {
+		o.setAdditionalInfo(timeoutChanged, fmt.Sprintf("%v->%v", old, d))
+	}
+}
+
+// ExtendTimeout extends the timeout of the operator by the given duration.
+// The negative duration is ignored.
+func (o *Operator) ExtendTimeout(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	atomic.AddInt64((*int64)(&o.timeout), int64(d))
+	times, _ := o.GetAdditionalInfoInt(timeoutExtendedTimes)
+	extended, _ := o.GetAdditionalInfoDuration(timeoutExtended)
+	o.SetAdditionalInfoInt(timeoutExtendedTimes, times+1)
+	o.SetAdditionalInfoDuration(timeoutExtended, extended+d)
+}
+
+// SetAdditionalInfoInt sets the additional info with the given integer value.
+func (o *Operator) SetAdditionalInfoInt(key string, v int64) {
+	o.setAdditionalInfo(key, strconv.FormatInt(v, 10))
+}
+
+// GetAdditionalInfoInt returns the integer value of the additional info.
+// It returns false if the key doesn't exist or the value is not an integer.
+func (o *Operator) GetAdditionalInfoInt(key string) (int64, bool) {
+	str, ok := o.AdditionalInfos[key]
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// SetAdditionalInfoDuration sets the additional info with the given duration value.
+func (o *Operator) SetAdditionalInfoDuration(key string, d time.Duration) {
+	o.setAdditionalInfo(key, d.String())
+}
+
+// GetAdditionalInfoDuration returns the duration value of the additional info.
+// It returns false if the key doesn't exist or the value is not a duration.
+func (o *Operator) GetAdditionalInfoDuration(key string) (time.Duration, bool) {
+	str, ok := o.AdditionalInfos[key]
+	if !ok {
+		return 0, false
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, false
+	}
+	return d, true
+}
+
+func (o *Operator) setAdditionalInfo(key, value string) {
+	if o.AdditionalInfos == nil {
+		o.AdditionalInfos = make(map[string]string)
+	}
+	o.AdditionalInfos[key] = value
+}
+
+// AppendStep appends a step to the operator which has not started yet, and the
+// timeout of the operator is extended by the timeout of the step.
+// It should not be called concurrently with Start.
+func (o *Operator) AppendStep(step OpStep) error {
+	if step == nil {
+		return errors.New("cannot append nil step")
+	}
+	if st := o.Status(); st != CREATED {
+		return errors.Errorf("cannot append step to operator with status %s", OpStatusToString(st))
+	}
+	o.steps = append(o.steps, step)
+	o.stepsTime = append(o.stepsTime, 0)
+	o.stepsDispatched = append(o.stepsDispatched, 0)
+	o.stepsAttempts = append(o.stepsAttempts, 0)
+	atomic.AddInt64((*int64)(&o.timeout), int64(step.Timeout(o.ApproximateSize)))
+	o.cost += int(stepCost(step, o.ApproximateSize))
+	// the cached influence doesn't contain the new step.
+	o.influence = nil
+	return nil
+}
+
+// SetApproximateSize updates the approximate size of the operator which has not
+// started yet, and recomputes the timeout from the steps. The extended timeout is kept.
+// It should not be called concurrently with Start.
+func (o *Operator) SetApproximateSize(size int64) error {
+	if st := o.Status(); st != CREATED {
+		return errors.Errorf("cannot set approximate size of operator with status %s", OpStatusToString(st))
+	}
+	o.ApproximateSize = size
+	extended, _ := o.GetAdditionalInfoDuration(timeoutExtended)
+	atomic.StoreInt64((*int64)(&o.timeout), int64(stepsTimeout(size, o.steps)+extended))
+	o.cost = stepsCost(size, o.steps)
+	// the cached influence is calculated with the old size.
+	o.influence = nil
+	return nil
+}
+
+// ReorderStepsByInfluence reorders the independent steps of the operator which has not started
+// yet, so that the store with less pending influence receives the new peer earlier. Only the
+// consecutive AddPeer and AddLearner steps are independent, and the relative order between them
+// and the other steps is kept.
+// It should not be called concurrently with Start.
+func (o *Operator) ReorderStepsByInfluence(opInfluence OpInfluence) {
+	if o.Status() != CREATED {
+		return
+	}
+	for start := 0; start < len(o.steps); {
+		end := start
+		for end < len(o.steps) && addedStore(o.steps[end]) != 0 {
+			end++
+		}
+		if end-start > 1 {
+			run := o.steps[start:end]
+			sort.SliceStable(run, func(i, j int) bool {
+				a := opInfluence.storeInfluence(addedStore(run[i]))
+				b := opInfluence.storeInfluence(addedStore(run[j]))
+				if a.RegionSize != b.RegionSize {
+					return a.RegionSize < b.RegionSize
+				}
+				return a.RegionCount < b.RegionCount
+			})
+		}
+		start = end + 1
+	}
+}
+
+// addedStore returns the store which the step adds a peer to, or 0 if the step is not an
+// independent adding step.
+func addedStore(step OpStep) uint64 {
+	switch s := step.(type) {
+	case AddPeer:
+		return s.ToStore
+	case AddLearner:
+		return s.ToStore
+	default:
+		return 0
+	}
+}
+
+// Len returns the operator's steps count.
+func (o *Operator) Len() int {
+	return len(o.steps)
+}
+
+// Step returns the i-th step.
+func (o *Operator) Step(i int) OpStep {
+	if i >= 0 && i < len(o.steps) {
+		return o.steps[i]
+	}
+	return nil
+}
+
+// CurrentStepIndex returns the index of the current step, which is the count of the finished steps.
+// It returns Len() if all steps are finished. It's safe to be called by multiple goroutine concurrently.
+func (o *Operator) CurrentStepIndex() int {
+	return min(int(atomic.LoadInt32(&o.currentStep)), len(o.steps))
+}
+
+// RemainingSteps returns the count of steps which are not finished yet.
+// It's safe to be called by multiple goroutine concurrently.
+func (o *Operator) RemainingSteps() int {
+	remaining := len(o.steps) - int(atomic.LoadInt32(&o.currentStep))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Progress returns the ratio of finished steps, which is between 0 and 1.
+// It's safe to be called by multiple goroutine concurrently.
+func (o *Operator) Progress() float64 {
+	if len(o.steps) == 0 {
+		return 1
+	}
+	return float64(len(o.steps)-o.RemainingSteps()) / float64(len(o.steps))
+}
+
+// ContainNonWitnessStep returns true if it contains the target OpStep
+func (o *Operator) ContainNonWitnessStep() bool {
+	return o.ContainsStepFunc(func(step OpStep) bool {
+		_, ok := step.(BecomeNonWitness)
+		return ok
+	})
+}
+
+// ContainsStepFunc returns true if any step of the operator satisfies the predicate.
+func (o *Operator) ContainsStepFunc(pred func(OpStep) bool) bool {
+	for _, step := range o.steps {
+		if pred(step) {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal returns true if the given operator has the same region, kind and steps.
+// The region epochs are compared by the version and the conf version.
+func (o *Operator) Equal(other *Operator) bool {
+	if other == nil || o.regionID != other.regionID || o.kind != other.kind || len(o.steps) != len(other.steps) {
+		return false
+	}
+	if o.regionEpoch.GetVersion() != other.regionEpoch.GetVersion() ||
+		o.regionEpoch.GetConfVer() != other.regionEpoch.GetConfVer() {
+		return false
+	}
+	for i, step := range o.steps {
+		if !step.Equal(other.steps[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks whether the steps form a coherent sequence, such as a peer can not be added to
+// a store twice, and a removed peer can not be removed, promoted or become the leader again.
+// The origin peers of the region are unknown, so only the stores changed by the previous steps are checked.
+func (o *Operator) Validate() error {
+	peers := make(stepPeers)
+	for i, step := range o.steps {
+		if err := peers.apply(step); err != nil {
+			return errors.Errorf("invalid step %d (%s): %v", i, step, err)
+		}
+	}
+	return nil
+}
+
+// stepPeerState is the state of the peer on a store which is changed by the steps.
+type stepPeerState int
+
+const (
+	stepPeerUnknown stepPeerState = iota // not changed by the steps, it is the origin peer or no peer.
+	stepPeerAbsent
+	stepPeerLearner
+	stepPeerVoter
+)
+
+func (s stepPeerState) String() string {
+	switch s {
+	case stepPeerAbsent:
+		return "absent"
+	case stepPeerLearner:
+		return "learner"
+	case stepPeerVoter:
+		return "voter"
+	default:
+		return "unknown"
+	}
+}
+
+// stepPeers records the peer state of the stores changed by the steps.
+type stepPeers map[uint64]stepPeerState
+
+func (p stepPeers) apply(step OpStep) error {
+	switch s := step.(type) {
+	case TransferLeader:
+		return p.check(stepPeerVoter, append([]uint64{s.FromStore, s.ToStore}, s.ToStores...)...)
+	case TransferLeaderToCandidates:
+		return p.check(stepPeerVoter, append([]uint64{s.FromStore}, s.ToStores...)...)
+	case AddPeer:
+		return p.add(s.ToStore, stepPeerVoter)
+	case AddPeerWithPriority:
+		return p.add(s.ToStore, stepPeerVoter)
+	case AddLearner:
+		return p.add(s.ToStore, stepPeerLearner)
+	case PromoteLearner:
+		return p.change(s.ToStore, stepPeerLearner, stepPeerVoter)
+	case DemoteVoterToLearner:
+		return p.change(s.StoreID, stepPeerVoter, stepPeerLearner)
+	case RemovePeer:
+		if err := p.check(stepPeerUnknown, s.FromStore); err != nil {
+			return err
+		}
+		p[s.FromStore] = stepPeerAbsent
+	case RemoveLearners:
+		for _, storeID := range s.StoreIDs {
+			if err := p.change(storeID, stepPeerLearner, stepPeerAbsent); err != nil {
+				return err
+			}
+		}
+	case BecomeWitness:
+		return p.check(stepPeerUnknown, s.StoreID)
+	case BecomeNonWitness:
+		return p.check(stepPeerUnknown, s.StoreID)
+	case BatchSwitchWitness:
+		for _, w := range s.ToWitnesses {
+			if err := p.check(stepPeerUnknown, w.StoreID); err != nil {
+				return err
+			}
+		}
+		for _, nw := range s.ToNonWitnesses {
+			if err := p.check(stepPeerUnknown, nw.StoreID); err != nil {
+				return err
+			}
+		}
+	case ChangePeerV2Enter:
+		for _, pl := range s.PromoteLearners {
+			if err := p.change(pl.ToStore, stepPeerLearner, stepPeerVoter); err != nil {
+				return err
+			}
+		}
+		// the demoting voters are still voters in the joint state.
+		for _, dv := range s.DemoteVoters {
+			if err := p.check(stepPeerVoter, dv.ToStore); err != nil {
+				return err
+			}
+		}
+	case ChangePeerV2Leave:
+		for _, pl := range s.PromoteLearners {
+			p[pl.ToStore] = stepPeerVoter
+		}
+		for _, dv := range s.DemoteVoters {
+			p[dv.ToStore] = stepPeerLearner
+		}
+	}
+	return nil
+}
+
+// check checks the peers on the stores exist and have the given state if it is known.
+// The zero store ID is ignored, and stepPeerUnknown means any state of an existing peer.
+func (p stepPeers) check(expected stepPeerState, storeIDs ...uint64) error {
+	for _, storeID := range storeIDs {
+		if storeID == 0 {
+			continue
+		}
+		switch st := p[storeID]; {
+		case st == stepPeerAbsent:
+			return errors.Errorf("store %d has no peer", storeID)
+		case expected != stepPeerUnknown && st != stepPeerUnknown && st != expected:
+			return errors.Errorf("peer on store %d is %s, not %s", storeID, st, expected)
+		}
+	}
+	return nil
+}
+
+func (p stepPeers) add(storeID uint64, st stepPeerState) error {
+	if s := p[storeID]; s == stepPeerLearner || s == stepPeerVoter {
+		return errors.Errorf("store %d already has a peer", storeID)
+	}
+	p[storeID] = st
+	return nil
+}
+
+func (p stepPeers) change(storeID uint64, from, to stepPeerState) error {
+	if err := p.check(from, storeID); err != nil {
+		return err
+	}
+	p[storeID] = to
+	return nil
+}
+
+// VoterDelta returns the net change of the voter count made by the steps of the operator. Adding
+// a voter or promoting a learner is +1, and removing a voter or demoting a voter is -1. The joint
+// consensus changes take effect when the joint state is left. The origin peers of the region are
+// unknown, so the removed peer is regarded as a voter unless it becomes a learner by the previous steps.
+func (o *Operator) VoterDelta() int {
+	var delta int
+	learners := make(map[uint64]struct{})
+	for _, step := range o.steps {
+		switch s := step.(type) {
+		case AddPeer:
+			delta++
+		case AddPeerWithPriority:
+			delta++
+		case AddLearner:
+			learners[s.ToStore] = struct{}{}
+		case PromoteLearner:
+			delete(learners, s.ToStore)
+			delta++
+		case DemoteVoterToLearner:
+			learners[s.StoreID] = struct{}{}
+			delta--
+		case RemovePeer:
+			if _, ok := learners[s.FromStore]; ok {
+				delete(learners, s.FromStore)
+				continue
+			}
+			delta--
+		case RemoveLearners:
+			for _, storeID := range s.StoreIDs {
+				delete(learners, storeID)
+			}
+		case ChangePeerV2Leave:
+			for _, pl := range s.PromoteLearners {
+				delete(learners, pl.ToStore)
+				delta++
+			}
+			for _, dv := range s.DemoteVoters {
+				learners[dv.ToStore] = struct{}{}
+				delta--
+			}
+		}
+	}
+	return delta
+}
+
+// LongestStep returns the step which has the largest timeout with the approximate size of the
+// operator, and the first one is returned if there are several. The index is -1 if there is no step.
+func (o *Operator) LongestStep() (index int, step OpStep, budget time.Duration) {
+	index = -1
+	for i, s := range o.steps {
+		if timeout := s.Timeout(o.ApproximateSize); index < 0 || timeout > budget {
+			index, step, budget = i, s, timeout
+		}
+	}
 	return
 }
 
+// StepSummary returns the count of steps for each step type. The key is the type name of
+// the step, which is the same as the label of the step duration metrics.
+func (o *Operator) StepSummary() map[string]int {
+	summary := make(map[string]int)
+	for _, step := range o.steps {
+		summary[reflect.TypeOf(step).Name()]++
+	}
+	return summary
+}
+
+// IsLeaderOnly returns true if all steps of the operator only transfer the leader.
+func (o *Operator) IsLeaderOnly() bool {
+	if len(o.steps) == 0 {
+		return false
+	}
+	for _, step := range o.steps {
+		switch step.(type) {
+		case TransferLeader, TransferLeaderToCandidates:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NeedsSnapshot returns true if any step of the operator may make TiKV send a snapshot,
+// including adding a peer with data and switching a witness to a non-witness.
+func (o *Operator) NeedsSnapshot() bool {
+	for _, step := range o.steps {
+		switch s := step.(type) {
+		case AddPeer:
+			if !s.IsLightWeight && !s.IsWitness {
+				return true
+			}
+		case AddPeerWithPriority:
+			if !s.IsLightWeight && !s.IsWitness {
+				return true
+			}
+		case AddLearner:
+			if !s.IsLightWeight && !s.IsWitness {
+				return true
+			}
+		case BecomeNonWitness:
+			return true
+		case BatchSwitchWitness:
+			if len(s.ToNonWitnesses) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Cost returns the rate limiting cost of the operator in snapshot-equivalent tokens, which is
+// consumed from the token bucket of the controller when the operator is admitted. Each step
+// sending a snapshot, such as AddPeer and AddLearner, contributes its size-based weight which
+// is at least 1, and the steps only changing the metadata contribute zero.
+func (o *Operator) Cost() int {
+	return o.cost
+}
+
+// TotalCost returns the approximate IO cost of all steps of the operator.
+func (o *Operator) TotalCost() int64 {
+	var cost int64
+	for _, step := range o.steps {
+		cost += step.ApproximateCost(o.ApproximateSize)
+	}
+	return cost
+}
+
+// InvolvedStores returns the sorted IDs of stores which are involved in the steps of the operator.
+func (o *Operator) InvolvedStores() []uint64 {
+	set := make(map[uint64]struct{})
+	add := func(storeIDs ...uint64) {
+		for _, id := range storeIDs {
+			if id != 0 {
+				set[id] = struct{}{}
+			}
+		}
+	}
+	for _, step := range o.steps {
+		switch s := step.(type) {
+		case TransferLeader:
+			add(s.FromStore, s.ToStore)
+			add(s.ToStores...)
+		case TransferLeaderToCandidates:
+			add(s.FromStore)
+			add(s.ToStores...)
+		case AddPeer:
+			add(s.ToStore)
+		case AddPeerWithPriority:
+			add(s.ToStore)
+		case AddLearner:
+			add(s.ToStore, s.SendStore)
+		case PromoteLearner:
+			add(s.ToStore)
+		case DemoteVoterToLearner:
+			add(s.StoreID)
+		case RemovePeer:
+			add(s.FromStore)
+		case RemoveLearners:
+			add(s.StoreIDs...)
+		case BecomeWitness:
+			add(s.StoreID)
+		case BecomeNonWitness:
+			add(s.StoreID, s.SendStore)
+		case BatchSwitchWitness:
+			for _, w := range s.ToWitnesses {
+				add(w.StoreID)
+			}
+			for _, nw := range s.ToNonWitnesses {
+				add(nw.StoreID, nw.SendStore)
+			}
+		case ChangePeerV2Enter:
+			for _, pl := range s.PromoteLearners {
+				add(pl.ToStore)
+			}
+			for _, dv := range s.DemoteVoters {
+				add(dv.ToStore)
+			}
+		case ChangePeerV2Leave:
+			for _, pl := range s.PromoteLearners {
+				add(pl.ToStore)
+			}
+			for _, dv := range s.DemoteVoters {
+				add(dv.ToStore)
+			}
+		}
+	}
+	stores := make([]uint64, 0, len(set))
+	for id := range set {
+		stores = append(stores, id)
+	}
+	sort.Slice(stores, func(i, j int) bool { return stores[i] < stores[j] })
+	return stores
+}
+
+// Conflicts returns true if the steps of the two operators on the same region would partially
+// cancel each other, such as one adds a peer to a store and the other removes the peer from the
+// store, or they transfer the leader between two stores in the opposite directions.
+func (o *Operator) Conflicts(other *Operator) bool {
+	if other == nil || o.RegionID() != other.RegionID() {
+		return false
+	}
+	involved := make(map[uint64]struct{})
+	for _, id := range o.InvolvedStores() {
+		involved[id] = struct{}{}
+	}
+	shared := false
+	for _, id := range other.InvolvedStores() {
+		if _, ok := involved[id]; ok {
+			shared = true
+			break
+		}
+	}
+	if !shared {
+		return false
+	}
+	added, removed, transfers := o.storeChanges()
+	otherAdded, otherRemoved, otherTransfers := other.storeChanges()
+	for id := range added {
+		if _, ok := otherRemoved[id]; ok {
+			return true
+		}
+	}
+	for id := range removed {
+		if _, ok := otherAdded[id]; ok {
+			return true
+		}
+	}
+	for t := range transfers {
+		if _, ok := otherTransfers[[2]uint64{t[1], t[0]}]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// storeChanges returns the stores which the steps add peers to and remove peers from,
+// and the leader transfers of the steps from one store to another.
+func (o *Operator) storeChanges() (added, removed map[uint64]struct{}, transfers map[[2]uint64]struct{}) {
+	added, removed, transfers = make(map[uint64]struct{}), make(map[uint64]struct{}), make(map[[2]uint64]struct{})
+	for _, step := range o.steps {
+		switch s := step.(type) {
+		case TransferLeader:
+			transfers[[2]uint64{s.FromStore, s.ToStore}] = struct{}{}
+		case AddPeer:
+			added[s.ToStore] = struct{}{}
+		case AddPeerWithPriority:
+			added[s.ToStore] = struct{}{}
+		case AddLearner:
+			added[s.ToStore] = struct{}{}
+		case RemovePeer:
+			removed[s.FromStore] = struct{}{}
+		case RemoveLearners:
+			for _, id := range s.StoreIDs {
+				removed[id] = struct{}{}
+			}
+		}
+	}
+	return
+}
+
+// getCurrentTimeAndStep returns the start time of the i-th step.
+// opStep is nil if the i-th step is not found.
+func (o *Operator) getCurrentTimeAndStep() (startTime time.Time, opStep OpStep) {
+	startTime = o.GetStartTime()
+	currentStep := atomic.LoadInt32(&o.currentStep)
+	if int(currentStep) < len(o.steps) {
+		opStep = o.steps[currentStep]
+		// we should use the finished time of the previous step if the first step is finished.
+		// the start time of the first step is the start time of the operator.
+		if currentStep > 0 {
+			startTime = time.Unix(0, atomic.LoadInt64(&(o.stepsTime[currentStep-1])))
+		}
+	}
+	return
+}
+
+// StepStatus is the execution status of a step.
+type StepStatus struct {
+	Step     OpStep
+	Finished bool
+	// Skipped is true if the step is finished before it is returned by Check,
+	// which means the step is already satisfied and it is a no-op.
+	Skipped    bool
+	FinishTime time.Time
+}
+
+// StepStatuses returns the execution status of each step.
+func (o *Operator) StepStatuses() []StepStatus {
+	statuses := make([]StepStatus, len(o.steps))
+	for i, step := range o.steps {
+		statuses[i].Step = step
+		if finishTime := atomic.LoadInt64(&(o.stepsTime[i])); finishTime != 0 {
+			statuses[i].Finished = true
+			statuses[i].Skipped = atomic.LoadInt32(&(o.stepsDispatched[i])) == 0
+			statuses[i].FinishTime = time.Unix(0, finishTime)
+		}
+	}
+	return statuses
+}
+
+// StepDurations returns the time taken by each step. The current step reports the
+// elapsed time so far, and the steps which are not reached yet report zero.
+func (o *Operator) StepDurations() []time.Duration {
+	durations := make([]time.Duration, len(o.steps))
+	if !o.HasStarted() {
+		return durations
+	}
+	last := o.GetStartTime()
+	for i := range o.steps {
+		finishTime := atomic.LoadInt64(&(o.stepsTime[i]))
+		if finishTime == 0 {
+			now := time.Now()
+			if o.IsEnd() {
+				now = o.status.ReachTime()
+			}
+			durations[i] = now.Sub(last)
+			break
+		}
+		durations[i] = time.Unix(0, finishTime).Sub(last)
+		last = time.Unix(0, finishTime)
+	}
+	return durations
+}
+
+// EstimatedCompletion projects the completion time of the operator. The remaining steps are
+// assumed to take their timeout budgets scaled by the pace of the finished steps. If no step
+// is finished, it is the start time plus the timeout. It returns zero if the operator has not
+// started, and the end time if the operator has ended.
+// It's safe to be called by multiple goroutine concurrently.
+func (o *Operator) EstimatedCompletion() time.Time {
+	if !o.HasStarted() {
+		return time.Time{}
+	}
+	if o.IsEnd() {
+		return o.GetFinishTime()
+	}
+	step := int(atomic.LoadInt32(&o.currentStep))
+	if step == 0 || step > len(o.steps) {
+		return o.GetStartTime().Add(o.Timeout())
+	}
+	lastFinish := time.Unix(0, atomic.LoadInt64(&(o.stepsTime[step-1])))
+	remaining := stepsTimeout(o.ApproximateSize, o.steps[step:])
+	if budget := stepsTimeout(o.ApproximateSize, o.steps[:step]); budget > 0 {
+		pace := float64(lastFinish.Sub(o.GetStartTime())) / float64(budget)
+		remaining = time.Duration(float64(remaining) * pace)
+	}
+	return lastFinish.Add(remaining)
+}
+
+// IsStalled returns true if the current step has been running longer than its own timeout.
+// It's safe to be called by multiple goroutine concurrently.
+func (o *Operator) IsStalled() bool {
+	if o.Status() != STARTED {
+		return false
+	}
+	startTime, step := o.getCurrentTimeAndStep()
+	if step == nil || startTime.IsZero() {
+		return false
+	}
+	return time.Since(startTime) > step.Timeout(o.ApproximateSize)
+}
+
+// CurrentStepMovesLeader returns true if the current step transfers the leader and it is
+// not finished on the given region yet. The region can be nil if it is unknown.
+// It's safe to be called by multiple goroutine concurrently.
+func (o *Operator) CurrentStepMovesLeader(region *core.RegionInfo) bool {
+	_, step := o.getCurrentTimeAndStep()
+	switch step.(type) {
+	case TransferLeader, TransferLeaderToCandidates:
+		return region == nil || !step.IsFinish(region)
+	default:
+		return false
+	}
+}
+
+// OnStepFinished registers a callback which is called once in Check when a step is finished.
+func (o *Operator) OnStepFinished(f func(stepIndex int, step OpStep)) {
+	if f == nil {
+		return
+	}
+	o.callbackMu.Lock()
+	defer o.callbackMu.Unlock()
+	o.onStepFinished = append(o.onStepFinished, f)
+}
+
+func (o *Operator) fireStepFinished(stepIndex int) {
+	o.callbackMu.RLock()
+	callbacks := o.onStepFinished
+	o.callbackMu.RUnlock()
+	for _, f := range callbacks {
+		f(stepIndex, o.steps[stepIndex])
+	}
+}
+
+// SetDependency makes the operator wait until the given operator succeeds.
+// It should be called before the operator is added to the controller. Note that
+// the timeout of the operator starts when it's started, so it includes the time
+// waiting for the dependency.
+func (o *Operator) SetDependency(dep *Operator) {
+	o.dependency = dep
+}
+
+// GetDependency returns the operator which this operator depends on.
+func (o *Operator) GetDependency() *Operator {
+	return o.dependency
+}
+
+// waitingForDependency returns true if the operator is not ended and its dependency is not ended yet.
+func (o *Operator) waitingForDependency() bool {
+	dep := o.dependency
+	return dep != nil && !dep.IsEnd() && !o.IsEnd()
+}
+
+// checkDependency returns true if the dependency is satisfied. If the dependency
+// ends without success, the operator is canceled.
+func (o *Operator) checkDependency() bool {
+	dep := o.dependency
+	if dep == nil {
+		return true
+	}
+	if !dep.IsEnd() {
+		return false
+	}
+	if dep.Status() != SUCCESS {
+		_ = o.Cancel(DependencyFailed)
+		return false
+	}
+	return true
+}
+
 // Check checks if current step is finished, returns next step to take action.
-// If operator is at an end status, check returns nil.
+// If operator is at an end status, paused or waiting for its dependency, check returns nil.
 // It's safe to be called by multiple goroutine concurrently.
 func (o *Operator) Check(region *core.RegionInfo) OpStep {
 	if o.IsEnd() {
 		return nil
 	}
+	if o.IsPaused() {
+		// the paused operator is timeout once the deadline is exceeded.
+		_ = o.CheckTimeout()
+		return nil
+	}
 	// CheckTimeout will call CheckSuccess first
 	defer func() { _ = o.CheckTimeout() }()
+	if !o.checkDependency() {
+		return nil
+	}
+	if o.epochCheckOnStep && !o.CheckEpochBeforeStep(region) {
+		return nil
+	}
 	for step := atomic.LoadInt32(&o.currentStep); int(step) < len(o.steps); step++ {
-		if o.steps[int(step)].IsFinish(region) {
+		if o.isStepFinished(step, region) {
 			if atomic.CompareAndSwapInt64(&(o.stepsTime[step]), 0, time.Now().UnixNano()) {
 				startTime, _ := o.getCurrentTimeAndStep()
-				operatorStepDuration.WithLabelValues(reflect.TypeOf(o.steps[int(step)]).Name()).
-					Observe(time.Unix(0, o.stepsTime[step]).Sub(startTime).Seconds())
+				stepType := reflect.TypeOf(o.steps[int(step)]).Name()
+				duration := time.Unix(0, o.stepsTime[step]).Sub(startTime).Seconds()
+				operatorStepDuration.WithLabelValues(stepType).Observe(duration)
+				if o.constLabels != "" {
+					operatorStepDurationByLabels.WithLabelValues(stepType, o.constLabels).Observe(duration)
+				}
+				o.advanceConfVerCache(step, region)
+				o.fireStepFinished(int(step))
 			}
 			atomic.StoreInt32(&o.currentStep, step+1)
 		} else {
+			if o.stopBySoftCancel(step) {
+				_ = o.Cancel(SoftCanceled)
+				return nil
+			}
+			if !o.attemptStep(step) {
+				_ = o.Cancel(StepRetryExhausted)
+				return nil
+			}
+			atomic.StoreInt32(&o.stepsDispatched[step], 1)
 			return o.steps[int(step)]
 		}
 	}
 	return nil
 }
 
+// isStepFinished checks if the step is finished on the region. WaitUntilNotHot is also finished
+// if it is the current step and it has waited for MaxWait, since the step doesn't know when it started.
+func (o *Operator) isStepFinished(step int32, region *core.RegionInfo) bool {
+	if o.steps[step].IsFinish(region) {
+		return true
+	}
+	wait, ok := o.steps[step].(WaitUntilNotHot)
+	if !ok || !o.HasStarted() || step != atomic.LoadInt32(&o.currentStep) {
+		return false
+	}
+	startTime, _ := o.getCurrentTimeAndStep()
+	return time.Since(startTime) >= wait.MaxWait
+}
+
+// SoftCancel cancels the operator softly. Unlike Cancel, the in-flight step is not abandoned,
+// and the operator is canceled by Check once the step is finished, before the next step is
+// dispatched. ChangePeerV2Leave is still dispatched to leave the joint state.
+func (o *Operator) SoftCancel() {
+	atomic.StoreInt32(&o.softCanceled, 1)
+}
+
+// stopBySoftCancel returns true if the operator is soft canceled and the step has not been dispatched.
+func (o *Operator) stopBySoftCancel(step int32) bool {
+	if atomic.LoadInt32(&o.softCanceled) == 0 || atomic.LoadInt32(&o.stepsDispatched[step]) != 0 {
+		return false
+	}
+	_, leaveJoint := o.steps[step].(ChangePeerV2Leave)
+	return !leaveJoint
+}
+
+// SetParallelGroups sets the groups of the step indices which may be executed simultaneously.
+// Each group must be a run of consecutive indices in ascending order, and the groups must not overlap.
+// It can only be called before the operator is started.
+func (o *Operator) SetParallelGroups(groups [][]int) error {
+	if st := o.Status(); st != CREATED {
+		return errors.Errorf("cannot set parallel groups of operator with status %s", OpStatusToString(st))
+	}
+	next := 0
+	for _, group := range groups {
+		if len(group) == 0 {
+			return errors.New("parallel group cannot be empty")
+		}
+		for i, idx := range group {
+			if idx < next || idx >= len(o.steps) || (i > 0 && idx != group[i-1]+1) {
+				return errors.Errorf("invalid step index %d in parallel group %v", idx, group)
+			}
+		}
+		next = group[len(group)-1] + 1
+	}
+	o.parallelGroups = groups
+	return nil
+}
+
+// GetParallelGroups returns the groups of the step indices which may be executed simultaneously.
+func (o *Operator) GetParallelGroups() [][]int {
+	return o.parallelGroups
+}
+
+// CheckParallel is like Check, but it returns all runnable steps rather than the first one.
+// The unfinished steps in the parallel group of the current step are runnable as well, and
+// only the current step is returned if it is not in any parallel group.
+func (o *Operator) CheckParallel(region *core.RegionInfo) []OpStep {
+	step := o.Check(region)
+	if step == nil {
+		return nil
+	}
+	cur := atomic.LoadInt32(&o.currentStep)
+	steps := []OpStep{step}
+	for _, group := range o.parallelGroups {
+		if int(cur) < group[0] || int(cur) > group[len(group)-1] {
+			continue
+		}
+		for _, idx := range group {
+			if idx > int(cur) && !o.isStepFinished(int32(idx), region) {
+				atomic.StoreInt32(&o.stepsDispatched[idx], 1)
+				steps = append(steps, o.steps[idx])
+			}
+		}
+		break
+	}
+	return steps
+}
+
+// attemptStep counts the attempts of the unfinished current step, the first attempt is counted when
+// the step is dispatched. It returns false if the attempts exceed MaxStepAttempts.
+func (o *Operator) attemptStep(step int32) bool {
+	attempts := atomic.LoadInt32(&o.stepsAttempts[step])
+	if attempts == 0 {
+		atomic.CompareAndSwapInt32(&o.stepsAttempts[step], 0, 1)
+		return true
+	}
+	if !o.HasStarted() || step != atomic.LoadInt32(&o.currentStep) {
+		return true
+	}
+	startTime, _ := o.getCurrentTimeAndStep()
+	if time.Since(startTime) >= time.Duration(attempts)*StepAttemptInterval &&
+		atomic.CompareAndSwapInt32(&o.stepsAttempts[step], attempts, attempts+1) {
+		attempts++
+	}
+	return MaxStepAttempts <= 0 || int(attempts) <= MaxStepAttempts
+}
+
+// StepAttempts returns the attempts of the i-th step, which is zero if the step has not been dispatched.
+func (o *Operator) StepAttempts(i int) int {
+	if i < 0 || i >= len(o.stepsAttempts) {
+		return 0
+	}
+	return int(atomic.LoadInt32(&o.stepsAttempts[i]))
+}
+
+// SkipToStep advances the current step past the leading steps which are already finished in the
+// given region, and the finished time of them is set to now. It is used to resume an operator which
+// is recovered from the region state. It never advances past an unfinished step, and the step
+// duration is not observed since the steps are not executed by this operator.
+func (o *Operator) SkipToStep(region *core.RegionInfo) {
+	if o.IsEnd() {
+		return
+	}
+	for step := atomic.LoadInt32(&o.currentStep); int(step) < len(o.steps); step++ {
+		if !o.isStepFinished(step, region) {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&(o.stepsTime[step]), 0, time.Now().UnixNano()) {
+			o.advanceConfVerCache(step, region)
+			o.fireStepFinished(int(step))
+		}
+		atomic.StoreInt32(&o.currentStep, step+1)
+	}
+}
+
+// PeekStep returns the first unfinished step like Check, but it doesn't update
+// the current step and the step finished time.
+func (o *Operator) PeekStep(region *core.RegionInfo) OpStep {
+	if o.IsEnd() || o.IsPaused() {
+		return nil
+	}
+	if dep := o.dependency; dep != nil && dep.Status() != SUCCESS {
+		return nil
+	}
+	_, step := o.FirstUnfinishedStep(region)
+	return step
+}
+
+// FirstUnfinishedStep returns the index and the first unfinished step from the current step
+// regardless of the status, and it doesn't update the current step and the step finished time.
+// It returns (-1, nil) if all steps are finished.
+func (o *Operator) FirstUnfinishedStep(region *core.RegionInfo) (int, OpStep) {
+	for step := atomic.LoadInt32(&o.currentStep); int(step) < len(o.steps); step++ {
+		if !o.isStepFinished(step, region) {
+			return int(step), o.steps[step]
+		}
+	}
+	return -1, nil
+}
+
+// IsNoop checks whether every step of the operator is already finished on the region, so the
+// operator does nothing and can be discarded without dispatching. All steps are checked from
+// the first one regardless of the progress and the status of the operator.
+func (o *Operator) IsNoop(region *core.RegionInfo) bool {
+	for _, step := range o.steps {
+		if !step.IsFinish(region) {
+			return false
+		}
+	}
+	return true
+}
+
+// confVerCache is the cumulative confver consumed by the first `steps` finished steps.
+type confVerCache struct {
+	steps int32
+	total uint64
+}
+
+func (o *Operator) loadConfVerCache() *confVerCache {
+	if cache, ok := o.confVerCache.Load().(*confVerCache); ok {
+		return cache
+	}
+	return &confVerCache{}
+}
+
+// advanceConfVerCache adds the confver consumed by the finished step into the cache.
+// The last step is not cached since it's always recomputed by ConfVerChanged.
+func (o *Operator) advanceConfVerCache(step int32, region *core.RegionInfo) {
+	if int(step) >= len(o.steps)-1 {
+		return
+	}
+	old := o.confVerCache.Load()
+	prev, ok := old.(*confVerCache)
+	if !ok {
+		prev = &confVerCache{}
+	}
+	if prev.steps != step {
+		return
+	}
+	next := &confVerCache{steps: step + 1, total: prev.total + o.steps[step].ConfVerChanged(region)}
+	o.confVerCache.CompareAndSwap(old, next)
+}
+
 // ConfVerChanged returns the number of confver has consumed by steps
 func (o *Operator) ConfVerChanged(region *core.RegionInfo) (total uint64) {
 	current := atomic.LoadInt32(&o.currentStep)
 	if current == int32(len(o.steps)) {
 		current--
 	}
+	start := int32(0)
+	if cache := o.loadConfVerCache(); cache.steps <= current {
+		start, total = cache.steps, cache.total
+	}
 	// including current step, it may has taken effects in this heartbeat
-	for _, step := range o.steps[0 : current+1] {
+	for _, step := range o.steps[start : current+1] {
 		total += step.ConfVerChanged(region)
 	}
 	return total
@@ -404,11 +2183,55 @@ func (o *Operator) SetPriorityLevel(level constant.PriorityLevel) {
 	o.level = level
 }
 
+// Less returns true if the operator should be ordered before the given one.
+// The operators are ordered by:
+//  1. the priority level, the higher level first.
+//  2. the scheduler kind, the smaller one first since the lower bit has the higher order.
+//  3. the kind, the smaller one first.
+//  4. the create time, the older one first.
+//  5. the region ID, the smaller one first.
+func (o *Operator) Less(other *Operator) bool {
+	if o.GetPriorityLevel() != other.GetPriorityLevel() {
+		return o.GetPriorityLevel() > other.GetPriorityLevel()
+	}
+	if o.SchedulerKind() != other.SchedulerKind() {
+		return o.SchedulerKind() < other.SchedulerKind()
+	}
+	if o.kind != other.kind {
+		return o.kind < other.kind
+	}
+	if createTime, otherCreateTime := o.GetCreateTime(), other.GetCreateTime(); !createTime.Equal(otherCreateTime) {
+		return createTime.Before(otherCreateTime)
+	}
+	return o.regionID < other.regionID
+}
+
 // GetPriorityLevel gets the priority level.
 func (o *Operator) GetPriorityLevel() constant.PriorityLevel {
 	return o.level
 }
 
+// AgeBoostedLevel returns the priority level which is one level higher than the raw level if the
+// elapsed time exceeds AgeBoostThreshold, and the level is capped at Urgent.
+func (o *Operator) AgeBoostedLevel() constant.PriorityLevel {
+	level := o.GetPriorityLevel()
+	if AgeBoostThreshold > 0 && level < constant.Urgent && o.ElapsedTime() > AgeBoostThreshold {
+		level++
+	}
+	return level
+}
+
+// NumericPriorityScale is the gap between the numeric priorities of the adjacent priority levels.
+const NumericPriorityScale = 100
+
+// NumericPriority returns the priority of the operator on a numeric scale where the higher one is
+// more urgent, so that the operators can be merged with the external work in a single queue. The
+// age boosted level is mapped to a multiple of NumericPriorityScale, which is 0 for Low, 100 for
+// Medium, 200 for High and 300 for Urgent, and the values in between are left for the external work.
+func (o *Operator) NumericPriority() int {
+	return int(o.AgeBoostedLevel()) * NumericPriorityScale
+}
+
 // UnfinishedInfluence calculates the store difference which unfinished operator steps make.
 func (o *Operator) UnfinishedInfluence(opInfluence OpInfluence, region *core.RegionInfo) {
 	for step := atomic.LoadInt32(&o.currentStep); int(step) < len(o.steps); step++ {
@@ -418,6 +2241,28 @@ func (o *Operator) UnfinishedInfluence(opInfluence OpInfluence, region *core.Reg
 	}
 }
 
+// UnfinishedInfluenceOnStores is like UnfinishedInfluence, but only the influence on the given
+// stores is accumulated, so the influence map is not filled with the irrelevant stores.
+func (o *Operator) UnfinishedInfluenceOnStores(opInfluence OpInfluence, region *core.RegionInfo, stores map[uint64]struct{}) {
+	if len(stores) == 0 {
+		return
+	}
+	// the influence of each step is filtered once calculated, which only involves a few stores.
+	stepInfluence := *NewOpInfluence()
+	for step := atomic.LoadInt32(&o.currentStep); int(step) < len(o.steps); step++ {
+		if o.steps[int(step)].IsFinish(region) {
+			continue
+		}
+		o.steps[int(step)].Influence(stepInfluence, region)
+		for id, inf := range stepInfluence.StoresInfluence {
+			if _, ok := stores[id]; ok {
+				opInfluence.GetStoreInfluence(id).add(inf)
+			}
+			delete(stepInfluence.StoresInfluence, id)
+		}
+	}
+}
+
 // TotalInfluence calculates the store difference which whole operator steps make.
 func (o *Operator) TotalInfluence(opInfluence OpInfluence, region *core.RegionInfo) {
 	// skip if region is nil and not cache influence.
@@ -433,6 +2278,96 @@ func (o *Operator) TotalInfluence(opInfluence OpInfluence, region *core.RegionIn
 	opInfluence.Add(o.influence)
 }
 
+// TotalInfluenceSized is like TotalInfluence, but the size-based influence of the steps, such as the
+// region size and the step cost of AddPeer and AddLearner, is calculated with the ApproximateSize of
+// the operator instead of the current size of the region. If ApproximateSize is not positive, the
+// size of the region is used. The result is not cached.
+func (o *Operator) TotalInfluenceSized(opInfluence OpInfluence, region *core.RegionInfo) {
+	if region == nil {
+		return
+	}
+	if o.ApproximateSize > 0 && o.ApproximateSize != region.GetApproximateSize() {
+		region = region.Clone(core.SetApproximateSize(o.ApproximateSize))
+	}
+	for _, step := range o.steps {
+		step.Influence(opInfluence, region)
+	}
+}
+
+// LeaderInfluence returns the net change of the leader count of each store made by the operator,
+// which is -1 for the store losing the leader and +1 for the store gaining it. Only the steps
+// transferring the leader contribute, and the stores without change are omitted. For the step with
+// candidates, including TransferLeader without ToStore, the leader is attributed to the current
+// leader store of the region if it is one of the candidates, otherwise to the first candidate.
+// The step without any target store is ignored, and the store 0 is never reported.
+func (o *Operator) LeaderInfluence(region *core.RegionInfo) map[uint64]int {
+	deltas := make(map[uint64]int)
+	transfer := func(step OpStep, from, to uint64) {
+		if region != nil && step.IsFinish(region) {
+			to = region.GetLeader().GetStoreId()
+		}
+		if to == 0 {
+			return
+		}
+		if from != 0 {
+			deltas[from]--
+		}
+		deltas[to]++
+	}
+	for _, step := range o.steps {
+		switch s := step.(type) {
+		case TransferLeader:
+			to := s.ToStore
+			if to == 0 && len(s.ToStores) > 0 {
+				to = s.ToStores[0]
+			}
+			transfer(s, s.FromStore, to)
+		case TransferLeaderToCandidates:
+			if len(s.ToStores) > 0 {
+				transfer(s, s.FromStore, s.ToStores[0])
+			}
+		}
+	}
+	for storeID, delta := range deltas {
+		if delta == 0 {
+			delete(deltas, storeID)
+		}
+	}
+	return deltas
+}
+
+// SnapshotPlannedInfluence freezes the total influence of the operator on the given region as the
+// planned influence, such as when it is admitted. Only the first snapshot takes effect, so that the
+// planned influence can be compared with the recomputed one later.
+func (o *Operator) SnapshotPlannedInfluence(region *core.RegionInfo) {
+	if region == nil || o.plannedInfluence != nil {
+		return
+	}
+	planned := NewOpInfluence()
+	for _, step := range o.steps {
+		step.Influence(*planned, region)
+	}
+	o.plannedInfluence = planned
+}
+
+// PlannedInfluence returns a copy of the planned influence, or nil if it is not snapshotted.
+func (o *Operator) PlannedInfluence() *OpInfluence {
+	if o.plannedInfluence == nil {
+		return nil
+	}
+	planned := NewOpInfluence()
+	planned.Add(o.plannedInfluence)
+	return planned
+}
+
+// InfluenceDiff checks whether the total influence of the operator matches the delta from before to after.
+// It is used in tests to catch the steps whose influence is miscalculated.
+func (o *Operator) InfluenceDiff(before, after OpInfluence, region *core.RegionInfo) bool {
+	expected := *NewOpInfluence()
+	o.TotalInfluence(expected, region)
+	return expected.matchDelta(before, after)
+}
+
 // OpHistory is used to log and visualize completed operators.
 type OpHistory struct {
 	FinishTime time.Time
@@ -456,10 +2391,23 @@ func (o *Operator) History() []OpHistory {
 			})
 		case AddPeer:
 			addPeerStores = append(addPeerStores, s.ToStore)
+		case AddPeerWithPriority:
+			addPeerStores = append(addPeerStores, s.ToStore)
 		case AddLearner:
 			addPeerStores = append(addPeerStores, s.ToStore)
 		case RemovePeer:
 			removePeerStores = append(removePeerStores, s.FromStore)
+		case BecomeWitness:
+			histories = append(histories, witnessHistory(now, s.StoreID))
+		case BecomeNonWitness:
+			histories = append(histories, witnessHistory(now, s.StoreID))
+		case BatchSwitchWitness:
+			for _, w := range s.ToWitnesses {
+				histories = append(histories, witnessHistory(now, w.StoreID))
+			}
+			for _, nw := range s.ToNonWitnesses {
+				histories = append(histories, witnessHistory(now, nw.StoreID))
+			}
 		}
 	}
 	for i := range addPeerStores {
@@ -475,14 +2423,170 @@ func (o *Operator) History() []OpHistory {
 	return histories
 }
 
+// witnessHistory returns the history of switching the peer on the store between witness and non-witness.
+func witnessHistory(finishTime time.Time, storeID uint64) OpHistory {
+	return OpHistory{
+		FinishTime: finishTime,
+		From:       storeID,
+		To:         storeID,
+		Kind:       constant.WitnessKind,
+	}
+}
+
+// historyCSVHeader is the header of the CSV exported by MarshalHistoryCSV.
+var historyCSVHeader = []string{"finish_time", "from_store", "to_store", "kind"}
+
+// MarshalHistoryCSV writes the histories as CSV with the header, each history is a record with
+// the columns finish_time, from_store, to_store and kind. The finish time is formatted as RFC3339.
+func MarshalHistoryCSV(w io.Writer, histories []OpHistory) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(historyCSVHeader); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, h := range histories {
+		record := []string{
+			h.FinishTime.Format(time.RFC3339),
+			strconv.FormatUint(h.From, 10),
+			strconv.FormatUint(h.To, 10),
+			h.Kind.String(),
+		}
+		if err := cw.Write(record); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	cw.Flush()
+	return errors.WithStack(cw.Error())
+}
+
+// StepEvent is a scheduling action of a step, which is used to replay the scheduling.
+// The store which a peer is added to or a leader is transferred to is ToStore, and the store
+// which a peer is removed from or a leader is transferred from is FromStore. Both of them are
+// the same store if the role of a peer is changed.
+type StepEvent struct {
+	Type         string
+	FromStore    uint64
+	ToStore      uint64
+	ConfVerDelta uint64
+}
+
+// StepEvents returns the events of all steps in execution order. The step which changes
+// several peers is expanded to an event per peer, so the sum of ConfVerDelta is the
+// change of the conf version made by the operator.
+func (o *Operator) StepEvents() []StepEvent {
+	events := make([]StepEvent, 0, len(o.steps))
+	for _, step := range o.steps {
+		typ := reflect.TypeOf(step).Name()
+		event := func(from, to, confVerDelta uint64) {
+			events = append(events, StepEvent{Type: typ, FromStore: from, ToStore: to, ConfVerDelta: confVerDelta})
+		}
+		switch s := step.(type) {
+		case TransferLeader:
+			event(s.FromStore, s.ToStore, 0)
+		case TransferLeaderToCandidates:
+			event(s.FromStore, 0, 0)
+		case AddPeer:
+			event(0, s.ToStore, 1)
+		case AddPeerWithPriority:
+			event(0, s.ToStore, 1)
+		case AddLearner:
+			event(0, s.ToStore, 1)
+		case PromoteLearner:
+			event(s.ToStore, s.ToStore, 1)
+		case DemoteVoterToLearner:
+			event(s.StoreID, s.StoreID, 1)
+		case RemovePeer:
+			event(s.FromStore, 0, 1)
+		case RemoveLearners:
+			for _, storeID := range s.StoreIDs {
+				event(storeID, 0, 1)
+			}
+		case BecomeWitness:
+			event(s.StoreID, s.StoreID, 1)
+		case BecomeNonWitness:
+			event(s.StoreID, s.StoreID, 1)
+		case BatchSwitchWitness:
+			for _, w := range s.ToWitnesses {
+				event(w.StoreID, w.StoreID, 1)
+			}
+			for _, nw := range s.ToNonWitnesses {
+				event(nw.StoreID, nw.StoreID, 1)
+			}
+		case ChangePeerV2Enter:
+			for _, pl := range s.PromoteLearners {
+				event(pl.ToStore, pl.ToStore, 1)
+			}
+			for _, dv := range s.DemoteVoters {
+				event(dv.ToStore, dv.ToStore, 1)
+			}
+		case ChangePeerV2Leave:
+			for _, pl := range s.PromoteLearners {
+				event(pl.ToStore, pl.ToStore, 1)
+			}
+			for _, dv := range s.DemoteVoters {
+				event(dv.ToStore, dv.ToStore, 1)
+			}
+		default:
+			// MergeRegion and SplitRegion don't change the peers.
+			event(0, 0, 0)
+		}
+	}
+	return events
+}
+
+// StepsHash returns a stable hash of the steps, which is computed over the ordered step types and
+// the stores they involve, excluding the peer IDs, the timing and the status. So the operators with
+// the same intent have the same hash even if they are created by different PD instances.
+func (o *Operator) StepsHash() uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	writeUint64 := func(v uint64) {
+		binary.BigEndian.PutUint64(buf[:], v)
+		_, _ = h.Write(buf[:])
+	}
+	for _, event := range o.StepEvents() {
+		_, _ = h.Write([]byte(event.Type))
+		_, _ = h.Write([]byte{0})
+		writeUint64(event.FromStore)
+		writeUint64(event.ToStore)
+	}
+	// the events don't contain the candidates and the merged regions.
+	for _, step := range o.steps {
+		switch s := step.(type) {
+		case TransferLeaderToCandidates:
+			for _, storeID := range s.ToStores {
+				writeUint64(storeID)
+			}
+		case MergeRegion:
+			writeUint64(s.FromRegion.GetId())
+			writeUint64(s.ToRegion.GetId())
+		}
+	}
+	return h.Sum64()
+}
+
+// OpRecordVersion is the version of the JSON layout of OpRecord.
+// NOTE: It must be bumped whenever the layout is changed.
+const OpRecordVersion = 1
+
 // OpRecord is used to log and visualize completed operators.
 // NOTE: This type is exported by HTTP API. Please pay more attention when modifying it.
 type OpRecord struct {
 	*Operator
+	Version    int
 	FinishTime time.Time
 	duration   time.Duration
 }
 
+// opRecordObject is the JSON layout of OpRecord.
+type opRecordObject struct {
+	Version int `json:"version"`
+	*OpStructuredObject
+	FinishTime time.Time `json:"finish_time"`
+	Duration   string    `json:"duration"`
+	// String is the string format of the record, which is the JSON layout before versioned.
+	String string `json:"string"`
+}
+
 func (o *OpRecord) String() string {
 	return fmt.Sprintf("%s (finishAt:%v, duration:%v)", o.Operator.String(), o.FinishTime, o.duration)
 }
@@ -492,11 +2596,44 @@ func (o *OpRecord) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + o.String() + `"`), nil
 }
 
+// Versioned returns the record which is marshaled as a versioned JSON object.
+func (o *OpRecord) Versioned() *VersionedOpRecord {
+	return &VersionedOpRecord{OpRecord: o}
+}
+
+// VersionedOpRecord is the OpRecord marshaled as a JSON object with the version of the layout.
+// OpRecord itself keeps being marshaled as a string for the existing consumers,
+// so the object layout is only returned when it is asked for explicitly.
+type VersionedOpRecord struct {
+	*OpRecord
+}
+
+// VersionedRecords converts the records to the versioned ones.
+func VersionedRecords(records []*OpRecord) []*VersionedOpRecord {
+	versioned := make([]*VersionedOpRecord, 0, len(records))
+	for _, record := range records {
+		versioned = append(versioned, record.Versioned())
+	}
+	return versioned
+}
+
+// MarshalJSON returns the record as a JSON object with the version of the layout.
+func (o *VersionedOpRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&opRecordObject{
+		Version:            o.Version,
+		OpStructuredObject: o.Operator.ToStructuredJSONObject(),
+		FinishTime:         o.FinishTime,
+		Duration:           o.duration.String(),
+		String:             o.String(),
+	})
+}
+
 // Record transfers the operator to OpRecord.
 func (o *Operator) Record(finishTime time.Time) *OpRecord {
 	step := atomic.LoadInt32(&o.currentStep)
 	record := &OpRecord{
 		Operator:   o,
+		Version:    OpRecordVersion,
 		FinishTime: finishTime,
 	}
 	start := o.GetStartTime()
@@ -507,10 +2644,29 @@ func (o *Operator) Record(finishTime time.Time) *OpRecord {
 	return record
 }
 
+// observeDuration observes the duration of the ended operator by its scheduler kind.
+// It should be called only once when the operator is ended.
+func (o *OpRecord) observeDuration() {
+	operatorKindDuration.WithLabelValues(o.SchedulerKind().String()).Observe(o.duration.Seconds())
+	if o.constLabels != "" {
+		operatorKindDurationByLabels.WithLabelValues(o.SchedulerKind().String(), o.constLabels).Observe(o.duration.Seconds())
+	}
+}
+
 // GetAdditionalInfo returns additional info with string
 func (o *Operator) GetAdditionalInfo() string {
-	if len(o.AdditionalInfos) != 0 {
-		additionalInfo, err := json.Marshal(o.AdditionalInfos)
+	infos := o.AdditionalInfos
+	if reason := o.GetCancelReason(); len(reason) != 0 {
+		// the cancel reason is not written into the additional infos by Cancel, since Cancel
+		// may be called concurrently, such as by Check.
+		infos = make(map[string]string, len(o.AdditionalInfos)+1)
+		for k, v := range o.AdditionalInfos {
+			infos[k] = v
+		}
+		infos[cancelReason] = string(reason)
+	}
+	if len(infos) != 0 {
+		additionalInfo, err := json.Marshal(infos)
 		if err == nil {
 			return string(additionalInfo)
 		}
@@ -19,6 +19,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingcap/failpoint"
@@ -66,6 +67,7 @@ type Controller struct {
 	wop             WaitingOperator
 	wopStatus       *waitingOperatorStatus
 	opNotifierQueue operatorQueue
+	frozen          atomic.Bool
 }
 
 // NewController creates a Controller.
@@ -85,6 +87,24 @@ func NewController(ctx context.Context, cluster *core.BasicCluster, config confi
 	}
 }
 
+// SetFreeze declares or lifts a change freeze. During the freeze, the operators which are
+// not freeze-exempt are paused rather than dispatched, so they don't time out because of
+// the freeze. They are resumed once the freeze is lifted.
+func (oc *Controller) SetFreeze(frozen bool) {
+	oc.frozen.Store(frozen)
+	if frozen {
+		return
+	}
+	for _, op := range oc.GetOperators() {
+		_ = op.resumeFromFreeze()
+	}
+}
+
+// IsFrozen returns whether a change freeze is declared.
+func (oc *Controller) IsFrozen() bool {
+	return oc.frozen.Load()
+}
+
 // Ctx returns a context which will be canceled once RaftCluster is stopped.
 // For now, it is only used to control the lifetime of TTL cache in schedulers.
 func (oc *Controller) Ctx() context.Context {
@@ -117,9 +137,16 @@ func (oc *Controller) Dispatch(region *core.RegionInfo, source string, recordOpS
 		switch op.Status() {
 		case STARTED:
 			operatorCounter.WithLabelValues(op.Desc(), "check").Inc()
+			if step == nil {
+				// The operator is waiting for its dependency.
+				return
+			}
 			if source == DispatchFromHeartBeat && oc.checkStaleOperator(op, step, region) {
 				return
 			}
+			if oc.holdByFreeze(op) {
+				return
+			}
 			oc.SendScheduleCommand(region, step, source)
 		case SUCCESS:
 			if op.ContainNonWitnessStep() {
@@ -133,31 +160,85 @@ func (oc *Controller) Dispatch(region *core.RegionInfo, source string, recordOpS
 				log.Debug("op finish duration less than 10s", zap.Uint64("region-id", op.RegionID()))
 				oc.pushFastOperator(op)
 			}
+		case PAUSED:
+			// The paused operator keeps its place until it is resumed, but the one held by
+			// the change freeze is still removed if it's stale.
+			if source == DispatchFromHeartBeat && op.isPausedByFreeze() {
+				if step := op.Step(op.CurrentStepIndex()); step != nil {
+					_ = oc.checkStaleOperator(op, step, region)
+				}
+			}
+		case CANCELED:
+			if reason := op.GetCancelReason(); knownDispatchCancel(reason) {
+				if oc.RemoveOperator(op, reason) {
+					operatorCounter.WithLabelValues(op.Desc(), "promote-"+dispatchCancelLabels[reason]).Inc()
+					oc.PromoteWaitingOperator()
+				}
+			} else {
+				oc.removeUnexpectedOperator(op)
+			}
 		case TIMEOUT:
 			if oc.RemoveOperator(op, Timeout) {
 				operatorCounter.WithLabelValues(op.Desc(), "promote-timeout").Inc()
 				oc.PromoteWaitingOperator()
 			}
 		default:
-			if oc.removeOperatorWithoutBury(op) {
-				// CREATED, EXPIRED must not appear.
-				// CANCELED, REPLACED must remove before transition.
-				log.Error("dispatching operator with unexpected status",
-					zap.Uint64("region-id", op.RegionID()),
-					zap.String("status", OpStatusToString(op.Status())),
-					zap.Reflect("operator", op), errs.ZapError(errs.ErrUnexpectedOperatorStatus))
-				failpoint.Inject("unexpectedOperator", func() {
-					panic(op)
-				})
-				_ = op.Cancel(NotInRunningState)
-				oc.buryOperator(op)
-				operatorCounter.WithLabelValues(op.Desc(), "promote-unexpected").Inc()
-				oc.PromoteWaitingOperator()
-			}
+			oc.removeUnexpectedOperator(op)
 		}
 	}
 }
 
+// dispatchCancelLabels maps the cancel reasons which the operator may raise by itself
+// when it's checked in Dispatch to the label of the promote counter.
+var dispatchCancelLabels = map[CancelReasonType]string{
+	DependencyFailed:   "dependency-failed",
+	SoftCanceled:       "soft-canceled",
+	StepRetryExhausted: "retry-exhausted",
+	// the region epoch is checked before each step, see Operator.CheckEpochBeforeStep.
+	EpochNotMatch:     "stale",
+	ContextCanceled:   "context-canceled",
+	BatchMemberFailed: "batch-member-failed",
+}
+
+// knownDispatchCancel returns true if the operator canceled with the reason should be
+// removed by Dispatch as expected.
+func knownDispatchCancel(reason CancelReasonType) bool {
+	_, ok := dispatchCancelLabels[reason]
+	return ok
+}
+
+// holdByFreeze checks whether the step of the operator should be held rather than dispatched
+// because of the change freeze, the held operator is paused until the freeze is lifted.
+func (oc *Controller) holdByFreeze(op *Operator) bool {
+	if !oc.IsFrozen() || op.IsFreezeExempt() {
+		return false
+	}
+	if op.pauseByFreeze() && !oc.IsFrozen() {
+		// the freeze is lifted concurrently.
+		_ = op.resumeFromFreeze()
+	}
+	operatorCounter.WithLabelValues(op.Desc(), "freeze-hold").Inc()
+	return true
+}
+
+func (oc *Controller) removeUnexpectedOperator(op *Operator) {
+	if oc.removeOperatorWithoutBury(op) {
+		// CREATED, EXPIRED must not appear.
+		// CANCELED, REPLACED must remove before transition.
+		log.Error("dispatching operator with unexpected status",
+			zap.Uint64("region-id", op.RegionID()),
+			zap.String("status", OpStatusToString(op.Status())),
+			zap.Reflect("operator", op), errs.ZapError(errs.ErrUnexpectedOperatorStatus))
+		failpoint.Inject("unexpectedOperator", func() {
+			panic(op)
+		})
+		_ = op.Cancel(NotInRunningState)
+		oc.buryOperator(op)
+		operatorCounter.WithLabelValues(op.Desc(), "promote-unexpected").Inc()
+		oc.PromoteWaitingOperator()
+	}
+}
+
 func (oc *Controller) checkStaleOperator(op *Operator, step OpStep, region *core.RegionInfo) bool {
 	err := step.CheckInProgress(oc.cluster, oc.config, region)
 	if err != nil {
@@ -168,6 +249,16 @@ func (oc *Controller) checkStaleOperator(op *Operator, step OpStep, region *core
 			return true
 		}
 	}
+	if storeID, exceeded := oc.exceedStoreCapacity(step, region); exceeded {
+		log.Info("operator target store runs out of space",
+			zap.Uint64("region-id", op.RegionID()),
+			zap.Uint64("store-id", storeID))
+		if oc.RemoveOperator(op, StoreCapacityExceeded) {
+			operatorCounter.WithLabelValues(op.Desc(), "promote-capacity-exceeded").Inc()
+			oc.PromoteWaitingOperator()
+			return true
+		}
+	}
 	// When the "source" is heartbeat, the region may have a newer
 	// confver than the region that the operator holds. In this case,
 	// the operator is stale, and will not be executed even we would
@@ -193,10 +284,36 @@ func (oc *Controller) checkStaleOperator(op *Operator, step OpStep, region *core
 	return false
 }
 
+// exceedStoreCapacity returns the target store and true if the step adds
+// a peer to a store which is lack of space. The step which has been started,
+// that is the peer already exists on the target store, is not checked since
+// the snapshot may be in flight.
+func (oc *Controller) exceedStoreCapacity(step OpStep, region *core.RegionInfo) (uint64, bool) {
+	var storeID uint64
+	switch s := step.(type) {
+	case AddPeer:
+		storeID = s.ToStore
+	case AddPeerWithPriority:
+		storeID = s.ToStore
+	case AddLearner:
+		storeID = s.ToStore
+	default:
+		return 0, false
+	}
+	if region.GetStorePeer(storeID) != nil {
+		return storeID, false
+	}
+	store := oc.cluster.GetStore(storeID)
+	if store == nil {
+		return storeID, false
+	}
+	return storeID, store.IsLowSpace(oc.config.GetLowSpaceRatio())
+}
+
 func (oc *Controller) getNextPushOperatorTime(step OpStep, now time.Time) time.Time {
 	nextTime := slowNotifyInterval
 	switch step.(type) {
-	case TransferLeader, PromoteLearner, ChangePeerV2Enter, ChangePeerV2Leave:
+	case TransferLeader, TransferLeaderToCandidates, PromoteLearner, ChangePeerV2Enter, ChangePeerV2Leave:
 		nextTime = fastNotifyInterval
 	}
 	return now.Add(nextTime)
@@ -230,7 +347,7 @@ func (oc *Controller) pollNeedDispatchRegion() (r *core.RegionInfo, next bool) {
 		return nil, true
 	}
 	step := op.Check(r)
-	if step == nil {
+	if step == nil && !op.IsPaused() && !op.waitingForDependency() {
 		return r, true
 	}
 	now := time.Now()
@@ -238,6 +355,13 @@ func (oc *Controller) pollNeedDispatchRegion() (r *core.RegionInfo, next bool) {
 		heap.Push(&oc.opNotifierQueue, item)
 		return nil, false
 	}
+	if step == nil {
+		// keep the paused operator in the queue, so it can be pushed after resumed,
+		// and so does the one waiting for its dependency.
+		item.time = now.Add(slowNotifyInterval)
+		heap.Push(&oc.opNotifierQueue, item)
+		return nil, true
+	}
 
 	// pushes with new notify time.
 	item.time = oc.getNextPushOperatorTime(step, now)
@@ -324,6 +448,20 @@ func (oc *Controller) AddOperator(ops ...*Operator) bool {
 	oc.Lock()
 	defer oc.Unlock()
 
+	if !oc.admitOperatorsLocked(ops...) {
+		return false
+	}
+	for _, op := range ops {
+		if !oc.addOperatorLocked(op) {
+			return false
+		}
+	}
+	return true
+}
+
+// admitOperatorsLocked checks whether the operators can be added, all of them
+// are canceled and buried if not.
+func (oc *Controller) admitOperatorsLocked(ops ...*Operator) bool {
 	// note: checkAddOperator uses false param for `isPromoting`.
 	// This is used to keep check logic before fixing issue #4946,
 	// but maybe user want to add operator when waiting queue is busy
@@ -342,10 +480,40 @@ func (oc *Controller) AddOperator(ops ...*Operator) bool {
 		}
 		return false
 	}
-	for _, op := range ops {
-		if !oc.addOperatorLocked(op) {
-			return false
+	return true
+}
+
+// AddBatchOperator adds the operators in the batch, all of them are admitted or none.
+// If one of them fails to be added, the operators added before it are removed and the
+// others are canceled. After being added, the others in the batch are removed by
+// Dispatch once any of them ends without success, see BatchOperator.
+func (oc *Controller) AddBatchOperator(batch *BatchOperator) bool {
+	ops := batch.Operators()
+	if len(ops) == 0 {
+		return false
+	}
+	oc.Lock()
+	defer oc.Unlock()
+
+	if !oc.admitOperatorsLocked(ops...) {
+		return false
+	}
+	for i, op := range ops {
+		if oc.addOperatorLocked(op) {
+			continue
+		}
+		// the failed one has been ended by others, and is buried by them.
+		for _, op := range ops[i:] {
+			if op.Cancel(BatchMemberFailed) {
+				oc.buryOperator(op)
+			}
+		}
+		for _, op := range ops[:i] {
+			_ = oc.removeOperatorLocked(op)
+			_ = op.Cancel(BatchMemberFailed)
+			oc.buryOperator(op)
 		}
+		return false
 	}
 	return true
 }
@@ -408,8 +576,7 @@ func (oc *Controller) checkAddOperator(isPromoting bool, ops ...*Operator) (bool
 			operatorCounter.WithLabelValues(op.Desc(), "not-found").Inc()
 			return false, RegionNotFound
 		}
-		if region.GetRegionEpoch().GetVersion() != op.RegionEpoch().GetVersion() ||
-			region.GetRegionEpoch().GetConfVer() != op.RegionEpoch().GetConfVer() {
+		if !op.EpochMatches(region) {
 			log.Debug("region epoch not match, cancel add operator",
 				zap.Uint64("region-id", op.RegionID()),
 				zap.Reflect("old", region.GetRegionEpoch()),
@@ -456,7 +623,7 @@ func (oc *Controller) checkAddOperator(isPromoting bool, ops ...*Operator) (bool
 }
 
 func isHigherPriorityOperator(new, old *Operator) bool {
-	return new.GetPriorityLevel() > old.GetPriorityLevel()
+	return new.AgeBoostedLevel() > old.AgeBoostedLevel()
 }
 
 func (oc *Controller) addOperatorLocked(op *Operator) bool {
@@ -470,6 +637,7 @@ func (oc *Controller) addOperatorLocked(op *Operator) bool {
 	// already.
 	if old, ok := oc.operators[regionID]; ok {
 		_ = oc.removeOperatorLocked(old)
+		old.ReplacedBy(op)
 		_ = old.Replace()
 		oc.buryOperator(old)
 	}
@@ -509,7 +677,7 @@ func (oc *Controller) addOperatorLocked(op *Operator) bool {
 
 	var step OpStep
 	if region := oc.cluster.GetRegion(op.RegionID()); region != nil {
-		if step = op.Check(region); step != nil {
+		if step = op.Check(region); step != nil && !oc.holdByFreeze(op) {
 			oc.SendScheduleCommand(region, step, DispatchFromCreate)
 		}
 	}
@@ -615,7 +783,7 @@ func (oc *Controller) removeOperatorLocked(op *Operator) bool {
 
 func (oc *Controller) removeRelatedMergeOperator(op *Operator) {
 	relatedID, _ := strconv.ParseUint(op.AdditionalInfos[string(RelatedMergeRegion)], 10, 64)
-	if relatedOp := oc.operators[relatedID]; relatedOp != nil && relatedOp.Status() != CANCELED {
+	if relatedOp := oc.operators[relatedID]; relatedOp != nil && !relatedOp.IsCanceled() {
 		log.Info("operator canceled related merge region",
 			zap.Uint64("region-id", relatedOp.RegionID()),
 			zap.String("additional-info", relatedOp.GetAdditionalInfo()),
@@ -683,6 +851,8 @@ func (oc *Controller) buryOperator(op *Operator) {
 		operatorCounter.WithLabelValues(op.Desc(), "cancel").Inc()
 	}
 
+	// observe here rather than in GetRecords, which may be called many times by API.
+	op.Record(time.Now()).observeDuration()
 	oc.records.Put(op)
 }
 
@@ -808,14 +978,13 @@ func (oc *Controller) GetOpInfluence(cluster *core.BasicCluster) OpInfluence {
 	}
 	oc.RLock()
 	defer oc.RUnlock()
+	ops := make([]*Operator, 0, len(oc.operators))
 	for _, op := range oc.operators {
 		if !op.CheckTimeout() && !op.CheckSuccess() {
-			region := cluster.GetRegion(op.RegionID())
-			if region != nil {
-				op.UnfinishedInfluence(influence, region)
-			}
+			ops = append(ops, op)
 		}
 	}
+	AccumulateUnfinishedInfluence(ops, influence, cluster.GetRegion)
 	return influence
 }
 
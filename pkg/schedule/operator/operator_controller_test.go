@@ -26,9 +26,11 @@ import (
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/pd/pkg/core"
+	"github.com/tikv/pd/pkg/core/constant"
 	"github.com/tikv/pd/pkg/core/storelimit"
 	"github.com/tikv/pd/pkg/mock/mockcluster"
 	"github.com/tikv/pd/pkg/mock/mockconfig"
@@ -223,6 +225,250 @@ func (suite *operatorControllerTestSuite) TestFastFailWithUnhealthyStore() {
 	re.True(oc.checkStaleOperator(op, steps[0], region))
 }
 
+func (suite *operatorControllerTestSuite) TestFastFailWithLowSpaceStore() {
+	re := suite.Require()
+	opt := mockconfig.NewTestOptions()
+	tc := mockcluster.NewCluster(suite.ctx, opt)
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, tc.ID, tc, false /* no need to run */)
+	oc := NewController(suite.ctx, tc.GetBasicCluster(), tc.GetSharedConfig(), stream)
+	tc.AddLeaderStore(1, 2)
+	tc.AddLeaderStore(2, 0)
+	tc.AddLeaderStore(3, 0)
+	tc.AddLeaderRegion(1, 1, 2)
+	region := tc.GetRegion(1)
+	steps := []OpStep{AddPeer{ToStore: 3, PeerID: 3}}
+	op := NewTestOperator(1, region.GetRegionEpoch(), OpRegion, steps...)
+	re.True(op.Start())
+	oc.SetOperator(op)
+	re.False(oc.checkStaleOperator(op, steps[0], region))
+	tc.UpdateStorageRatio(3, 0.99, 0.01)
+	re.True(oc.checkStaleOperator(op, steps[0], region))
+	re.Equal(CANCELED, op.Status())
+	re.Equal(StoreCapacityExceeded, op.GetCancelReason())
+
+	// the in-flight add step is not canceled.
+	op = NewTestOperator(1, region.GetRegionEpoch(), OpRegion, steps...)
+	re.True(oc.AddOperator(op))
+	region = region.Clone(
+		core.WithAddPeer(&metapb.Peer{Id: 3, StoreId: 3}),
+		core.WithPendingPeers([]*metapb.Peer{{Id: 3, StoreId: 3}}),
+		core.WithIncConfVer(),
+	)
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+	re.Equal(op, oc.GetOperator(1))
+	re.Equal(STARTED, op.Status())
+}
+
+func (suite *operatorControllerTestSuite) TestDispatchWithDependency() {
+	re := suite.Require()
+	opt := mockconfig.NewTestOptions()
+	tc := mockcluster.NewCluster(suite.ctx, opt)
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, tc.ID, tc, false /* no need to run */)
+	oc := NewController(suite.ctx, tc.GetBasicCluster(), tc.GetSharedConfig(), stream)
+	tc.AddLeaderStore(1, 2)
+	tc.AddLeaderStore(2, 0)
+	tc.AddLeaderRegion(1, 1, 2)
+	tc.AddLeaderRegion(2, 1, 2)
+	region := tc.GetRegion(1)
+	dep := NewTestOperator(2, tc.GetRegion(2).GetRegionEpoch(), OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op := NewTestOperator(1, region.GetRegionEpoch(), OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetDependency(dep)
+	re.True(oc.AddOperator(dep, op))
+
+	// waiting for the dependency.
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+	re.Equal(op, oc.GetOperator(1))
+	re.Equal(STARTED, op.Status())
+
+	// the waiting operator is kept in the notifier queue.
+	oc.Lock()
+	for oc.opNotifierQueue.Len() > 0 {
+		heap.Pop(&oc.opNotifierQueue)
+	}
+	heap.Push(&oc.opNotifierQueue, &operatorWithTime{op: op, time: time.Now()})
+	oc.Unlock()
+	r, next := oc.pollNeedDispatchRegion()
+	re.Nil(r)
+	re.True(next)
+	re.Equal(1, oc.opNotifierQueue.Len())
+	re.True(oc.opNotifierQueue[0].time.After(time.Now()))
+
+	re.True(oc.RemoveOperator(dep, AdminStop))
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+	re.Nil(oc.GetOperator(1))
+	re.Equal(CANCELED, op.Status())
+	re.Equal(DependencyFailed, op.GetCancelReason())
+}
+
+func (suite *operatorControllerTestSuite) TestAddBatchOperator() {
+	re := suite.Require()
+	opt := mockconfig.NewTestOptions()
+	tc := mockcluster.NewCluster(suite.ctx, opt)
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, tc.ID, tc, false /* no need to run */)
+	oc := NewController(suite.ctx, tc.GetBasicCluster(), tc.GetSharedConfig(), stream)
+	tc.AddLeaderStore(1, 3)
+	tc.AddLeaderStore(2, 0)
+	for i := uint64(1); i <= 3; i++ {
+		tc.AddLeaderRegion(i, 1, 2)
+	}
+	newBatch := func() *BatchOperator {
+		var ops []*Operator
+		for i := uint64(1); i <= 3; i++ {
+			ops = append(ops, NewTestOperator(i, tc.GetRegion(i).GetRegionEpoch(), OpLeader, TransferLeader{FromStore: 1, ToStore: 2}))
+		}
+		return NewBatchOperator(ops...)
+	}
+
+	// all of the operators are admitted.
+	batch := newBatch()
+	re.True(oc.AddBatchOperator(batch))
+	for i, op := range batch.Operators() {
+		re.Equal(op, oc.GetOperator(uint64(i+1)))
+	}
+
+	// the others are removed once one of them is canceled.
+	re.True(oc.RemoveOperator(batch.Operators()[0], AdminStop))
+	for i, op := range batch.Operators()[1:] {
+		re.Equal(BatchMemberFailed, op.GetCancelReason())
+		oc.Dispatch(tc.GetRegion(uint64(i+2)), DispatchFromHeartBeat, nil)
+		re.Nil(oc.GetOperator(uint64(i + 2)))
+	}
+
+	// none of the operators is admitted if one of them is rejected.
+	batch = newBatch()
+	batch.Operators()[2].regionEpoch = &metapb.RegionEpoch{Version: 100}
+	re.False(oc.AddBatchOperator(batch))
+	for i, op := range batch.Operators() {
+		re.Nil(oc.GetOperator(uint64(i + 1)))
+		re.Equal(CANCELED, op.Status())
+	}
+	re.False(oc.AddBatchOperator(NewBatchOperator()))
+
+	// the operators added are rolled back if one of them fails to be added.
+	old := NewTestOperator(1, tc.GetRegion(1).GetRegionEpoch(), OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(oc.AddOperator(old))
+	batch = newBatch()
+	batch.Operators()[0].SetPriorityLevel(constant.High)
+	// the last one is canceled when the old operator is replaced by the first one.
+	old.OnEnd(func(OpStatus) { _ = batch.Operators()[2].Cancel(AdminStop) })
+	re.False(oc.AddBatchOperator(batch))
+	re.Equal(REPLACED, old.Status())
+	for i, op := range batch.Operators() {
+		re.Nil(oc.GetOperator(uint64(i + 1)))
+		re.Equal(CANCELED, op.Status())
+	}
+	re.Equal(BatchMemberFailed, batch.Operators()[0].GetCancelReason())
+	re.Equal(BatchMemberFailed, batch.Operators()[1].GetCancelReason())
+	re.Equal(AdminStop, batch.Operators()[2].GetCancelReason())
+}
+
+func (suite *operatorControllerTestSuite) TestDispatchSoftCanceled() {
+	re := suite.Require()
+	opt := mockconfig.NewTestOptions()
+	tc := mockcluster.NewCluster(suite.ctx, opt)
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, tc.ID, tc, false /* no need to run */)
+	oc := NewController(suite.ctx, tc.GetBasicCluster(), tc.GetSharedConfig(), stream)
+	tc.AddLeaderStore(1, 2)
+	tc.AddLeaderStore(2, 0)
+	tc.AddLeaderStore(3, 0)
+	tc.AddLeaderRegion(1, 1, 2)
+	region := tc.GetRegion(1)
+	op := NewTestOperator(1, region.GetRegionEpoch(), OpRegion|OpLeader,
+		TransferLeader{FromStore: 1, ToStore: 2},
+		AddLearner{ToStore: 3, PeerID: 3},
+	)
+	re.True(oc.AddOperator(op))
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+
+	// the in-flight step is kept until it is finished.
+	op.SoftCancel()
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+	re.Equal(op, oc.GetOperator(1))
+	re.Equal(STARTED, op.Status())
+
+	region = region.Clone(core.WithLeader(region.GetStorePeer(2)))
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+	re.Nil(oc.GetOperator(1))
+	re.Equal(CANCELED, op.Status())
+	re.Equal(SoftCanceled, op.GetCancelReason())
+}
+
+func (suite *operatorControllerTestSuite) TestDispatchRetryExhausted() {
+	re := suite.Require()
+	defer func(attempts int) { MaxStepAttempts = attempts }(MaxStepAttempts)
+	MaxStepAttempts = 1
+	opt := mockconfig.NewTestOptions()
+	tc := mockcluster.NewCluster(suite.ctx, opt)
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, tc.ID, tc, false /* no need to run */)
+	oc := NewController(suite.ctx, tc.GetBasicCluster(), tc.GetSharedConfig(), stream)
+	tc.AddLeaderStore(1, 2)
+	tc.AddLeaderStore(2, 0)
+	tc.AddLeaderRegion(1, 1, 2)
+	region := tc.GetRegion(1)
+	op := NewTestOperator(1, region.GetRegionEpoch(), OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(oc.AddOperator(op))
+	unexpected := testutil.ToFloat64(operatorCounter.WithLabelValues(op.Desc(), "promote-unexpected"))
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+	re.Equal(op, oc.GetOperator(1))
+
+	op.SetStatusReachTime(STARTED, time.Now().Add(-StepAttemptInterval))
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+	re.Nil(oc.GetOperator(1))
+	re.Equal(CANCELED, op.Status())
+	re.Equal(StepRetryExhausted, op.GetCancelReason())
+	re.Equal(unexpected, testutil.ToFloat64(operatorCounter.WithLabelValues(op.Desc(), "promote-unexpected")))
+}
+
+func (suite *operatorControllerTestSuite) TestDispatchEpochCheckOnStep() {
+	re := suite.Require()
+	opt := mockconfig.NewTestOptions()
+	tc := mockcluster.NewCluster(suite.ctx, opt)
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, tc.ID, tc, false /* no need to run */)
+	oc := NewController(suite.ctx, tc.GetBasicCluster(), tc.GetSharedConfig(), stream)
+	tc.AddLeaderStore(1, 2)
+	tc.AddLeaderStore(2, 0)
+	tc.AddLeaderRegion(1, 1, 2)
+	region := tc.GetRegion(1).Clone(core.SetRegionConfVer(1), core.SetRegionVersion(1))
+	tc.PutRegion(region)
+	op := NewTestOperator(1, region.GetRegionEpoch(), OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetEpochCheckOnStep(true)
+	re.True(oc.AddOperator(op))
+	unexpected := testutil.ToFloat64(operatorCounter.WithLabelValues(op.Desc(), "promote-unexpected"))
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+	re.Equal(op, oc.GetOperator(1))
+
+	// the region is split during the operator.
+	oc.Dispatch(region.Clone(core.WithIncVersion()), DispatchFromHeartBeat, nil)
+	re.Nil(oc.GetOperator(1))
+	re.Equal(CANCELED, op.Status())
+	re.Equal(EpochNotMatch, op.GetCancelReason())
+	re.Equal(unexpected, testutil.ToFloat64(operatorCounter.WithLabelValues(op.Desc(), "promote-unexpected")))
+}
+
+func (suite *operatorControllerTestSuite) TestDispatchContextCanceled() {
+	re := suite.Require()
+	opt := mockconfig.NewTestOptions()
+	tc := mockcluster.NewCluster(suite.ctx, opt)
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, tc.ID, tc, false /* no need to run */)
+	oc := NewController(suite.ctx, tc.GetBasicCluster(), tc.GetSharedConfig(), stream)
+	tc.AddLeaderStore(1, 2)
+	tc.AddLeaderStore(2, 0)
+	tc.AddLeaderRegion(1, 1, 2)
+	region := tc.GetRegion(1)
+	op := NewTestOperator(1, region.GetRegionEpoch(), OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	ctx, cancel := context.WithCancel(suite.ctx)
+	op.WithContext(ctx)
+	re.True(oc.AddOperator(op))
+	unexpected := testutil.ToFloat64(operatorCounter.WithLabelValues(op.Desc(), "promote-unexpected"))
+
+	cancel()
+	re.Eventually(func() bool { return op.Status() == CANCELED }, time.Second, 10*time.Millisecond)
+	oc.Dispatch(region, DispatchFromHeartBeat, nil)
+	re.Nil(oc.GetOperator(1))
+	re.Equal(ContextCanceled, op.GetCancelReason())
+	re.Equal(unexpected, testutil.ToFloat64(operatorCounter.WithLabelValues(op.Desc(), "promote-unexpected")))
+}
+
 func (suite *operatorControllerTestSuite) TestCheckAddUnexpectedStatus() {
 	re := suite.Require()
 	re.NoError(failpoint.Disable("github.com/tikv/pd/pkg/schedule/operator/unexpectedOperator"))
@@ -476,6 +722,77 @@ func (suite *operatorControllerTestSuite) TestStoreLimit() {
 }
 
 // #1652
+func (suite *operatorControllerTestSuite) TestDispatchDuringFreeze() {
+	re := suite.Require()
+	cluster := mockcluster.NewCluster(suite.ctx, mockconfig.NewTestOptions())
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, cluster.ID, cluster, false /* no need to run */)
+	controller := NewController(suite.ctx, cluster.GetBasicCluster(), cluster.GetSharedConfig(), stream)
+	cluster.AddLeaderStore(1, 2)
+	cluster.AddLeaderStore(2, 0)
+	cluster.AddLeaderRegion(1, 1, 2)
+	cluster.AddLeaderRegion(2, 1, 2)
+	controller.SetFreeze(true)
+	re.True(controller.IsFrozen())
+
+	// the balance operator is held during the freeze.
+	op := NewTestOperator(1, &metapb.RegionEpoch{}, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.False(op.IsFreezeExempt())
+	re.True(controller.AddOperator(op))
+	re.Equal(0, stream.MsgLength())
+	re.Equal(PAUSED, op.Status())
+	// the held operator doesn't time out even if the freeze is longer than its timeout.
+	start := time.Now().Add(-op.Timeout() - time.Minute)
+	op.SetStatusReachTime(STARTED, start)
+	op.SetStatusReachTime(PAUSED, start.Add(time.Second))
+	controller.Dispatch(cluster.GetRegion(1), DispatchFromHeartBeat, nil)
+	re.Equal(0, stream.MsgLength())
+	re.Equal(PAUSED, op.Status())
+
+	// the failure recovery operator is still dispatched.
+	recovery := NewTestOperator(2, &metapb.RegionEpoch{}, OpReplica|OpRegion, RemovePeer{FromStore: 2})
+	re.True(recovery.IsFreezeExempt())
+	re.True(controller.AddOperator(recovery))
+	re.Equal(1, stream.MsgLength())
+
+	// the stale operator is still removed during the freeze.
+	cluster.AddLeaderRegion(3, 1, 2)
+	stale := NewTestOperator(3, &metapb.RegionEpoch{}, OpLeader, TransferLeader{FromStore: 1, ToStore: 3})
+	re.True(controller.AddOperator(stale))
+	controller.Dispatch(cluster.GetRegion(3), DispatchFromHeartBeat, nil)
+	re.Equal(CANCELED, stale.Status())
+	re.Equal(StaleStatus, stale.GetCancelReason())
+	re.Nil(controller.GetOperator(3))
+
+	// the held operator is resumed once the freeze is lifted.
+	controller.SetFreeze(false)
+	re.Equal(STARTED, op.Status())
+	controller.Dispatch(cluster.GetRegion(1), DispatchFromHeartBeat, nil)
+	re.Equal(2, stream.MsgLength())
+	re.Equal(STARTED, op.Status())
+}
+
+func (suite *operatorControllerTestSuite) TestDispatchPausedWithDeadline() {
+	re := suite.Require()
+	cluster := mockcluster.NewCluster(suite.ctx, mockconfig.NewTestOptions())
+	stream := hbstream.NewTestHeartbeatStreams(suite.ctx, cluster.ID, cluster, false /* no need to run */)
+	controller := NewController(suite.ctx, cluster.GetBasicCluster(), cluster.GetSharedConfig(), stream)
+	cluster.AddLeaderStore(1, 2)
+	cluster.AddLeaderStore(2, 0)
+	cluster.AddLeaderRegion(1, 1, 2)
+	op := NewTestOperator(1, &metapb.RegionEpoch{}, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetDeadline(time.Now().Add(time.Hour))
+	re.True(controller.AddOperator(op))
+	re.True(op.Pause())
+	controller.Dispatch(cluster.GetRegion(1), DispatchFromHeartBeat, nil)
+	re.Equal(op, controller.GetOperator(1))
+
+	// the paused operator is removed once the deadline is exceeded.
+	op.SetDeadline(time.Now().Add(-time.Second))
+	controller.Dispatch(cluster.GetRegion(1), DispatchFromHeartBeat, nil)
+	re.Equal(TIMEOUT, op.Status())
+	re.Nil(controller.GetOperator(1))
+}
+
 func (suite *operatorControllerTestSuite) TestDispatchOutdatedRegion() {
 	re := suite.Require()
 	cluster := mockcluster.NewCluster(suite.ctx, mockconfig.NewTestOptions())
@@ -15,13 +15,19 @@
 package operator
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/pd/pkg/core"
@@ -288,6 +294,529 @@ func (suite *operatorTestSuite) TestInfluence() {
 	}, *storeOpInfluence[2])
 }
 
+func (suite *operatorTestSuite) TestUnfinishedInfluenceOnStores() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op := suite.newTestOperator(1, OpRegion|OpLeader,
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		RemovePeer{FromStore: 1},
+	)
+	all := *NewOpInfluence()
+	op.UnfinishedInfluence(all, region)
+
+	opInfluence := *NewOpInfluence()
+	opInfluence.GetStoreInfluence(3).RegionCount = 10
+	op.UnfinishedInfluenceOnStores(opInfluence, region, map[uint64]struct{}{1: {}, 3: {}, 4: {}})
+	re.Len(opInfluence.StoresInfluence, 2)
+	re.Equal(all.StoresInfluence[1].RegionCount, opInfluence.StoresInfluence[1].RegionCount)
+	re.Equal(all.StoresInfluence[1].LeaderCount, opInfluence.StoresInfluence[1].LeaderCount)
+	re.Equal(all.StoresInfluence[1].GetStepCost(storelimit.RemovePeer), opInfluence.StoresInfluence[1].GetStepCost(storelimit.RemovePeer))
+	re.Equal(all.StoresInfluence[3].RegionCount+10, opInfluence.StoresInfluence[3].RegionCount)
+	re.Equal(all.StoresInfluence[3].RegionSize, opInfluence.StoresInfluence[3].RegionSize)
+
+	opInfluence = *NewOpInfluence()
+	op.UnfinishedInfluenceOnStores(opInfluence, region, nil)
+	re.Empty(opInfluence.StoresInfluence)
+}
+
+func (suite *operatorTestSuite) TestInfluenceDiff() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	before := *NewOpInfluence()
+	before.GetStoreInfluence(1).LeaderCount = 10
+	re.False(op.InfluenceDiff(before, before, region))
+
+	after := *NewOpInfluence()
+	after.GetStoreInfluence(1).LeaderCount = 9
+	after.GetStoreInfluence(1).LeaderSize = -region.GetApproximateSize()
+	after.GetStoreInfluence(2).LeaderCount = 1
+	after.GetStoreInfluence(2).LeaderSize = region.GetApproximateSize()
+	re.True(op.InfluenceDiff(before, after, region))
+	_, ok := before.StoresInfluence[2]
+	re.False(ok)
+
+	// the size out of the tolerance is not matched.
+	after.GetStoreInfluence(2).LeaderSize = region.GetApproximateSize() * 2
+	re.False(op.InfluenceDiff(before, after, region))
+}
+
+func (suite *operatorTestSuite) TestLeaderInfluence() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2}, [2]uint64{3, 3})
+	op := suite.newTestOperator(1, OpLeader|OpRegion,
+		AddLearner{ToStore: 4, PeerID: 4},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		RemovePeer{FromStore: 1, PeerID: 1},
+	)
+	re.Equal(map[uint64]int{1: -1, 2: 1}, op.LeaderInfluence(region))
+
+	// the intermediate store is omitted.
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2}, TransferLeader{FromStore: 2, ToStore: 3})
+	re.Equal(map[uint64]int{1: -1, 3: 1}, op.LeaderInfluence(region))
+
+	op = suite.newTestOperator(1, OpLeader, TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2, 3}})
+	re.Equal(map[uint64]int{1: -1, 2: 1}, op.LeaderInfluence(region))
+	re.Equal(map[uint64]int{1: -1, 2: 1}, op.LeaderInfluence(nil))
+	transferred := region.Clone(core.WithLeader(region.GetStorePeer(3)))
+	re.Equal(map[uint64]int{1: -1, 3: 1}, op.LeaderInfluence(transferred))
+
+	// TransferLeader without ToStore is attributed like the one with candidates.
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStores: []uint64{2, 3}})
+	re.Equal(map[uint64]int{1: -1, 2: 1}, op.LeaderInfluence(region))
+	re.Equal(map[uint64]int{1: -1, 3: 1}, op.LeaderInfluence(transferred))
+
+	// the step without target store is ignored, and the store 0 is not reported.
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1})
+	re.Empty(op.LeaderInfluence(region))
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{ToStore: 2})
+	re.Equal(map[uint64]int{2: 1}, op.LeaderInfluence(region))
+
+	op = suite.newTestOperator(1, OpRegion, AddLearner{ToStore: 4, PeerID: 4})
+	re.Empty(op.LeaderInfluence(region))
+}
+
+func (suite *operatorTestSuite) TestTotalInfluenceSized() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op := NewOperator("test", "test", 1, &metapb.RegionEpoch{}, OpRegion, 1000,
+		AddPeer{ToStore: 3, PeerID: 3},
+		RemovePeer{FromStore: 1, PeerID: 1},
+	)
+	opInfluence := *NewOpInfluence()
+	op.TotalInfluenceSized(opInfluence, region)
+	re.Equal(int64(1000), opInfluence.GetStoreInfluence(3).RegionSize)
+	re.Equal(int64(-1000), opInfluence.GetStoreInfluence(1).RegionSize)
+	re.Equal(storelimit.RegionInfluence[storelimit.AddPeer], opInfluence.GetStoreInfluence(3).GetStepCost(storelimit.AddPeer))
+	// the region itself is not changed.
+	re.Equal(int64(50), region.GetApproximateSize())
+
+	// fall back to the size of the region.
+	op.ApproximateSize = 0
+	opInfluence = *NewOpInfluence()
+	op.TotalInfluenceSized(opInfluence, region)
+	re.Equal(region.GetApproximateSize(), opInfluence.GetStoreInfluence(3).RegionSize)
+
+	opInfluence = *NewOpInfluence()
+	op.TotalInfluenceSized(opInfluence, nil)
+	re.Empty(opInfluence.StoresInfluence)
+}
+
+func (suite *operatorTestSuite) TestPlannedInfluence() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.Nil(op.PlannedInfluence())
+	op.SnapshotPlannedInfluence(nil)
+	re.Nil(op.PlannedInfluence())
+
+	op.SnapshotPlannedInfluence(region)
+	planned := op.PlannedInfluence()
+	re.Equal(int64(-1), planned.GetStoreInfluence(1).LeaderCount)
+	re.Equal(int64(1), planned.GetStoreInfluence(2).LeaderCount)
+	re.Equal(region.GetApproximateSize(), planned.GetStoreInfluence(2).LeaderSize)
+
+	// the planned influence is frozen.
+	planned.GetStoreInfluence(2).LeaderCount = 10
+	op.SnapshotPlannedInfluence(suite.newTestRegion(1, 2, [2]uint64{1, 1}, [2]uint64{2, 2}))
+	re.Equal(int64(1), op.PlannedInfluence().GetStoreInfluence(2).LeaderCount)
+	re.Equal(int64(1), op.Clone().PlannedInfluence().GetStoreInfluence(2).LeaderCount)
+}
+
+func (suite *operatorTestSuite) TestAccumulateUnfinishedInfluence() {
+	re := suite.Require()
+	regions := map[uint64]*core.RegionInfo{
+		1: suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2}),
+		2: suite.newTestRegion(2, 3, [2]uint64{1, 3}, [2]uint64{2, 4}),
+	}
+	ops := []*Operator{
+		suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2}),
+		suite.newTestOperator(2, OpLeader, TransferLeader{FromStore: 1, ToStore: 2}),
+		// the region is not found.
+		suite.newTestOperator(3, OpRegion, AddPeer{ToStore: 3, PeerID: 5}),
+	}
+	influence := *NewOpInfluence()
+	AccumulateUnfinishedInfluence(ops, influence, func(id uint64) *core.RegionInfo { return regions[id] })
+
+	expected := *NewOpInfluence()
+	ops[0].UnfinishedInfluence(expected, regions[1])
+	ops[1].UnfinishedInfluence(expected, regions[2])
+	re.Equal(expected, influence)
+	re.Equal(int64(-2), influence.GetStoreInfluence(1).LeaderCount)
+	re.Equal(int64(2), influence.GetStoreInfluence(2).LeaderCount)
+	re.Nil(influence.StoresInfluence[3])
+}
+
+func (suite *operatorTestSuite) TestRetryable() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(op.Retryable())
+	re.True(op.Start())
+	re.True(op.Cancel(EpochNotMatch))
+	re.True(op.Retryable())
+
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(op.Start())
+	re.True(op.Cancel(RegionNotFound))
+	re.False(op.Retryable())
+	re.False(op.Clone().Retryable())
+
+	op = suite.newTestOperator(1, OpLeader|OpAdmin, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(op.Start())
+	op.SetStatusReachTime(STARTED, time.Now().Add(-op.Timeout()-time.Second))
+	re.True(op.CheckTimeout())
+	re.False(op.Retryable())
+}
+
+func (suite *operatorTestSuite) TestDeadline() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(op.GetDeadline().IsZero())
+	deadline := time.Now().Add(time.Minute)
+	op.SetDeadline(deadline)
+	re.True(deadline.Equal(op.GetDeadline()))
+	re.True(op.Start())
+	re.False(op.CheckTimeout())
+
+	// the deadline comes before the timeout.
+	op.SetDeadline(time.Now().Add(-time.Second))
+	re.True(op.CheckTimeout())
+	re.Equal(TIMEOUT, op.Status())
+
+	// the timeout comes before the deadline.
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetDeadline(time.Now().Add(time.Hour))
+	re.True(op.Start())
+	op.SetStatusReachTime(STARTED, time.Now().Add(-op.Timeout()-time.Second))
+	re.True(op.CheckTimeout())
+
+	// the paused operator is timeout once the deadline is exceeded.
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetDeadline(time.Now().Add(time.Hour))
+	re.True(op.Start())
+	re.True(op.Pause())
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	re.Nil(op.Check(region))
+	re.Equal(PAUSED, op.Status())
+	op.SetDeadline(time.Now().Add(-time.Second))
+	re.Nil(op.Check(region))
+	re.Equal(TIMEOUT, op.Status())
+
+	// the operator is expired if it is not started before the deadline.
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.False(op.CheckExpired())
+	op.SetDeadline(time.Now().Add(-time.Second))
+	re.True(op.CheckExpired())
+	re.Equal(EXPIRED, op.Status())
+
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetDeadline(time.Now())
+	op.SetDeadline(time.Time{})
+	re.True(op.GetDeadline().IsZero())
+	re.True(op.Start())
+	re.False(op.CheckTimeout())
+}
+
+func (suite *operatorTestSuite) TestGetFinishTime() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(op.GetFinishTime().IsZero())
+	re.True(op.Start())
+	re.True(op.GetFinishTime().IsZero())
+	re.Nil(op.Check(suite.newTestRegion(1, 2, [2]uint64{1, 1}, [2]uint64{2, 2})))
+	re.Equal(SUCCESS, op.Status())
+	re.Equal(op.GetReachTimeOf(SUCCESS), op.GetFinishTime())
+	re.False(op.GetFinishTime().Before(op.GetStartTime()))
+
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(op.Start())
+	re.True(op.Cancel(AdminStop))
+	re.Equal(op.GetReachTimeOf(CANCELED), op.GetFinishTime())
+	re.False(op.GetFinishTime().IsZero())
+}
+
+func (suite *operatorTestSuite) TestVoterDelta() {
+	re := suite.Require()
+	testCases := []struct {
+		steps    []OpStep
+		expected int
+	}{
+		{[]OpStep{TransferLeader{FromStore: 1, ToStore: 2}}, 0},
+		{[]OpStep{AddPeer{ToStore: 3, PeerID: 3}}, 1},
+		{[]OpStep{AddLearner{ToStore: 3, PeerID: 3}, PromoteLearner{ToStore: 3, PeerID: 3}, RemovePeer{FromStore: 1, PeerID: 1}}, 0},
+		{[]OpStep{AddLearner{ToStore: 3, PeerID: 3}, RemovePeer{FromStore: 3, PeerID: 3}}, 0},
+		{[]OpStep{DemoteVoterToLearner{StoreID: 1}, RemovePeer{FromStore: 1, PeerID: 1}}, -1},
+		{[]OpStep{RemovePeer{FromStore: 1, PeerID: 1}}, -1},
+		{[]OpStep{AddLearner{ToStore: 3, PeerID: 3}, RemoveLearners{StoreIDs: []uint64{3}}}, 0},
+		{[]OpStep{
+			AddLearner{ToStore: 3, PeerID: 3},
+			ChangePeerV2Enter{
+				PromoteLearners: []PromoteLearner{{ToStore: 3, PeerID: 3}},
+				DemoteVoters:    []DemoteVoter{{ToStore: 1, PeerID: 1}, {ToStore: 2, PeerID: 2}},
+			},
+			ChangePeerV2Leave{
+				PromoteLearners: []PromoteLearner{{ToStore: 3, PeerID: 3}},
+				DemoteVoters:    []DemoteVoter{{ToStore: 1, PeerID: 1}, {ToStore: 2, PeerID: 2}},
+			},
+			RemovePeer{FromStore: 1, PeerID: 1},
+		}, -1},
+	}
+	for i, testCase := range testCases {
+		op := suite.newTestOperator(1, OpRegion, testCase.steps...)
+		re.Equal(testCase.expected, op.VoterDelta(), "case %d", i)
+	}
+}
+
+func (suite *operatorTestSuite) TestLongestStep() {
+	re := suite.Require()
+	steps := []OpStep{
+		TransferLeader{FromStore: 1, ToStore: 2},
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		AddLearner{ToStore: 4, PeerID: 4},
+	}
+	op := suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	index, step, budget := op.LongestStep()
+	re.Equal(1, index)
+	re.Equal(steps[1], step)
+	re.Equal(steps[1].Timeout(op.ApproximateSize), budget)
+	re.Greater(budget, steps[0].Timeout(op.ApproximateSize))
+	re.Equal(int32(0), op.currentStep)
+
+	op = NewOperator("test", "test", 1, &metapb.RegionEpoch{}, OpLeader, 0)
+	index, step, budget = op.LongestStep()
+	re.Equal(-1, index)
+	re.Nil(step)
+	re.Zero(budget)
+}
+
+func (suite *operatorTestSuite) TestStepStatuses() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	steps := []OpStep{
+		TransferLeader{FromStore: 2, ToStore: 1},
+		AddLearner{ToStore: 3, PeerID: 3},
+		RemovePeer{FromStore: 2},
+	}
+	op := suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	re.True(op.Start())
+	// the leader is already on store 1, so the first step is skipped.
+	re.Equal(steps[1], op.Check(region))
+	statuses := op.StepStatuses()
+	re.Len(statuses, 3)
+	re.True(statuses[0].Finished)
+	re.True(statuses[0].Skipped)
+	re.False(statuses[0].FinishTime.IsZero())
+	re.False(statuses[1].Finished)
+	re.False(statuses[1].Skipped)
+	re.True(statuses[1].FinishTime.IsZero())
+
+	region = region.Clone(core.WithAddPeer(&metapb.Peer{Id: 3, StoreId: 3, Role: metapb.PeerRole_Learner}))
+	re.Equal(steps[2], op.Check(region))
+	statuses = op.StepStatuses()
+	re.True(statuses[1].Finished)
+	re.False(statuses[1].Skipped)
+	re.Equal(steps[2], statuses[2].Step)
+	re.False(statuses[2].Finished)
+}
+
+func (suite *operatorTestSuite) TestReorderStepsByInfluence() {
+	re := suite.Require()
+	opInfluence := *NewOpInfluence()
+	opInfluence.GetStoreInfluence(3).RegionSize = 100
+	opInfluence.GetStoreInfluence(4).RegionSize = 10
+	opInfluence.GetStoreInfluence(5).RegionSize = 10
+	opInfluence.GetStoreInfluence(5).RegionCount = 1
+	op := suite.newTestOperator(1, OpRegion|OpLeader,
+		AddLearner{ToStore: 3, PeerID: 3},
+		AddLearner{ToStore: 5, PeerID: 5},
+		AddLearner{ToStore: 4, PeerID: 4},
+		AddLearner{ToStore: 6, PeerID: 6},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 3},
+		AddLearner{ToStore: 4, PeerID: 7},
+		RemovePeer{FromStore: 1},
+	)
+	op.ReorderStepsByInfluence(opInfluence)
+	re.Equal([]OpStep{
+		AddLearner{ToStore: 6, PeerID: 6},
+		AddLearner{ToStore: 4, PeerID: 4},
+		AddLearner{ToStore: 5, PeerID: 5},
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 3},
+		AddLearner{ToStore: 4, PeerID: 7},
+		RemovePeer{FromStore: 1},
+	}, op.steps)
+
+	// the started operator is not reordered.
+	op = suite.newTestOperator(1, OpRegion,
+		AddLearner{ToStore: 3, PeerID: 3},
+		AddLearner{ToStore: 4, PeerID: 4},
+	)
+	re.True(op.Start())
+	op.ReorderStepsByInfluence(opInfluence)
+	re.Equal(AddLearner{ToStore: 3, PeerID: 3}, op.steps[0])
+}
+
+func (suite *operatorTestSuite) TestStepsHash() {
+	re := suite.Require()
+	op1 := suite.newTestOperator(1, OpRegion, AddLearner{ToStore: 3, PeerID: 3}, RemovePeer{FromStore: 1, PeerID: 1})
+	// the peer IDs and the status are excluded.
+	op2 := suite.newTestOperator(2, OpRegion, AddLearner{ToStore: 3, PeerID: 5}, RemovePeer{FromStore: 1, PeerID: 1})
+	re.True(op2.Start())
+	re.Equal(op1.StepsHash(), op2.StepsHash())
+
+	hashes := map[uint64]struct{}{op1.StepsHash(): {}}
+	for _, op := range []*Operator{
+		suite.newTestOperator(1, OpRegion, AddLearner{ToStore: 4, PeerID: 3}, RemovePeer{FromStore: 1, PeerID: 1}),
+		suite.newTestOperator(1, OpRegion, AddPeer{ToStore: 3, PeerID: 3}, RemovePeer{FromStore: 1, PeerID: 1}),
+		suite.newTestOperator(1, OpRegion, RemovePeer{FromStore: 1, PeerID: 1}, AddLearner{ToStore: 3, PeerID: 3}),
+		suite.newTestOperator(1, OpLeader, TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2}}),
+		suite.newTestOperator(1, OpLeader, TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{3}}),
+		suite.newTestOperator(1, OpMerge, MergeRegion{FromRegion: &metapb.Region{Id: 1}, ToRegion: &metapb.Region{Id: 2}}),
+		suite.newTestOperator(1, OpMerge, MergeRegion{FromRegion: &metapb.Region{Id: 1}, ToRegion: &metapb.Region{Id: 3}}),
+	} {
+		hashes[op.StepsHash()] = struct{}{}
+	}
+	re.Len(hashes, 8)
+}
+
+func (suite *operatorTestSuite) TestStepEvents() {
+	re := suite.Require()
+	promote := []PromoteLearner{{ToStore: 3, PeerID: 3}}
+	demote := []DemoteVoter{{ToStore: 1, PeerID: 1}}
+	op := suite.newTestOperator(1, OpRegion|OpLeader,
+		AddLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		ChangePeerV2Enter{PromoteLearners: promote, DemoteVoters: demote},
+		ChangePeerV2Leave{PromoteLearners: promote, DemoteVoters: demote},
+		RemovePeer{FromStore: 1, PeerID: 1},
+		MergeRegion{FromRegion: &metapb.Region{Id: 1}, ToRegion: &metapb.Region{Id: 2}},
+	)
+	re.Equal([]StepEvent{
+		{Type: "AddLearner", ToStore: 3, ConfVerDelta: 1},
+		{Type: "TransferLeader", FromStore: 1, ToStore: 2},
+		{Type: "ChangePeerV2Enter", FromStore: 3, ToStore: 3, ConfVerDelta: 1},
+		{Type: "ChangePeerV2Enter", FromStore: 1, ToStore: 1, ConfVerDelta: 1},
+		{Type: "ChangePeerV2Leave", FromStore: 3, ToStore: 3, ConfVerDelta: 1},
+		{Type: "ChangePeerV2Leave", FromStore: 1, ToStore: 1, ConfVerDelta: 1},
+		{Type: "RemovePeer", FromStore: 1, ConfVerDelta: 1},
+		{Type: "MergeRegion"},
+	}, op.StepEvents())
+
+	// the sum of the deltas is the change of the conf version.
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op = suite.newTestOperator(1, OpRegion,
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		RemovePeer{FromStore: 1, PeerID: 1},
+	)
+	for i := 0; !op.IsEnd() && i < 10; i++ {
+		region, _ = op.SimulateStep(region)
+	}
+	var total uint64
+	for _, event := range op.StepEvents() {
+		total += event.ConfVerDelta
+	}
+	re.Equal(op.ConfVerChanged(region), total)
+}
+
+func (suite *operatorTestSuite) TestConflicts() {
+	re := suite.Require()
+	addPeer := suite.newTestOperator(1, OpRegion, AddLearner{ToStore: 3, PeerID: 3}, PromoteLearner{ToStore: 3, PeerID: 3})
+	removePeer := suite.newTestOperator(1, OpRegion, RemovePeer{FromStore: 3, PeerID: 3})
+	re.True(addPeer.Conflicts(removePeer))
+	re.True(removePeer.Conflicts(addPeer))
+	re.False(addPeer.Conflicts(nil))
+
+	// on different regions or stores.
+	re.False(addPeer.Conflicts(suite.newTestOperator(2, OpRegion, RemovePeer{FromStore: 3, PeerID: 3})))
+	re.False(addPeer.Conflicts(suite.newTestOperator(1, OpRegion, RemovePeer{FromStore: 4, PeerID: 4})))
+	re.False(addPeer.Conflicts(suite.newTestOperator(1, OpRegion, AddLearner{ToStore: 3, PeerID: 5})))
+
+	// transfer leader in the opposite directions.
+	transfer := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(transfer.Conflicts(suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})))
+	re.False(transfer.Conflicts(suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 3})))
+	re.False(transfer.Conflicts(transfer))
+}
+
+func (suite *operatorTestSuite) TestContainsStepFunc() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpRegion,
+		AddLearner{ToStore: 3, PeerID: 3},
+		RemovePeer{FromStore: 1},
+	)
+	isRemovePeer := func(step OpStep) bool {
+		_, ok := step.(RemovePeer)
+		return ok
+	}
+	re.True(op.ContainsStepFunc(isRemovePeer))
+	re.False(op.ContainsStepFunc(func(step OpStep) bool {
+		_, ok := step.(TransferLeader)
+		return ok
+	}))
+	re.False(op.ContainNonWitnessStep())
+
+	op = suite.newTestOperator(1, OpRegion|OpWitness, BecomeNonWitness{StoreID: 2, PeerID: 2})
+	re.True(op.ContainNonWitnessStep())
+	re.False(op.ContainsStepFunc(isRemovePeer))
+}
+
+func (suite *operatorTestSuite) TestStepSummary() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpRegion|OpLeader,
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		AddLearner{ToStore: 4, PeerID: 4},
+		TransferLeader{FromStore: 1, ToStore: 3},
+		RemovePeer{FromStore: 1},
+	)
+	re.Equal(map[string]int{
+		"AddLearner":     2,
+		"PromoteLearner": 1,
+		"TransferLeader": 1,
+		"RemovePeer":     1,
+	}, op.StepSummary())
+}
+
+func (suite *operatorTestSuite) TestValidate() {
+	re := suite.Require()
+	testCases := []struct {
+		steps []OpStep
+		valid bool
+	}{
+		{[]OpStep{AddLearner{ToStore: 3, PeerID: 3}, PromoteLearner{ToStore: 3, PeerID: 3}, TransferLeader{FromStore: 1, ToStore: 3}, RemovePeer{FromStore: 1}}, true},
+		{[]OpStep{RemovePeer{FromStore: 1}, AddLearner{ToStore: 1, PeerID: 4}}, true},
+		{[]OpStep{AddPeer{ToStore: 3, PeerID: 3}, AddPeer{ToStore: 3, PeerID: 4}}, false},
+		{[]OpStep{RemovePeer{FromStore: 2}, RemovePeer{FromStore: 2}}, false},
+		{[]OpStep{RemovePeer{FromStore: 2}, TransferLeader{FromStore: 1, ToStore: 2}}, false},
+		{[]OpStep{AddLearner{ToStore: 3, PeerID: 3}, TransferLeader{FromStore: 1, ToStore: 3}}, false},
+		{[]OpStep{AddPeer{ToStore: 3, PeerID: 3}, PromoteLearner{ToStore: 3, PeerID: 3}}, false},
+		{[]OpStep{DemoteVoterToLearner{StoreID: 2}, TransferLeader{FromStore: 2, ToStore: 1}}, false},
+		{[]OpStep{AddLearner{ToStore: 3, PeerID: 3}, RemoveLearners{StoreIDs: []uint64{2, 3}}}, true},
+		{[]OpStep{AddPeer{ToStore: 3, PeerID: 3}, RemoveLearners{StoreIDs: []uint64{3}}}, false},
+		{[]OpStep{
+			AddLearner{ToStore: 3, PeerID: 3},
+			ChangePeerV2Enter{PromoteLearners: []PromoteLearner{{ToStore: 3, PeerID: 3}}, DemoteVoters: []DemoteVoter{{ToStore: 1, PeerID: 1}}},
+			TransferLeader{FromStore: 1, ToStore: 3},
+			ChangePeerV2Leave{PromoteLearners: []PromoteLearner{{ToStore: 3, PeerID: 3}}, DemoteVoters: []DemoteVoter{{ToStore: 1, PeerID: 1}}},
+			RemovePeer{FromStore: 1},
+		}, true},
+	}
+	for i, testCase := range testCases {
+		op := suite.newTestOperator(1, OpRegion, testCase.steps...)
+		if testCase.valid {
+			re.NoError(op.Validate(), i)
+		} else {
+			re.Error(op.Validate(), i)
+		}
+	}
+}
+
 func (suite *operatorTestSuite) TestOperatorKind() {
 	re := suite.Require()
 	re.Equal("replica,leader", (OpLeader | OpReplica).String())
@@ -299,6 +828,15 @@ func (suite *operatorTestSuite) TestOperatorKind() {
 	re.NoError(err)
 	_, err = ParseOperatorKind("foobar")
 	re.Error(err)
+	k, err = ParseOperatorKind("witness,region")
+	re.NoError(err)
+	re.Equal(OpWitness|OpRegion, k)
+	re.Equal("region,witness", k.String())
+
+	op := suite.newTestOperator(1, OpRegion|OpWitness, BecomeWitness{StoreID: 1, PeerID: 1})
+	re.Equal(OpRegion, op.SchedulerKind())
+	op = suite.newTestOperator(1, OpAdmin|OpRegion|OpWitness, BecomeWitness{StoreID: 1, PeerID: 1})
+	re.Equal(OpAdmin, op.SchedulerKind())
 }
 
 func (suite *operatorTestSuite) TestCheckSuccess() {
@@ -315,6 +853,8 @@ func (suite *operatorTestSuite) TestCheckSuccess() {
 		re.True(op.Start())
 		re.False(op.CheckSuccess())
 		op.currentStep = int32(len(op.steps))
+		re.True(op.AllStepsDone())
+		re.Equal(STARTED, op.Status())
 		re.True(op.CheckSuccess())
 		re.True(op.CheckSuccess())
 	}
@@ -378,6 +918,37 @@ func (suite *operatorTestSuite) TestStart() {
 	re.True(op.Start())
 	re.NotEqual(0, op.GetStartTime().Nanosecond())
 	re.Equal(STARTED, op.Status())
+	re.False(op.Start())
+
+	// can't start an ended operator.
+	op = suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	re.True(op.Cancel(AdminStop))
+	re.False(op.Start())
+	re.Equal(CANCELED, op.Status())
+	re.True(op.GetStartTime().IsZero())
+
+	// the operator is never reported as STARTED after it is canceled.
+	for i := 0; i < 100; i++ {
+		op = suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+		var (
+			wg      sync.WaitGroup
+			started bool
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			started = op.Start()
+		}()
+		go func() {
+			defer wg.Done()
+			op.Cancel(AdminStop)
+		}()
+		wg.Wait()
+		re.Equal(CANCELED, op.Status())
+		re.Equal(started, !op.GetStartTime().IsZero())
+		re.False(op.Start())
+		re.Equal(CANCELED, op.Status())
+	}
 }
 
 func (suite *operatorTestSuite) TestCheckExpired() {
@@ -393,6 +964,79 @@ func (suite *operatorTestSuite) TestCheckExpired() {
 	op.SetStatusReachTime(CREATED, time.Now().Add(-OperatorExpireTime))
 	re.True(op.CheckExpired())
 	re.Equal(EXPIRED, op.Status())
+
+	// the admin operator never expires.
+	op = suite.newTestOperator(1, OpLeader|OpRegion|OpAdmin, steps...)
+	re.True(op.IsAdmin())
+	op.SetStatusReachTime(CREATED, time.Now().Add(-10*time.Second))
+	re.False(op.CheckExpired())
+	re.Equal(CREATED, op.Status())
+	re.True(op.Start())
+	op.SetStatusReachTime(STARTED, time.Now().Add(-op.Timeout()-time.Second))
+	re.True(op.CheckTimeout())
+	re.Equal(TIMEOUT, op.Status())
+}
+
+func (suite *operatorTestSuite) TestClearKind() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpAdmin|OpLeader|OpRegion, TransferLeader{FromStore: 2, ToStore: 1})
+	re.Equal(constant.Urgent, op.GetPriorityLevel())
+	re.Equal(OpAdmin, op.SchedulerKind())
+
+	op.ClearKind(OpRegion)
+	re.Equal(OpAdmin|OpLeader, op.Kind())
+	re.Equal(constant.Urgent, op.GetPriorityLevel())
+
+	op.ClearKind(OpAdmin)
+	re.False(op.IsAdmin())
+	re.Equal(OpLeader, op.Kind())
+	re.Equal(OpLeader, op.SchedulerKind())
+	re.Equal(constant.Medium, op.GetPriorityLevel())
+
+	// the level is kept if OpAdmin is not set.
+	op.SetPriorityLevel(constant.High)
+	op.ClearKind(OpAdmin | OpLeader)
+	re.Equal(OpKind(0), op.Kind())
+	re.Equal(constant.High, op.GetPriorityLevel())
+}
+
+func (suite *operatorTestSuite) TestEndStatusPredicates() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.False(op.IsEnd())
+	re.False(op.IsReplaced())
+	re.False(op.IsCanceled())
+
+	for _, st := range []OpStatus{SUCCESS, CANCELED, REPLACED, EXPIRED, TIMEOUT} {
+		op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+		if st != EXPIRED {
+			re.True(op.Start())
+			re.False(op.IsEnd())
+		}
+		re.True(op.status.To(st))
+		re.True(op.IsEnd())
+		re.Equal(st == REPLACED, op.IsReplaced(), OpStatusToString(st))
+		re.Equal(st == CANCELED, op.IsCanceled(), OpStatusToString(st))
+	}
+}
+
+func (suite *operatorTestSuite) TestIsQueued() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.IsQueued())
+	op.SetStatusReachTime(CREATED, time.Now().Add(-OperatorExpireTime))
+	re.False(op.IsQueued())
+	re.Equal(CREATED, op.Status())
+
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op.SetDeadline(time.Now().Add(-time.Second))
+	re.False(op.IsQueued())
+
+	op = suite.newTestOperator(1, OpLeader|OpAdmin, TransferLeader{FromStore: 2, ToStore: 1})
+	op.SetStatusReachTime(CREATED, time.Now().Add(-OperatorExpireTime))
+	re.True(op.IsQueued())
+	re.True(op.Start())
+	re.False(op.IsQueued())
 }
 
 func (suite *operatorTestSuite) TestCheck() {
@@ -542,6 +1186,36 @@ func (suite *operatorTestSuite) TestRecord() {
 	re.Greater(ob.duration.Seconds(), time.Second.Seconds())
 }
 
+func (suite *operatorTestSuite) TestRecordMarshalJSON() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.Start())
+	re.True(op.Cancel(AdminStop))
+	record := op.Record(op.GetReachTimeOf(CANCELED))
+	re.Equal(OpRecordVersion, record.Version)
+	// The record is still marshaled as a string by default.
+	data, err := json.Marshal(record)
+	re.NoError(err)
+	var str string
+	re.NoError(json.Unmarshal(data, &str))
+	re.Equal(record.String(), str)
+
+	data, err = json.Marshal(VersionedRecords([]*OpRecord{record}))
+	re.NoError(err)
+	var objs []map[string]any
+	re.NoError(json.Unmarshal(data, &objs))
+	re.Len(objs, 1)
+	obj := objs[0]
+	re.Equal(float64(OpRecordVersion), obj["version"])
+	re.Equal("test", obj["desc"])
+	re.Equal(float64(1), obj["region_id"])
+	re.Equal(OpStatusToString(CANCELED), obj["status"])
+	re.Equal(record.duration.String(), obj["duration"])
+	re.Equal(record.String(), obj["string"])
+	re.Contains(obj, "finish_time")
+	re.Len(obj["steps"], 1)
+}
+
 func (suite *operatorTestSuite) TestToJSONObject() {
 	steps := []OpStep{
 		AddPeer{ToStore: 1, PeerID: 1},
@@ -574,3 +1248,1558 @@ func (suite *operatorTestSuite) TestToJSONObject() {
 	obj = op.ToJSONObject()
 	suite.Equal(TIMEOUT, obj.Status)
 }
+
+func (suite *operatorTestSuite) TestMarshalStructuredJSON() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 1, PeerID: 1},
+		TransferLeader{FromStore: 3, ToStore: 1},
+		RemovePeer{FromStore: 3},
+	}
+	op := NewTestOperator(101, nil, OpLeader|OpRegion, steps...)
+	op.Start()
+	data, err := op.MarshalStructuredJSON()
+	re.NoError(err)
+	obj := &OpStructuredObject{}
+	re.NoError(json.Unmarshal(data, obj))
+	re.Equal(op.ToStructuredJSONObject(), obj)
+	re.Equal(op.GetID(), obj.ID)
+	re.Equal("test", obj.Desc)
+	re.Equal(uint64(101), obj.RegionID)
+	re.Nil(obj.RegionEpoch)
+	re.Equal("region,leader", obj.Kind)
+	re.Equal("Started", obj.Status)
+	re.Equal(int64(mockRegionSize), obj.ApproximateSize)
+	re.Len(obj.Steps, 3)
+	re.Equal("AddPeer", obj.Steps[0].Type)
+	re.Equal(steps[1].String(), obj.Steps[1].Desc)
+
+	// The timed out operator is not changed by the serialization.
+	op.SetStatusReachTime(STARTED, op.GetStartTime().Add(-op.Timeout()-time.Second))
+	_, err = op.MarshalStructuredJSON()
+	re.NoError(err)
+	re.Equal("Started", op.ToStructuredJSONObject().Status)
+	re.Equal(STARTED, op.Status())
+	re.True(op.CheckTimeout())
+	re.Equal("Timeout", op.ToStructuredJSONObject().Status)
+}
+
+func (suite *operatorTestSuite) TestClone() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 1, PeerID: 1},
+		TransferLeader{FromStore: 3, ToStore: 1},
+		RemovePeer{FromStore: 3},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	op.AdditionalInfos["foo"] = "bar"
+	re.True(op.Start())
+	region := suite.newTestRegion(1, 3, [2]uint64{1, 1}, [2]uint64{3, 3})
+	re.Equal(steps[1], op.Check(region))
+
+	clone := op.Clone()
+	re.Greater(clone.GetID(), op.GetID())
+	re.Equal(CREATED, clone.Status())
+	re.Equal(op.Len(), clone.Len())
+	re.Equal(op.AdditionalInfos, clone.AdditionalInfos)
+	// the progress is reset along with the status.
+	re.Equal(1, op.CurrentStepIndex())
+	re.Zero(clone.CurrentStepIndex())
+	re.Equal(make([]int64, len(steps)), clone.stepsTime)
+	re.Zero(clone.StepAttempts(1))
+	re.True(clone.Start())
+	re.Equal(steps[0], clone.Check(suite.newTestRegion(1, 3, [2]uint64{3, 3})))
+
+	clone.steps[0] = RemovePeer{FromStore: 2}
+	clone.stepsTime[1] = 1
+	clone.AdditionalInfos["foo"] = "baz"
+	re.Equal(steps[0], op.Step(0))
+	re.Zero(op.stepsTime[1])
+	re.Equal("bar", op.AdditionalInfos["foo"])
+	re.Equal(STARTED, op.Status())
+
+	// the dependency, the planned influence and the parallel groups are not shared.
+	dep := suite.newTestOperator(2, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op = suite.newTestOperator(1, OpRegion, AddLearner{ToStore: 2, PeerID: 2}, AddLearner{ToStore: 4, PeerID: 4})
+	op.SetDependency(dep)
+	re.NoError(op.SetParallelGroups([][]int{{0, 1}}))
+	op.SnapshotPlannedInfluence(region)
+	clone = op.Clone()
+	re.NotSame(dep, clone.dependency)
+	re.Equal(dep.Desc(), clone.dependency.Desc())
+	re.NotSame(op.plannedInfluence, clone.plannedInfluence)
+	re.Equal(op.plannedInfluence, clone.plannedInfluence)
+	clone.plannedInfluence.GetStoreInfluence(2).RegionCount++
+	re.NotEqual(op.plannedInfluence, clone.plannedInfluence)
+	clone.parallelGroups[0][0] = 1
+	re.Equal([][]int{{0, 1}}, op.parallelGroups)
+}
+
+func (suite *operatorTestSuite) TestMergeAdditionalInfos() {
+	re := suite.Require()
+	op1 := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op2 := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 3})
+	op1.AdditionalInfos["retry"] = "1"
+	op1.AdditionalInfos["old"] = "a"
+	op2.AdditionalInfos["retry"] = "2"
+	op2.AdditionalInfos["new"] = "b"
+
+	op1.MergeAdditionalInfos(op2)
+	re.Equal(map[string]string{"retry": "2", "old": "a", "new": "b"}, op1.AdditionalInfos)
+	re.Equal(map[string]string{"retry": "2", "new": "b"}, op2.AdditionalInfos)
+
+	op1.MergeAdditionalInfos(nil)
+	re.Len(op1.AdditionalInfos, 3)
+	op2.AdditionalInfos = nil
+	op2.MergeAdditionalInfos(op1)
+	re.Equal(op1.AdditionalInfos, op2.AdditionalInfos)
+}
+
+func (suite *operatorTestSuite) TestAdditionalInfoHelpers() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.AdditionalInfos = nil
+	_, ok := op.GetAdditionalInfoInt("retry")
+	re.False(ok)
+	op.SetAdditionalInfoInt("retry", 3)
+	v, ok := op.GetAdditionalInfoInt("retry")
+	re.True(ok)
+	re.Equal(int64(3), v)
+	re.Equal("3", op.AdditionalInfos["retry"])
+	_, ok = op.GetAdditionalInfoDuration("retry")
+	re.False(ok)
+
+	_, ok = op.GetAdditionalInfoDuration("wait")
+	re.False(ok)
+	op.SetAdditionalInfoDuration("wait", 90*time.Second)
+	d, ok := op.GetAdditionalInfoDuration("wait")
+	re.True(ok)
+	re.Equal(90*time.Second, d)
+	_, ok = op.GetAdditionalInfoInt("wait")
+	re.False(ok)
+}
+
+func (suite *operatorTestSuite) TestDependency() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	dep := suite.newTestOperator(2, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetDependency(dep)
+	re.Equal(dep, op.GetDependency())
+	re.True(op.Start())
+	re.Nil(op.Check(region))
+	re.True(dep.Start())
+	re.Nil(op.Check(region))
+	re.Equal(STARTED, op.Status())
+
+	// the dependency succeeds.
+	re.Nil(dep.Check(suite.newTestRegion(2, 2, [2]uint64{1, 1}, [2]uint64{2, 2})))
+	re.Equal(SUCCESS, dep.Status())
+	re.Equal(TransferLeader{FromStore: 1, ToStore: 2}, op.Check(region))
+
+	// the dependency fails.
+	dep = suite.newTestOperator(2, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetDependency(dep)
+	re.True(op.Start())
+	re.True(dep.Start())
+	re.True(dep.Cancel(AdminStop))
+	re.Nil(op.Check(region))
+	re.Equal(CANCELED, op.Status())
+	re.Equal(DependencyFailed, op.GetCancelReason())
+
+	// the timeout includes the time waiting for the dependency.
+	dep = suite.newTestOperator(2, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetDependency(dep)
+	re.True(op.Start())
+	re.True(dep.Start())
+	re.True(op.waitingForDependency())
+	op.status.setTime(STARTED, time.Now().Add(-op.Timeout()))
+	re.Nil(op.Check(region))
+	re.Equal(TIMEOUT, op.Status())
+	re.False(op.waitingForDependency())
+}
+
+func (suite *operatorTestSuite) TestStepDurations() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 2, PeerID: 2},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		RemovePeer{FromStore: 1},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	re.Equal(make([]time.Duration, 3), op.StepDurations())
+
+	re.True(op.Start())
+	start := op.GetStartTime()
+	op.status.setTime(STARTED, start.Add(-10*time.Second))
+	op.stepsTime[0] = start.Add(-7 * time.Second).UnixNano()
+	op.currentStep = 1
+	durations := op.StepDurations()
+	re.Len(durations, 3)
+	re.Equal(3*time.Second, durations[0])
+	re.GreaterOrEqual(durations[1], 7*time.Second)
+	re.Zero(durations[2])
+
+	re.True(op.Cancel(AdminStop))
+	durations = op.StepDurations()
+	re.Equal(durations, op.StepDurations())
+	re.Zero(durations[2])
+}
+
+func (suite *operatorTestSuite) TestPeekStep() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 2, PeerID: 2},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		RemovePeer{FromStore: 1},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	re.True(op.Start())
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	re.Equal(steps[1], op.PeekStep(region))
+	re.Equal(int32(0), op.currentStep)
+	re.Equal(make([]int64, 3), op.stepsTime)
+
+	re.Equal(steps[1], op.Check(region))
+	re.Equal(steps[1], op.PeekStep(region))
+	re.True(op.Cancel(AdminStop))
+	re.Nil(op.PeekStep(region))
+}
+
+func (suite *operatorTestSuite) TestFirstUnfinishedStep() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 2, PeerID: 2},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		RemovePeer{FromStore: 1},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	idx, step := op.FirstUnfinishedStep(region)
+	re.Equal(1, idx)
+	re.Equal(steps[1], step)
+	re.Zero(op.CurrentStepIndex())
+	re.Equal(make([]int64, 3), op.stepsTime)
+	re.Equal(CREATED, op.Status())
+
+	// it doesn't depend on the status.
+	re.True(op.Cancel(AdminStop))
+	region = region.Clone(core.WithLeader(region.GetStorePeer(2)))
+	idx, step = op.FirstUnfinishedStep(region)
+	re.Equal(2, idx)
+	re.Equal(steps[2], step)
+
+	region = region.Clone(core.WithRemoveStorePeer(1))
+	idx, step = op.FirstUnfinishedStep(region)
+	re.Equal(-1, idx)
+	re.Nil(step)
+}
+
+func (suite *operatorTestSuite) TestIsNoop() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op := suite.newTestOperator(1, OpLeader|OpRegion,
+		AddPeer{ToStore: 2, PeerID: 2},
+		TransferLeader{FromStore: 2, ToStore: 1},
+	)
+	re.True(op.IsNoop(region))
+	re.Equal(CREATED, op.Status())
+
+	op = suite.newTestOperator(1, OpLeader|OpRegion,
+		AddPeer{ToStore: 2, PeerID: 2},
+		TransferLeader{FromStore: 1, ToStore: 2},
+	)
+	re.False(op.IsNoop(region))
+	// the finished steps are checked again.
+	op.currentStep = 1
+	re.False(op.IsNoop(region.Clone(core.WithRemoveStorePeer(2))))
+	re.True(op.IsNoop(region.Clone(core.WithLeader(region.GetStorePeer(2)))))
+}
+
+func (suite *operatorTestSuite) TestSkipToStep() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 2, PeerID: 2},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		RemovePeer{FromStore: 1},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	// the last step is finished, but the previous one is not.
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op.SkipToStep(region)
+	re.Equal(int32(1), op.currentStep)
+	re.NotZero(op.stepsTime[0])
+	re.Zero(op.stepsTime[1])
+	re.Zero(op.stepsTime[2])
+	re.Equal(uint64(1), op.ConfVerChanged(region))
+	re.Equal(CREATED, op.Status())
+
+	region = suite.newTestRegion(1, 2, [2]uint64{2, 2})
+	op.SkipToStep(region)
+	re.True(op.AllStepsDone())
+	re.Equal(uint64(2), op.ConfVerChanged(region))
+	re.True(op.Start())
+	re.Nil(op.Check(region))
+	re.Equal(SUCCESS, op.Status())
+}
+
+func (suite *operatorTestSuite) TestConfVerChangedCache() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 2, PeerID: 2},
+		AddPeer{ToStore: 3, PeerID: 3},
+		RemovePeer{FromStore: 1, PeerID: 1},
+	}
+	op := suite.newTestOperator(1, OpRegion, steps...)
+	re.True(op.Start())
+	region0 := suite.newTestRegion(1, 1, [2]uint64{1, 1})
+	region1 := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	region2 := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2}, [2]uint64{3, 3})
+	region3 := suite.newTestRegion(1, 2, [2]uint64{2, 2}, [2]uint64{3, 3})
+
+	re.Equal(steps[0], op.Check(region0))
+	re.Equal(uint64(0), op.ConfVerChanged(region0))
+	re.Equal(steps[1], op.Check(region1))
+	re.Equal(uint64(1), op.ConfVerChanged(region1))
+	// the finished step is not recomputed.
+	re.Equal(uint64(1), op.ConfVerChanged(region0))
+	re.Equal(steps[2], op.Check(region2))
+	re.Equal(uint64(2), op.ConfVerChanged(region2))
+	re.Nil(op.Check(region3))
+	re.Equal(uint64(3), op.ConfVerChanged(region3))
+
+	// concurrent Check and ConfVerChanged.
+	op = suite.newTestOperator(1, OpRegion, steps...)
+	re.True(op.Start())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, region := range []*core.RegionInfo{region0, region1, region2, region3} {
+				op.Check(region)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				re.LessOrEqual(op.ConfVerChanged(region3), uint64(3))
+			}
+		}()
+	}
+	wg.Wait()
+	re.Equal(uint64(3), op.ConfVerChanged(region3))
+}
+
+func (suite *operatorTestSuite) TestIsLeaderOnly() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.True(op.IsLeaderOnly())
+	op = suite.newTestOperator(1, OpLeader,
+		TransferLeader{FromStore: 1, ToStore: 2},
+		TransferLeaderToCandidates{FromStore: 2, ToStores: []uint64{1, 3}})
+	re.True(op.IsLeaderOnly())
+	op = suite.newTestOperator(1, OpLeader|OpRegion,
+		AddPeer{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 3})
+	re.False(op.IsLeaderOnly())
+	op = NewOperator(mockDesc, mockBrief, 1, &metapb.RegionEpoch{}, OpLeader, mockRegionSize)
+	re.False(op.IsLeaderOnly())
+}
+
+func (suite *operatorTestSuite) TestEqual() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 3, ToStores: []uint64{3}},
+	}
+	newOp := func(epoch *metapb.RegionEpoch, kind OpKind, steps ...OpStep) *Operator {
+		return NewOperator("test", "test", 1, epoch, kind, mockRegionSize, steps...)
+	}
+	op := newOp(&metapb.RegionEpoch{ConfVer: 1, Version: 1}, OpRegion|OpLeader, steps...)
+	re.True(op.Equal(newOp(&metapb.RegionEpoch{ConfVer: 1, Version: 1}, OpRegion|OpLeader, steps...)))
+	re.False(op.Equal(nil))
+	re.False(op.Equal(newOp(&metapb.RegionEpoch{ConfVer: 2, Version: 1}, OpRegion|OpLeader, steps...)))
+	re.False(op.Equal(newOp(&metapb.RegionEpoch{ConfVer: 1, Version: 2}, OpRegion|OpLeader, steps...)))
+	re.False(op.Equal(newOp(&metapb.RegionEpoch{ConfVer: 1, Version: 1}, OpRegion, steps...)))
+	re.False(op.Equal(newOp(&metapb.RegionEpoch{ConfVer: 1, Version: 1}, OpRegion|OpLeader, steps[:2]...)))
+	re.False(op.Equal(newOp(&metapb.RegionEpoch{ConfVer: 1, Version: 1}, OpRegion|OpLeader,
+		steps[0], steps[1], TransferLeader{FromStore: 1, ToStore: 3, ToStores: []uint64{2, 3}})))
+	re.False(op.Equal(suite.newTestOperator(2, OpRegion|OpLeader, steps...)))
+}
+
+func (suite *operatorTestSuite) TestAppendStep() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpRegion, AddLearner{ToStore: 3, PeerID: 3})
+	timeout := op.Timeout()
+	re.Error(op.AppendStep(nil))
+	re.NoError(op.AppendStep(RemovePeer{FromStore: 2, PeerID: 2}))
+	re.Equal(2, op.Len())
+	re.Len(op.stepsTime, 2)
+	re.Equal(RemovePeer{FromStore: 2, PeerID: 2}, op.Step(1))
+	re.Equal(timeout+RemovePeer{}.Timeout(op.ApproximateSize), op.Timeout())
+
+	re.True(op.Start())
+	re.Error(op.AppendStep(RemovePeer{FromStore: 1, PeerID: 1}))
+	re.Equal(2, op.Len())
+}
+
+func (suite *operatorTestSuite) TestNeedsSnapshot() {
+	re := suite.Require()
+	testCases := []struct {
+		steps    []OpStep
+		expected bool
+	}{
+		{[]OpStep{TransferLeader{FromStore: 1, ToStore: 2}}, false},
+		{[]OpStep{RemovePeer{FromStore: 1}, PromoteLearner{ToStore: 2, PeerID: 2}}, false},
+		{[]OpStep{AddPeer{ToStore: 3, PeerID: 3}}, true},
+		{[]OpStep{AddPeer{ToStore: 3, PeerID: 3, IsLightWeight: true}}, false},
+		{[]OpStep{AddPeerWithPriority{AddPeer: AddPeer{ToStore: 3, PeerID: 3}}}, true},
+		{[]OpStep{AddLearner{ToStore: 3, PeerID: 3}, PromoteLearner{ToStore: 3, PeerID: 3}}, true},
+		{[]OpStep{AddLearner{ToStore: 3, PeerID: 3, IsWitness: true}}, false},
+		{[]OpStep{BecomeWitness{StoreID: 2, PeerID: 2}}, false},
+		{[]OpStep{BecomeNonWitness{StoreID: 2, PeerID: 2}}, true},
+		{[]OpStep{BatchSwitchWitness{ToWitnesses: []BecomeWitness{{StoreID: 2, PeerID: 2}}}}, false},
+		{[]OpStep{BatchSwitchWitness{ToNonWitnesses: []BecomeNonWitness{{StoreID: 2, PeerID: 2}}}}, true},
+	}
+	for _, testCase := range testCases {
+		op := suite.newTestOperator(1, OpRegion, testCase.steps...)
+		re.Equal(testCase.expected, op.NeedsSnapshot(), op.String())
+	}
+}
+
+func (suite *operatorTestSuite) TestSetApproximateSize() {
+	re := suite.Require()
+	steps := []OpStep{AddLearner{ToStore: 3, PeerID: 3}, RemovePeer{FromStore: 2, PeerID: 2}}
+	op := suite.newTestOperator(1, OpRegion, steps...)
+	op.ExtendTimeout(time.Minute)
+	re.NoError(op.SetApproximateSize(10 * mockRegionSize))
+	re.Equal(int64(10*mockRegionSize), op.ApproximateSize)
+	expected := steps[0].Timeout(10*mockRegionSize) + steps[1].Timeout(10*mockRegionSize) + time.Minute
+	re.Equal(expected, op.Timeout())
+
+	re.True(op.Start())
+	re.Error(op.SetApproximateSize(mockRegionSize))
+	re.Equal(int64(10*mockRegionSize), op.ApproximateSize)
+	re.Equal(expected, op.Timeout())
+}
+
+func (suite *operatorTestSuite) TestOnStepFinished() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 2, PeerID: 2},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		RemovePeer{FromStore: 1},
+	}
+	op := suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	var mu sync.Mutex
+	finished := make(map[int]int)
+	op.OnStepFinished(func(stepIndex int, step OpStep) {
+		mu.Lock()
+		defer mu.Unlock()
+		re.Equal(steps[stepIndex], step)
+		finished[stepIndex]++
+	})
+	re.True(op.Start())
+	regions := []*core.RegionInfo{
+		suite.newTestRegion(1, 1, [2]uint64{1, 1}),
+		suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2}),
+		suite.newTestRegion(1, 2, [2]uint64{1, 1}, [2]uint64{2, 2}),
+		suite.newTestRegion(1, 2, [2]uint64{2, 2}),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, region := range regions {
+				op.Check(region)
+			}
+		}()
+	}
+	wg.Wait()
+	re.Equal(SUCCESS, op.Status())
+	re.Equal(map[int]int{0: 1, 1: 1, 2: 1}, finished)
+}
+
+func (suite *operatorTestSuite) TestStatusReason() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 2, PeerID: 2},
+		TransferLeader{FromStore: 1, ToStore: 2},
+	}
+	op := suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	re.Contains(op.StatusReason(), "created")
+	re.True(op.Start())
+	re.Equal("running on step 0 (add peer 2 on store 2) for 0s", op.StatusReason())
+	re.Nil(op.Check(suite.newTestRegion(1, 2, [2]uint64{1, 1}, [2]uint64{2, 2})))
+	re.Equal("finished 2 steps in 0s", op.StatusReason())
+
+	op = suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	re.True(op.Start())
+	re.True(op.Cancel(EpochNotMatch))
+	re.Equal("canceled: epoch not match", op.StatusReason())
+	op = suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	re.True(op.Start())
+	re.True(op.Cancel(""))
+	re.Equal("canceled: unknown", op.StatusReason())
+
+	op = suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	re.True(op.Start())
+	op.SetStatusReachTime(STARTED, time.Now().Add(-op.Timeout()-31*time.Second))
+	re.True(op.CheckTimeout())
+	re.Equal(fmt.Sprintf("timed out on step 0 (add peer 2 on store 2) after %v", op.Timeout()+31*time.Second), op.StatusReason())
+
+	op = suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	re.True(op.Start())
+	re.True(op.Replace())
+	re.Equal("replaced by a newer operator on step 0 (add peer 2 on store 2)", op.StatusReason())
+}
+
+func (suite *operatorTestSuite) TestReplacedBy() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	var status OpStatus
+	op.OnEnd(func(st OpStatus) { status = st })
+	re.True(op.Start())
+	op.ReplacedBy(nil)
+	re.Empty(op.AdditionalInfos)
+	op.ReplacedBy(suite.newTestOperator(1, OpRegion|OpAdmin, AddPeer{ToStore: 3, PeerID: 3}))
+	re.True(op.Replace())
+	re.Equal(REPLACED, status)
+	regionID, ok := op.GetAdditionalInfoInt(replacedByRegion)
+	re.True(ok)
+	re.Equal(int64(1), regionID)
+	re.Equal("admin,region", op.AdditionalInfos[replacedByKind])
+	re.Equal("replaced by a newer admin,region operator on step 0 (transfer leader from store 1 to store 2)", op.StatusReason())
+}
+
+func (suite *operatorTestSuite) TestAgeBoostedLevel() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetPriorityLevel(constant.Low)
+	op.SetStatusReachTime(CREATED, time.Now().Add(-time.Minute))
+	// no boost by default.
+	re.Equal(constant.Low, op.AgeBoostedLevel())
+
+	defer func(threshold time.Duration) { AgeBoostThreshold = threshold }(AgeBoostThreshold)
+	AgeBoostThreshold = 30 * time.Second
+	re.Equal(constant.Medium, op.AgeBoostedLevel())
+	re.Equal(constant.Low, op.GetPriorityLevel())
+	op.SetPriorityLevel(constant.Urgent)
+	re.Equal(constant.Urgent, op.AgeBoostedLevel())
+
+	// the young operator is not boosted.
+	newOp := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	newOp.SetPriorityLevel(constant.High)
+	re.Equal(constant.High, newOp.AgeBoostedLevel())
+
+	// the boosted operator can not be replaced by the operator with the next level.
+	op.SetPriorityLevel(constant.Medium)
+	re.False(isHigherPriorityOperator(newOp, op))
+	op.SetStatusReachTime(CREATED, time.Now())
+	re.True(isHigherPriorityOperator(newOp, op))
+}
+
+func (suite *operatorTestSuite) TestNumericPriority() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	re.Equal(100, op.NumericPriority())
+	for level, expected := range map[constant.PriorityLevel]int{
+		constant.Low:    0,
+		constant.Medium: 100,
+		constant.High:   200,
+		constant.Urgent: 300,
+	} {
+		op.SetPriorityLevel(level)
+		re.Equal(expected, op.NumericPriority())
+	}
+
+	defer func(threshold time.Duration) { AgeBoostThreshold = threshold }(AgeBoostThreshold)
+	AgeBoostThreshold = 30 * time.Second
+	op.SetPriorityLevel(constant.Low)
+	op.SetStatusReachTime(CREATED, time.Now().Add(-time.Minute))
+	re.Equal(NumericPriorityScale, op.NumericPriority())
+	op.SetPriorityLevel(constant.Urgent)
+	re.Equal(3*NumericPriorityScale, op.NumericPriority())
+}
+
+func (suite *operatorTestSuite) TestLess() {
+	re := suite.Require()
+	now := time.Now()
+	newOp := func(regionID uint64, kind OpKind, level constant.PriorityLevel, createTime time.Time) *Operator {
+		op := suite.newTestOperator(regionID, kind, TransferLeader{FromStore: 1, ToStore: 2})
+		op.SetPriorityLevel(level)
+		op.SetStatusReachTime(CREATED, createTime)
+		return op
+	}
+	ops := []*Operator{
+		newOp(1, OpLeader, constant.Low, now),
+		newOp(2, OpLeader, constant.Urgent, now),
+		newOp(3, OpLeader|OpReplica, constant.Urgent, now),
+		newOp(4, OpLeader, constant.Urgent, now.Add(-time.Second)),
+		newOp(5, OpLeader, constant.Urgent, now),
+		newOp(6, OpLeader, constant.High, now),
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Less(ops[j]) })
+	var regionIDs []uint64
+	for _, op := range ops {
+		regionIDs = append(regionIDs, op.RegionID())
+	}
+	re.Equal([]uint64{3, 4, 2, 5, 6, 1}, regionIDs)
+	re.False(ops[0].Less(ops[0]))
+}
+
+func (suite *operatorTestSuite) TestNewOperatorWithTimeouts() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 1, PeerID: 1},
+		RemovePeer{FromStore: 3},
+	}
+	op, err := NewOperatorWithTimeouts(mockDesc, mockBrief, 1, &metapb.RegionEpoch{}, OpRegion, mockRegionSize, nil, steps...)
+	re.NoError(err)
+	re.Equal(suite.newTestOperator(1, OpRegion, steps...).timeout, op.timeout)
+
+	op, err = NewOperatorWithTimeouts(mockDesc, mockBrief, 1, &metapb.RegionEpoch{}, OpRegion, mockRegionSize,
+		[]time.Duration{time.Hour, 0}, steps...)
+	re.NoError(err)
+	re.Equal(time.Hour+FastStepWaitTime, op.timeout)
+
+	_, err = NewOperatorWithTimeouts(mockDesc, mockBrief, 1, &metapb.RegionEpoch{}, OpRegion, mockRegionSize,
+		[]time.Duration{time.Hour}, steps...)
+	re.Error(err)
+}
+
+func (suite *operatorTestSuite) TestCancelReason() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.Empty(op.GetCancelReason())
+	re.True(op.Start())
+	re.True(op.Cancel(EpochNotMatch))
+	re.False(op.Cancel(RegionNotFound))
+	re.Equal(EpochNotMatch, op.GetCancelReason())
+	re.Contains(op.GetAdditionalInfo(), `"cancel-reason":"epoch not match"`)
+	re.Equal(EpochNotMatch, op.Clone().GetCancelReason())
+	re.Contains(op.String(), "canceled(reason:epoch not match)")
+
+	// the empty reason is omitted.
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.Start())
+	re.True(op.Cancel(""))
+	re.Contains(op.String(), " canceled")
+	re.NotContains(op.String(), "reason:")
+
+	// the reason is not recorded if the operator has ended.
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.Start())
+	re.True(op.status.To(SUCCESS))
+	re.False(op.Cancel(RegionNotFound))
+	re.Empty(op.GetCancelReason())
+	re.Empty(op.GetAdditionalInfo())
+	re.True(op.Retryable())
+	re.NotContains(op.String(), "canceled")
+}
+
+func (suite *operatorTestSuite) TestCheckParallel() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	steps := []OpStep{
+		AddLearner{ToStore: 3, PeerID: 3},
+		AddLearner{ToStore: 4, PeerID: 4},
+		AddLearner{ToStore: 5, PeerID: 5},
+		RemovePeer{FromStore: 2, PeerID: 2},
+	}
+	op := suite.newTestOperator(1, OpRegion, steps...)
+	re.Error(op.SetParallelGroups([][]int{{}}))
+	re.Error(op.SetParallelGroups([][]int{{0, 2}}))
+	re.Error(op.SetParallelGroups([][]int{{1, 0}}))
+	re.Error(op.SetParallelGroups([][]int{{0, 1}, {1, 2}}))
+	re.Error(op.SetParallelGroups([][]int{{3, 4}}))
+	re.Empty(op.GetParallelGroups())
+	// the operator without parallel groups behaves like Check.
+	re.Equal([]OpStep{steps[0]}, op.Clone().CheckParallel(region))
+
+	re.NoError(op.SetParallelGroups([][]int{{0, 1, 2}}))
+	re.Equal([][]int{{0, 1, 2}}, op.GetParallelGroups())
+	re.True(op.Start())
+	re.Error(op.SetParallelGroups(nil))
+	re.Equal(steps[:3], op.CheckParallel(region))
+
+	// the finished steps in the group are not returned.
+	learner := func(storeID uint64) core.RegionCreateOption {
+		return core.WithAddPeer(&metapb.Peer{Id: storeID, StoreId: storeID, Role: metapb.PeerRole_Learner})
+	}
+	region = region.Clone(learner(4))
+	re.Equal([]OpStep{steps[0], steps[2]}, op.CheckParallel(region))
+	region = region.Clone(learner(3))
+	re.Equal([]OpStep{steps[2]}, op.CheckParallel(region))
+	re.Equal(2, op.CurrentStepIndex())
+	region = region.Clone(learner(5))
+	re.Equal([]OpStep{steps[3]}, op.CheckParallel(region))
+	region = region.Clone(core.WithRemoveStorePeer(2))
+	re.Nil(op.CheckParallel(region))
+	re.Equal(SUCCESS, op.Status())
+}
+
+func (suite *operatorTestSuite) TestRemainingSteps() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 1, PeerID: 1},
+		TransferLeader{FromStore: 2, ToStore: 1},
+		RemovePeer{FromStore: 2},
+		RemovePeer{FromStore: 3},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	re.Equal(4, op.RemainingSteps())
+	re.Zero(op.Progress())
+	re.Zero(op.CurrentStepIndex())
+	re.True(op.Start())
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	re.Equal(steps[2], op.Check(region))
+	re.Equal(2, op.RemainingSteps())
+	re.Equal(0.5, op.Progress())
+	re.Equal(2, op.CurrentStepIndex())
+	op.currentStep = int32(len(op.steps) + 1)
+	re.Zero(op.RemainingSteps())
+	re.Equal(1.0, op.Progress())
+	re.Equal(op.Len(), op.CurrentStepIndex())
+}
+
+func (suite *operatorTestSuite) TestInvolvedStores() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddLearner{ToStore: 5, PeerID: 5, SendStore: 1},
+		PromoteLearner{ToStore: 5, PeerID: 5},
+		TransferLeader{FromStore: 1, ToStore: 3, ToStores: []uint64{3, 4}},
+		RemovePeer{FromStore: 1},
+		ChangePeerV2Enter{DemoteVoters: []DemoteVoter{{ToStore: 2, PeerID: 2}}},
+		SplitRegion{},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	re.Equal([]uint64{1, 2, 3, 4, 5}, op.InvolvedStores())
+	op = suite.newTestOperator(1, OpSplit, SplitRegion{})
+	re.Empty(op.InvolvedStores())
+}
+
+func (suite *operatorTestSuite) TestTotalCost() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 3},
+		RemovePeer{FromStore: 1},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	re.Equal(int64(mockRegionSize)+3*metadataStepCost, op.TotalCost())
+	re.Equal(metadataStepCost, AddPeer{ToStore: 3, PeerID: 3, IsLightWeight: true}.ApproximateCost(mockRegionSize))
+	re.Equal(metadataStepCost, AddPeer{ToStore: 3, PeerID: 3}.ApproximateCost(0))
+
+	leaderOp := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 3})
+	re.Less(leaderOp.TotalCost(), op.TotalCost())
+}
+
+func (suite *operatorTestSuite) TestCost() {
+	re := suite.Require()
+	op := NewOperator("test", "test", 1, &metapb.RegionEpoch{}, OpRegion|OpLeader, 100,
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		AddPeer{ToStore: 4, PeerID: 4, IsLightWeight: true},
+		TransferLeader{FromStore: 1, ToStore: 3},
+		RemovePeer{FromStore: 1},
+	)
+	re.Equal(100, op.Cost())
+	re.Equal(100, op.Clone().Cost())
+
+	re.NoError(op.AppendStep(AddPeer{ToStore: 5, PeerID: 5}))
+	re.Equal(200, op.Cost())
+	re.NoError(op.SetApproximateSize(10))
+	re.Equal(20, op.Cost())
+
+	leaderOp := NewOperator("test", "test", 1, &metapb.RegionEpoch{}, OpLeader, 100, TransferLeader{FromStore: 1, ToStore: 3})
+	re.Zero(leaderOp.Cost())
+
+	// the cost is derived from the approximate cost, except the metadata only steps.
+	witnessOp := NewOperator("test", "test", 1, &metapb.RegionEpoch{}, OpRegion, 100,
+		BecomeWitness{PeerID: 2, StoreID: 2},
+		BecomeNonWitness{PeerID: 3, StoreID: 3},
+	)
+	re.Equal(int(witnessOp.TotalCost()-metadataStepCost), witnessOp.Cost())
+
+	// the snapshot step costs at least 1 even if the region is empty.
+	for _, size := range []int64{0, 1} {
+		op := NewOperator("test", "test", 1, &metapb.RegionEpoch{}, OpRegion, size,
+			AddLearner{ToStore: 3, PeerID: 3},
+			PromoteLearner{ToStore: 3, PeerID: 3},
+			RemovePeer{FromStore: 1},
+		)
+		re.Equal(1, op.Cost())
+		re.NoError(op.AppendStep(AddPeer{ToStore: 4, PeerID: 4}))
+		re.Equal(2, op.Cost())
+	}
+}
+
+func (suite *operatorTestSuite) TestPauseAndResume() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 1, PeerID: 1},
+		TransferLeader{FromStore: 2, ToStore: 1},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	re.False(op.Pause())
+	re.True(op.Start())
+	re.True(op.Pause())
+	re.True(op.IsPaused())
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	re.Nil(op.Check(region))
+	re.Equal(int32(0), op.currentStep)
+	re.Equal(PAUSED, op.Status())
+
+	re.True(op.Resume())
+	re.False(op.Resume())
+	re.Nil(op.Check(region))
+	re.Equal(SUCCESS, op.Status())
+}
+
+func (suite *operatorTestSuite) TestExtendTimeout() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.Start())
+	op.SetStatusReachTime(STARTED, op.GetStartTime().Add(-FastStepWaitTime-time.Second))
+	op.ExtendTimeout(-time.Minute)
+	op.ExtendTimeout(0)
+	re.Empty(op.AdditionalInfos)
+	op.ExtendTimeout(time.Minute)
+	op.ExtendTimeout(time.Minute)
+	re.Equal(FastStepWaitTime+2*time.Minute, op.Timeout())
+	re.Equal("2", op.AdditionalInfos[timeoutExtendedTimes])
+	re.Equal("2m0s", op.AdditionalInfos[timeoutExtended])
+	re.False(op.CheckTimeout())
+	re.Equal(STARTED, op.Status())
+}
+
+func (suite *operatorTestSuite) TestSetTimeout() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op.SetTimeout(time.Minute)
+	re.Equal(time.Minute, op.Timeout())
+	re.Empty(op.AdditionalInfos)
+
+	re.True(op.Start())
+	op.SetTimeout(time.Minute)
+	re.Empty(op.AdditionalInfos)
+	op.SetTimeout(time.Second)
+	re.Equal(time.Second, op.Timeout())
+	re.Equal("1m0s->1s", op.AdditionalInfos[timeoutChanged])
+	op.SetStatusReachTime(STARTED, op.GetStartTime().Add(-2*time.Second))
+	re.True(op.CheckTimeout())
+}
+
+func (suite *operatorTestSuite) TestSyncTimeout() {
+	re := suite.Require()
+	op1 := suite.newTestOperator(1, OpMerge, MergeRegion{})
+	op2 := suite.newTestOperator(2, OpMerge, MergeRegion{})
+	op2.SetTimeout(time.Minute)
+	re.True(op1.Start())
+	op1.Sync(op2)
+	re.Equal(time.Minute, op1.Timeout())
+	// Syncing the timeout is not a change of the timeout.
+	re.NotContains(op1.AdditionalInfos, timeoutChanged)
+	re.Equal("2", op1.AdditionalInfos[string(RelatedMergeRegion)])
+	re.Equal("1", op2.AdditionalInfos[string(RelatedMergeRegion)])
+}
+
+func (suite *operatorTestSuite) TestTimeoutStatus() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op.SetTimeout(time.Minute)
+	timedOut, elapsed, budget := op.TimeoutStatus()
+	re.False(timedOut)
+	re.Zero(elapsed)
+	re.Equal(time.Minute, budget)
+
+	re.True(op.Start())
+	op.SetStatusReachTime(STARTED, time.Now().Add(-2*time.Minute))
+	timedOut, elapsed, budget = op.TimeoutStatus()
+	re.True(timedOut)
+	re.GreaterOrEqual(elapsed, 2*time.Minute)
+	re.Equal(time.Minute, budget)
+	// the status is not changed.
+	re.Equal(STARTED, op.Status())
+
+	// the budget is zero if the deadline is exceeded.
+	op.SetTimeout(time.Hour)
+	re.False(op.CheckTimeout())
+	op.SetDeadline(time.Now().Add(-time.Second))
+	timedOut, _, budget = op.TimeoutStatus()
+	re.True(timedOut)
+	re.Zero(budget)
+	re.Equal(STARTED, op.Status())
+	re.True(op.CheckTimeout())
+	timedOut, _, _ = op.TimeoutStatus()
+	re.True(timedOut)
+
+	// the operator whose steps are all done is not timed out.
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.Start())
+	op.currentStep = int32(len(op.steps))
+	op.SetStatusReachTime(STARTED, time.Now().Add(-2*op.Timeout()))
+	timedOut, _, _ = op.TimeoutStatus()
+	re.False(timedOut)
+	re.Equal(STARTED, op.Status())
+}
+
+func (suite *operatorTestSuite) TestOnTimeout() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	steps := []OpStep{
+		TransferLeader{FromStore: 2, ToStore: 1},
+		AddLearner{ToStore: 3, PeerID: 3},
+	}
+	op := suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	var (
+		called  int
+		stalled OpStep
+		index   int
+	)
+	op.OnTimeout(func(step OpStep, i int) {
+		called++
+		stalled, index = step, i
+	})
+	re.True(op.Start())
+	re.Equal(steps[1], op.Check(region))
+	re.False(op.CheckTimeout())
+	re.Zero(called)
+	op.SetStatusReachTime(STARTED, time.Now().Add(-op.Timeout()-time.Second))
+	re.True(op.CheckTimeout())
+	re.True(op.CheckTimeout())
+	re.Equal(1, called)
+	re.Equal(steps[1], stalled)
+	re.Equal(1, index)
+
+	// not called on other end status.
+	op = suite.newTestOperator(1, OpRegion|OpLeader, steps...)
+	op.OnTimeout(func(OpStep, int) { called++ })
+	re.True(op.Start())
+	re.True(op.Cancel(AdminStop))
+	re.Equal(1, called)
+}
+
+// histogramSampleCount returns the sample count of the histogram by gathering it from a new registry.
+func histogramSampleCount(re *require.Assertions, h prometheus.Observer) uint64 {
+	reg := prometheus.NewRegistry()
+	re.NoError(reg.Register(h.(prometheus.Histogram)))
+	mfs, err := reg.Gather()
+	re.NoError(err)
+	re.Len(mfs, 1)
+	re.Len(mfs[0].GetMetric(), 1)
+	return mfs[0].GetMetric()[0].GetHistogram().GetSampleCount()
+}
+
+func (suite *operatorTestSuite) TestQueueTime() {
+	re := suite.Require()
+	sampleCount := func() uint64 {
+		return histogramSampleCount(re, operatorQueueTime.WithLabelValues(OpHotRegion.String()))
+	}
+	op := suite.newTestOperator(1, OpHotRegion|OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op.SetStatusReachTime(CREATED, time.Now().Add(-time.Second))
+	re.GreaterOrEqual(op.QueueTime(), time.Second)
+
+	before := sampleCount()
+	re.True(op.Start())
+	re.Equal(before+1, sampleCount())
+	queueTime := op.QueueTime()
+	re.Equal(op.GetStartTime().Sub(op.GetCreateTime()), queueTime)
+	re.GreaterOrEqual(queueTime, time.Second)
+	// resuming is not counted.
+	re.True(op.Pause())
+	re.True(op.Start())
+	re.Equal(before+1, sampleCount())
+	re.Equal(queueTime, op.QueueTime())
+
+	// the expired operator is not queued any more.
+	op = suite.newTestOperator(1, OpHotRegion|OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op.SetStatusReachTime(CREATED, time.Now().Add(-OperatorExpireTime))
+	re.True(op.CheckExpired())
+	re.Equal(op.GetReachTimeOf(EXPIRED).Sub(op.GetCreateTime()), op.QueueTime())
+}
+
+func (suite *operatorTestSuite) TestObserveDuration() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpHotRegion|OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.Start())
+	re.True(op.Cancel(AdminStop))
+	histogram := operatorKindDuration.WithLabelValues(OpHotRegion.String())
+	before := histogramSampleCount(re, histogram)
+	op.Record(time.Now()).observeDuration()
+	re.Equal(before+1, histogramSampleCount(re, histogram))
+}
+
+func (suite *operatorTestSuite) TestAttachConstLabels() {
+	re := suite.Require()
+	sampleCount := func(h prometheus.Observer) uint64 {
+		return histogramSampleCount(re, h)
+	}
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op := suite.newTestOperator(1, OpHotRegion|OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op.AttachConstLabels(prometheus.Labels{"tenant": "t1", "cluster": "c1"})
+	re.Equal("cluster=c1,tenant=t1", op.constLabels)
+	re.Equal(op.constLabels, op.Clone().constLabels)
+	stepHistogram := operatorStepDurationByLabels.WithLabelValues("TransferLeader", op.constLabels)
+	kindHistogram := operatorKindDurationByLabels.WithLabelValues(OpHotRegion.String(), op.constLabels)
+	stepBefore, kindBefore := sampleCount(stepHistogram), sampleCount(kindHistogram)
+	re.True(op.Start())
+	re.Nil(op.Check(region))
+	re.Equal(SUCCESS, op.Status())
+	op.Record(time.Now()).observeDuration()
+	re.Equal(stepBefore+1, sampleCount(stepHistogram))
+	re.Equal(kindBefore+1, sampleCount(kindHistogram))
+
+	op.AttachConstLabels(nil)
+	re.Empty(op.constLabels)
+}
+
+func (suite *operatorTestSuite) TestLongStepsCounter() {
+	re := suite.Require()
+	getCount := func() float64 {
+		return testutil.ToFloat64(operatorLongStepsCounter.WithLabelValues("test"))
+	}
+	steps := make([]OpStep, 0, LongStepsThreshold+1)
+	for i := 0; i < LongStepsThreshold; i++ {
+		steps = append(steps, TransferLeader{FromStore: 1, ToStore: 2})
+	}
+	before := getCount()
+	op := suite.newTestOperator(1, OpLeader, steps...)
+	re.Equal(before, getCount())
+	_, ok := op.AdditionalInfos[stepsCount]
+	re.False(ok)
+
+	steps = append(steps, TransferLeader{FromStore: 2, ToStore: 1})
+	op = suite.newTestOperator(1, OpLeader, steps...)
+	re.Equal(before+1, getCount())
+	count, ok := op.GetAdditionalInfoInt(stepsCount)
+	re.True(ok)
+	re.Equal(int64(LongStepsThreshold+1), count)
+}
+
+func (suite *operatorTestSuite) TestCanceledCounter() {
+	re := suite.Require()
+	getCount := func(reason CancelReasonType) float64 {
+		return testutil.ToFloat64(operatorCanceledCounter.WithLabelValues(string(reason)))
+	}
+	epochNotMatch, unknown := getCount(EpochNotMatch), getCount(Unknown)
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.Start())
+	re.True(op.Cancel(EpochNotMatch))
+	re.False(op.Cancel(EpochNotMatch))
+	re.Equal(epochNotMatch+1, getCount(EpochNotMatch))
+
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.Start())
+	re.True(op.Cancel("not defined"))
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.True(op.Start())
+	re.True(op.Cancel(""))
+	re.Equal(unknown+2, getCount(Unknown))
+}
+
+func (suite *operatorTestSuite) TestHistory() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpRegion|OpLeader|OpWitness,
+		AddLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		RemovePeer{FromStore: 1, PeerID: 1},
+		BecomeWitness{StoreID: 4, PeerID: 4},
+		BatchSwitchWitness{
+			ToWitnesses:    []BecomeWitness{{StoreID: 5, PeerID: 5}},
+			ToNonWitnesses: []BecomeNonWitness{{StoreID: 6, PeerID: 6}},
+		},
+		BecomeNonWitness{StoreID: 7, PeerID: 7},
+	)
+	type entry struct {
+		from, to uint64
+		kind     constant.ResourceKind
+	}
+	var entries []entry
+	for _, h := range op.History() {
+		entries = append(entries, entry{h.From, h.To, h.Kind})
+	}
+	re.Equal([]entry{
+		{1, 2, constant.LeaderKind},
+		{4, 4, constant.WitnessKind},
+		{5, 5, constant.WitnessKind},
+		{6, 6, constant.WitnessKind},
+		{7, 7, constant.WitnessKind},
+		{1, 3, constant.RegionKind},
+	}, entries)
+}
+
+func (suite *operatorTestSuite) TestMarshalHistoryCSV() {
+	re := suite.Require()
+	finishTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	histories := []OpHistory{
+		{FinishTime: finishTime, From: 1, To: 2, Kind: constant.LeaderKind},
+		{FinishTime: finishTime, From: 1, To: 3, Kind: constant.RegionKind},
+	}
+	var buf bytes.Buffer
+	re.NoError(MarshalHistoryCSV(&buf, histories))
+	re.Equal("finish_time,from_store,to_store,kind\n"+
+		"2024-01-02T03:04:05Z,1,2,leader\n"+
+		"2024-01-02T03:04:05Z,1,3,region\n", buf.String())
+
+	buf.Reset()
+	re.NoError(MarshalHistoryCSV(&buf, nil))
+	re.Equal("finish_time,from_store,to_store,kind\n", buf.String())
+}
+
+func (suite *operatorTestSuite) TestID() {
+	re := suite.Require()
+	op1 := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op2 := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.NotZero(op1.GetID())
+	re.Greater(op2.GetID(), op1.GetID())
+	re.Contains(op1.String(), fmt.Sprintf("id:%d,", op1.GetID()))
+	data, err := op1.Encode()
+	re.NoError(err)
+	decoded, err := DecodeOperator(data)
+	re.NoError(err)
+	re.Equal(op1.GetID(), decoded.GetID())
+}
+
+func (suite *operatorTestSuite) TestSource() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.Empty(op.Source())
+	re.NotContains(op.String(), "source:")
+	op.SetSource("balance-leader-scheduler")
+	re.Equal("balance-leader-scheduler", op.Source())
+	re.Contains(op.String(), "source:balance-leader-scheduler")
+	re.Equal("balance-leader-scheduler", op.ToStructuredJSONObject().Source)
+	re.Equal("balance-leader-scheduler", op.Clone().Source())
+}
+
+func (suite *operatorTestSuite) TestBrief() {
+	re := suite.Require()
+	op := NewOperator("test", "transfer leader", 1, &metapb.RegionEpoch{}, OpLeader, 0, TransferLeader{FromStore: 2, ToStore: 1})
+	re.Equal("transfer leader", op.Brief())
+	op.SetBrief("transfer leader: store 2 to 1")
+	re.Equal("transfer leader: store 2 to 1", op.Brief())
+	re.Contains(op.String(), "{transfer leader: store 2 to 1}")
+}
+
+func (suite *operatorTestSuite) TestEpochMatches() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2}).Clone(core.SetRegionConfVer(1), core.SetRegionVersion(1))
+	step := TransferLeader{FromStore: 1, ToStore: 2}
+	op := NewTestOperator(1, &metapb.RegionEpoch{ConfVer: 1, Version: 1}, OpLeader, step)
+	re.True(op.EpochMatches(region))
+	re.False(op.EpochMatches(nil))
+	re.False(op.EpochMatches(region.Clone(core.WithIncVersion())))
+	re.False(op.EpochMatches(region.Clone(core.WithIncConfVer())))
+
+	// the epoch is not checked if it is not attached.
+	op = NewTestOperator(1, nil, OpLeader, step)
+	re.True(op.EpochMatches(region))
+	re.True(op.EpochMatches(nil))
+}
+
+func (suite *operatorTestSuite) TestCheckEpochBeforeStep() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2}).Clone(core.SetRegionConfVer(1), core.SetRegionVersion(1))
+	steps := []OpStep{
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+	}
+	newOp := func() *Operator {
+		op := NewTestOperator(1, &metapb.RegionEpoch{ConfVer: 1, Version: 1}, OpRegion, steps...)
+		re.True(op.Start())
+		return op
+	}
+	learnerAdded := region.Clone(core.WithAddPeer(&metapb.Peer{Id: 3, StoreId: 3, Role: metapb.PeerRole_Learner}), core.WithIncConfVer())
+	split := learnerAdded.Clone(core.WithIncVersion())
+
+	// the epoch is not checked by default.
+	op := newOp()
+	re.False(op.IsEpochCheckOnStep())
+	re.Equal(steps[1], op.Check(split))
+	re.Equal(STARTED, op.Status())
+
+	op = newOp()
+	op.SetEpochCheckOnStep(true)
+	re.True(op.Clone().IsEpochCheckOnStep())
+	re.Equal(steps[0], op.Check(region))
+	// the confver consumed by the steps is allowed.
+	re.True(op.CheckEpochBeforeStep(learnerAdded))
+	re.Equal(steps[1], op.Check(learnerAdded))
+	re.Nil(op.Check(split))
+	re.Equal(CANCELED, op.Status())
+	re.Equal(EpochNotMatch, op.GetCancelReason())
+
+	// the confver changed by others.
+	op = newOp()
+	re.False(op.CheckEpochBeforeStep(region.Clone(core.WithIncConfVer())))
+	re.Equal(EpochNotMatch, op.GetCancelReason())
+
+	// the epoch is not checked if it is not attached.
+	op = NewTestOperator(1, nil, OpRegion, steps...)
+	re.True(op.CheckEpochBeforeStep(split))
+}
+
+func (suite *operatorTestSuite) TestStepAttempts() {
+	re := suite.Require()
+	defer func(attempts int) { MaxStepAttempts = attempts }(MaxStepAttempts)
+	MaxStepAttempts = 3
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	steps := []OpStep{
+		TransferLeader{FromStore: 1, ToStore: 2},
+		TransferLeader{FromStore: 2, ToStore: 1},
+	}
+	op := suite.newTestOperator(1, OpLeader, steps...)
+	re.Zero(op.StepAttempts(0))
+	re.True(op.Start())
+	re.Equal(steps[0], op.Check(region))
+	re.Equal(1, op.StepAttempts(0))
+	// the attempts are not increased within the interval.
+	re.Equal(steps[0], op.Check(region))
+	re.Equal(1, op.StepAttempts(0))
+	op.SetStatusReachTime(STARTED, time.Now().Add(-StepAttemptInterval))
+	re.Equal(steps[0], op.Check(region))
+	re.Equal(2, op.StepAttempts(0))
+	re.Zero(op.StepAttempts(1))
+	re.Zero(op.StepAttempts(-1))
+	re.Zero(op.StepAttempts(2))
+
+	// the attempts of the next step are counted separately.
+	transferred := region.Clone(core.WithLeader(region.GetStorePeer(2)))
+	re.Equal(steps[1], op.Check(transferred))
+	re.Equal(2, op.StepAttempts(0))
+	re.Equal(1, op.StepAttempts(1))
+	re.Zero(op.Clone().StepAttempts(1))
+
+	// the operator is canceled if the attempts are exhausted.
+	op = suite.newTestOperator(1, OpLeader, steps...)
+	re.True(op.Start())
+	for i := 1; i <= MaxStepAttempts; i++ {
+		op.SetStatusReachTime(STARTED, time.Now().Add(-time.Duration(i-1)*StepAttemptInterval))
+		re.Equal(steps[0], op.Check(region))
+		re.Equal(i, op.StepAttempts(0))
+	}
+	op.SetStatusReachTime(STARTED, time.Now().Add(-time.Duration(MaxStepAttempts)*StepAttemptInterval))
+	re.Nil(op.Check(region))
+	re.Equal(CANCELED, op.Status())
+	re.Equal(StepRetryExhausted, op.GetCancelReason())
+}
+
+func (suite *operatorTestSuite) TestWithContext() {
+	re := suite.Require()
+	ctx, cancel := context.WithCancel(context.Background())
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op.WithContext(ctx)
+	re.True(op.Start())
+	cancel()
+	re.Eventually(func() bool { return op.Status() == CANCELED }, time.Second, 10*time.Millisecond)
+	re.Equal(ContextCanceled, op.GetCancelReason())
+
+	// the operator which ends before the context is done is not affected.
+	ctx, cancel = context.WithCancel(context.Background())
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op.WithContext(ctx)
+	re.True(op.Cancel(AdminStop))
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	re.Equal(AdminStop, op.GetCancelReason())
+
+	// the ended operator is not watched.
+	op.WithContext(context.Background())
+
+	// the cancel races with the readers of the operator.
+	ctx, cancel = context.WithCancel(context.Background())
+	op = suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	op.WithContext(ctx)
+	re.True(op.Start())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !op.IsEnd() {
+			_ = op.GetAdditionalInfo()
+			_ = op.String()
+		}
+	}()
+	cancel()
+	wg.Wait()
+	re.Equal(ContextCanceled, op.GetCancelReason())
+}
+
+func (suite *operatorTestSuite) TestCancelForRegion() {
+	re := suite.Require()
+	ops := []*Operator{
+		suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1}),
+		suite.newTestOperator(1, OpRegion, AddPeer{ToStore: 3, PeerID: 3}),
+		suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2}),
+		suite.newTestOperator(2, OpLeader, TransferLeader{FromStore: 2, ToStore: 1}),
+		nil,
+	}
+	re.True(ops[1].Start())
+	re.True(ops[2].Cancel(AdminStop))
+	re.Equal(2, CancelForRegion(ops, 1, RegionNotFound))
+	re.Equal(CANCELED, ops[0].Status())
+	re.Equal(CANCELED, ops[1].Status())
+	re.Equal(RegionNotFound, ops[0].GetCancelReason())
+	// the ended operator is skipped.
+	re.Equal(AdminStop, ops[2].GetCancelReason())
+	re.Equal(CREATED, ops[3].Status())
+	re.Zero(CancelForRegion(ops, 1, RegionNotFound))
+	re.Zero(CancelForRegion(ops, 3, RegionNotFound))
+}
+
+func (suite *operatorTestSuite) TestShortString() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 2},
+	}
+	op := NewTestOperator(5, nil, OpLeader|OpRegion, steps...)
+	re.Equal(fmt.Sprintf("op#%d region=5 kind=region,leader step=0/2 status=CREATED", op.GetID()), op.ShortString())
+	re.True(op.Start())
+	op.currentStep = 1
+	re.Equal(fmt.Sprintf("op#%d region=5 kind=region,leader step=1/2 status=STARTED", op.GetID()), op.ShortString())
+	re.True(op.Cancel(AdminStop))
+	re.Equal(fmt.Sprintf("op#%d region=5 kind=region,leader step=1/2 status=CANCELED", op.GetID()), op.ShortString())
+}
+
+func (suite *operatorTestSuite) TestFreezeExempt() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	re.False(op.IsFreezeExempt())
+	op.SetFreezeExempt(true)
+	re.True(op.IsFreezeExempt())
+	re.True(op.Clone().IsFreezeExempt())
+	re.True(suite.newTestOperator(1, OpAdmin|OpLeader, TransferLeader{FromStore: 2, ToStore: 1}).IsFreezeExempt())
+	re.True(suite.newTestOperator(1, OpReplica|OpRegion, RemovePeer{FromStore: 2}).IsFreezeExempt())
+}
+
+func (suite *operatorTestSuite) TestGroupID() {
+	re := suite.Require()
+	ops := make([]*Operator, 5)
+	for i := range ops {
+		ops[i] = suite.newTestOperator(uint64(i+1), OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	}
+	re.Zero(ops[0].GetGroupID())
+	ops[1].SetGroupID(42)
+	ops[3].SetGroupID(42)
+	ops[4].SetGroupID(43)
+	re.Equal(uint64(42), ops[1].Clone().GetGroupID())
+
+	re.Equal([]*Operator{ops[1], ops[3]}, FilterByGroup(ops, 42))
+	re.Equal([]*Operator{ops[4]}, FilterByGroup(ops, 43))
+	re.Equal([]*Operator{ops[0], ops[2]}, FilterByGroup(ops, 0))
+	re.Empty(FilterByGroup(ops, 44))
+	re.Empty(FilterByGroup(nil, 42))
+}
+
+func (suite *operatorTestSuite) TestWaitUntilNotHot() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	hotRegion := region.Clone(core.SetWrittenBytes(10000), core.SetReportInterval(0, 10))
+	steps := []OpStep{
+		WaitUntilNotHot{MaxWait: time.Minute, MaxBytesRate: 100},
+		TransferLeader{FromStore: 1, ToStore: 2},
+	}
+	op := suite.newTestOperator(1, OpLeader, steps...)
+	// the max wait doesn't elapse before started.
+	op.SetStatusReachTime(CREATED, time.Now().Add(-2*time.Minute))
+	re.Equal(steps[0], op.PeekStep(hotRegion))
+	re.True(op.Start())
+	re.Equal(steps[0], op.Check(hotRegion))
+	re.Equal(steps[1], op.Check(region))
+
+	// stop waiting after the max wait elapses.
+	op = suite.newTestOperator(1, OpLeader, steps...)
+	re.True(op.Start())
+	re.Equal(steps[0], op.Check(hotRegion))
+	op.SetStatusReachTime(STARTED, time.Now().Add(-time.Minute))
+	re.Equal(steps[1], op.PeekStep(hotRegion))
+	re.Equal(steps[1], op.Check(hotRegion))
+	re.Equal(STARTED, op.Status())
+}
+
+func (suite *operatorTestSuite) TestSoftCancel() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2}, [2]uint64{3, 3})
+	promote := []PromoteLearner{{ToStore: 3, PeerID: 3}}
+	demote := []DemoteVoter{{ToStore: 2, PeerID: 2}}
+	steps := []OpStep{
+		ChangePeerV2Enter{PromoteLearners: promote, DemoteVoters: demote},
+		ChangePeerV2Leave{PromoteLearners: promote, DemoteVoters: demote},
+		RemovePeer{FromStore: 2, PeerID: 2},
+	}
+	region = region.Clone(core.WithRole(3, metapb.PeerRole_Learner))
+	op := suite.newTestOperator(1, OpRegion, steps...)
+	re.True(op.Start())
+	re.Equal(steps[0], op.Check(region))
+	op.SoftCancel()
+	// the in-flight step is not abandoned.
+	re.Equal(steps[0], op.Check(region))
+	re.Equal(STARTED, op.Status())
+	// the region must leave the joint state.
+	region = region.Clone(core.WithRole(3, metapb.PeerRole_IncomingVoter), core.WithRole(2, metapb.PeerRole_DemotingVoter))
+	re.Equal(steps[1], op.Check(region))
+	re.Equal(STARTED, op.Status())
+	region = region.Clone(core.WithRole(3, metapb.PeerRole_Voter), core.WithRole(2, metapb.PeerRole_Learner))
+	re.Nil(op.Check(region))
+	re.Equal(CANCELED, op.Status())
+	re.Equal(SoftCanceled, op.GetCancelReason())
+	re.Equal("soft canceled before step 2 (remove peer on store 2)", op.StatusReason())
+
+	// the soft canceled operator is succeeded if all steps are finished.
+	op = suite.newTestOperator(1, OpRegion, steps[2])
+	re.True(op.Start())
+	re.Equal(steps[2], op.Check(region))
+	op.SoftCancel()
+	re.Nil(op.Check(region.Clone(core.WithRemoveStorePeer(2))))
+	re.Equal(SUCCESS, op.Status())
+
+	// the soft cancel in Check races with the cancel by others, only one of the reasons is recorded.
+	op = suite.newTestOperator(1, OpRegion, steps[2])
+	re.True(op.Start())
+	op.SoftCancel()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		re.Nil(op.Check(region))
+	}()
+	go func() {
+		defer wg.Done()
+		_ = op.Cancel(AdminStop)
+		_ = op.GetAdditionalInfo()
+	}()
+	wg.Wait()
+	re.Equal(CANCELED, op.Status())
+	re.Contains([]CancelReasonType{SoftCanceled, AdminStop}, op.GetCancelReason())
+	re.Contains(op.GetAdditionalInfo(), string(op.GetCancelReason()))
+}
+
+func (suite *operatorTestSuite) TestReset() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 1, ToStore: 2})
+	op.SetSource("test")
+	op.SetLabel("tenant", "t1")
+	op.ExtendTimeout(time.Minute)
+	re.True(op.Start())
+	re.True(op.Cancel(AdminStop))
+	id := op.GetID()
+
+	op.Reset()
+	re.Greater(op.GetID(), id)
+	re.Equal(CREATED, op.Status())
+	re.Zero(op.Len())
+	re.Zero(op.RegionID())
+	re.Zero(op.Timeout())
+	re.Empty(op.Source())
+	re.Empty(op.AdditionalInfos)
+	re.Empty(op.GetCancelReason())
+	_, ok := op.GetLabel("tenant")
+	re.False(ok)
+
+	// the reset operator can be used again.
+	re.NoError(op.AppendStep(TransferLeader{FromStore: 2, ToStore: 1}))
+	re.True(op.Start())
+	re.Nil(op.Check(region))
+	re.Equal(SUCCESS, op.Status())
+}
+
+func (suite *operatorTestSuite) TestLabels() {
+	re := suite.Require()
+	op := suite.newTestOperator(1, OpLeader, TransferLeader{FromStore: 2, ToStore: 1})
+	_, ok := op.GetLabel("tenant")
+	re.False(ok)
+	re.Nil(op.ToStructuredJSONObject().Labels)
+	op.SetLabel("tenant", "t1")
+	value, ok := op.GetLabel("tenant")
+	re.True(ok)
+	re.Equal("t1", value)
+	re.Empty(op.AdditionalInfos)
+	re.Equal(map[string]string{"tenant": "t1"}, op.ToStructuredJSONObject().Labels)
+
+	clone := op.Clone()
+	clone.SetLabel("tenant", "t2")
+	value, _ = op.GetLabel("tenant")
+	re.Equal("t1", value)
+	value, _ = clone.GetLabel("tenant")
+	re.Equal("t2", value)
+}
+
+func (suite *operatorTestSuite) TestEstimatedCompletion() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddLearner{ToStore: 2, PeerID: 2},
+		AddLearner{ToStore: 3, PeerID: 3},
+		RemovePeer{FromStore: 1},
+	}
+	op := suite.newTestOperator(1, OpRegion, steps...)
+	re.True(op.EstimatedCompletion().IsZero())
+
+	re.True(op.Start())
+	re.Equal(op.GetStartTime().Add(op.Timeout()), op.EstimatedCompletion())
+
+	// the first step takes half of its budget, so do the remaining steps.
+	start := op.GetStartTime()
+	budget := steps[0].Timeout(op.ApproximateSize)
+	lastFinish := start.Add(budget / 2)
+	op.stepsTime[0] = lastFinish.UnixNano()
+	op.currentStep = 1
+	remaining := steps[1].Timeout(op.ApproximateSize) + steps[2].Timeout(op.ApproximateSize)
+	re.True(lastFinish.Add(remaining / 2).Equal(op.EstimatedCompletion()))
+
+	re.True(op.Cancel(AdminStop))
+	re.Equal(op.GetFinishTime(), op.EstimatedCompletion())
+}
+
+func (suite *operatorTestSuite) TestCurrentStepMovesLeader() {
+	re := suite.Require()
+	region := suite.newTestRegion(1, 1, [2]uint64{1, 1}, [2]uint64{2, 2})
+	op := suite.newTestOperator(1, OpRegion|OpLeader,
+		AddLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 2},
+		RemovePeer{FromStore: 1},
+	)
+	re.False(op.CurrentStepMovesLeader(region))
+	op.currentStep = 1
+	re.True(op.CurrentStepMovesLeader(region))
+	re.True(op.CurrentStepMovesLeader(nil))
+	// the leader has been moved.
+	re.False(op.CurrentStepMovesLeader(region.Clone(core.WithLeader(region.GetStorePeer(2)))))
+	op.currentStep = 2
+	re.False(op.CurrentStepMovesLeader(region))
+	op.currentStep = 3
+	re.False(op.CurrentStepMovesLeader(region))
+
+	op = suite.newTestOperator(1, OpLeader, TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2}})
+	re.True(op.CurrentStepMovesLeader(region))
+}
+
+func (suite *operatorTestSuite) TestIsStalled() {
+	re := suite.Require()
+	steps := []OpStep{
+		AddPeer{ToStore: 1, PeerID: 1},
+		TransferLeader{FromStore: 2, ToStore: 1},
+	}
+	op := suite.newTestOperator(1, OpLeader|OpRegion, steps...)
+	re.False(op.IsStalled())
+	re.True(op.Start())
+	re.False(op.IsStalled())
+	op.SetStatusReachTime(STARTED, time.Now().Add(-SlowStepWaitTime-time.Second))
+	re.True(op.IsStalled())
+
+	// the second step starts from the finish time of the first step.
+	region := suite.newTestRegion(1, 2, [2]uint64{1, 1}, [2]uint64{2, 2})
+	re.Equal(steps[1], op.Check(region))
+	re.False(op.IsStalled())
+	atomic.StoreInt64(&op.stepsTime[0], time.Now().Add(-FastStepWaitTime-time.Second).UnixNano())
+	re.True(op.IsStalled())
+}
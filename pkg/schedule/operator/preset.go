@@ -0,0 +1,83 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"fmt"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/pd/pkg/core"
+)
+
+// The presets assemble the canonical steps of the common scheduling patterns without a cluster.
+// Unlike the Builder, they don't check the stores, so the caller should make sure the pattern is
+// applicable, such as the peer to be removed by RemoveReplica is not the leader. The new peer ID
+// must be allocated by the caller. The presets removing a peer take the region rather than its ID,
+// epoch and size, since the removed peer ID and the leader can't be derived from them.
+
+// MovePeer creates an operator that moves the peer from a store to another one with the canonical
+// steps: the new peer is added as a learner and promoted, then the leader is transferred to it if
+// the old peer is the leader, and the old peer is removed at last. It returns nil if there is no
+// peer in fromStore or there is already a peer in toStore.
+func MovePeer(region *core.RegionInfo, fromStore, toStore, newPeerID uint64) *Operator {
+	oldPeer := region.GetStorePeer(fromStore)
+	if oldPeer == nil || region.GetStorePeer(toStore) != nil {
+		return nil
+	}
+	kind := OpRegion
+	leaderStore := region.GetLeader().GetStoreId()
+	steps := []OpStep{
+		AddLearner{ToStore: toStore, PeerID: newPeerID, SendStore: leaderStore},
+		PromoteLearner{ToStore: toStore, PeerID: newPeerID},
+	}
+	if leaderStore == fromStore {
+		kind |= OpLeader
+		steps = append(steps, TransferLeader{FromStore: fromStore, ToStore: toStore})
+	}
+	steps = append(steps, RemovePeer{FromStore: fromStore, PeerID: oldPeer.GetId()})
+	return NewOperator("move-peer", fmt.Sprintf("mv peer: store [%d] to [%d]", fromStore, toStore),
+		region.GetID(), region.GetRegionEpoch(), kind, region.GetApproximateSize(), steps...)
+}
+
+// MoveLeader creates an operator that transfers the leader from a store to another one.
+func MoveLeader(regionID uint64, epoch *metapb.RegionEpoch, fromStore, toStore uint64, size int64) *Operator {
+	return NewOperator("move-leader", fmt.Sprintf("transfer leader: store %d to %d", fromStore, toStore),
+		regionID, epoch, OpLeader, size,
+		TransferLeader{FromStore: fromStore, ToStore: toStore},
+	)
+}
+
+// AddReplica creates an operator that adds a voter to the store. The new peer is added as a
+// learner and promoted to avoid affecting the availability during the snapshot.
+func AddReplica(regionID uint64, epoch *metapb.RegionEpoch, toStore, newPeerID uint64, size int64) *Operator {
+	return NewOperator("add-replica", fmt.Sprintf("add peer: store [%d]", toStore),
+		regionID, epoch, OpRegion, size,
+		AddLearner{ToStore: toStore, PeerID: newPeerID},
+		PromoteLearner{ToStore: toStore, PeerID: newPeerID},
+	)
+}
+
+// RemoveReplica creates an operator that removes the peer from the store. It returns nil if there is
+// no peer in the store or the peer is the leader.
+func RemoveReplica(region *core.RegionInfo, fromStore uint64) *Operator {
+	peer := region.GetStorePeer(fromStore)
+	if peer == nil || region.GetLeader().GetStoreId() == fromStore {
+		return nil
+	}
+	return NewOperator("remove-replica", fmt.Sprintf("rm peer: store [%d]", fromStore),
+		region.GetID(), region.GetRegionEpoch(), OpRegion, region.GetApproximateSize(),
+		RemovePeer{FromStore: fromStore, PeerID: peer.GetId()},
+	)
+}
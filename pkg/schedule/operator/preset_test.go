@@ -0,0 +1,78 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/pkg/core"
+)
+
+func TestPresets(t *testing.T) {
+	re := require.New(t)
+	epoch := &metapb.RegionEpoch{ConfVer: 1, Version: 1}
+	region := newSimulateRegion(&metapb.Peer{Id: 1, StoreId: 1}, &metapb.Peer{Id: 2, StoreId: 2})
+
+	for _, fromStore := range []uint64{1, 2} {
+		op := MovePeer(region, fromStore, 3, 3)
+		steps := []OpStep{
+			AddLearner{ToStore: 3, PeerID: 3, SendStore: 1},
+			PromoteLearner{ToStore: 3, PeerID: 3},
+		}
+		if fromStore == 1 {
+			// the leader is transferred to the new peer before the old one is removed.
+			re.Equal(OpRegion|OpLeader, op.Kind())
+			steps = append(steps, TransferLeader{FromStore: 1, ToStore: 3})
+		} else {
+			re.Equal(OpRegion, op.Kind())
+		}
+		steps = append(steps, RemovePeer{FromStore: fromStore, PeerID: fromStore})
+		re.Equal(steps, op.steps)
+		re.Equal(region.GetRegionEpoch(), op.RegionEpoch())
+		re.Equal(region.GetApproximateSize(), op.ApproximateSize)
+		moved := region
+		for !op.IsEnd() {
+			moved, _ = op.SimulateStep(moved)
+		}
+		re.Equal(SUCCESS, op.Status())
+		re.Nil(moved.GetStorePeer(fromStore))
+		re.True(core.IsVoter(moved.GetStorePeer(3)))
+		if fromStore == 1 {
+			re.Equal(uint64(3), moved.GetLeader().GetStoreId())
+		}
+	}
+	re.Nil(MovePeer(region, 3, 4, 4))
+	re.Nil(MovePeer(region, 1, 2, 3))
+
+	op := MoveLeader(1, epoch, 1, 2, 10)
+	re.Equal(OpLeader, op.Kind())
+	re.Equal([]OpStep{TransferLeader{FromStore: 1, ToStore: 2}}, op.steps)
+
+	op = AddReplica(1, epoch, 3, 3, 10)
+	re.Equal(OpRegion, op.Kind())
+	re.Equal([]OpStep{
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+	}, op.steps)
+
+	op = RemoveReplica(region, 2)
+	re.Equal(OpRegion, op.Kind())
+	re.Equal([]OpStep{RemovePeer{FromStore: 2, PeerID: 2}}, op.steps)
+	re.Equal(region.GetRegionEpoch(), op.RegionEpoch())
+	re.Nil(RemoveReplica(region, 1))
+	re.Nil(RemoveReplica(region, 3))
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/pd/pkg/core"
+)
+
+// SimulateStep applies the effect of the current step to a copy of the given region without a real
+// cluster, and advances the current step by checking the operator with the new region. It returns
+// the new region and the applied step. If there is no step to apply, the given region and nil are
+// returned. The operator is started if it has not been started yet.
+// Note: MergeRegion and SplitRegion can not be simulated on a single region, so the region is not
+// changed by them, and the operator will stay on these steps.
+func (o *Operator) SimulateStep(region *core.RegionInfo) (*core.RegionInfo, OpStep) {
+	_ = o.Start()
+	step := o.Check(region)
+	if step == nil {
+		return region, nil
+	}
+	region = simulateStep(step, region)
+	// there is no finished time of the step in simulation, so check it again to advance the step.
+	_ = o.Check(region)
+	return region, step
+}
+
+// simulateStep returns a copy of the region which the step has been applied to.
+func simulateStep(step OpStep, region *core.RegionInfo) *core.RegionInfo {
+	var opts []core.RegionCreateOption
+	switch s := step.(type) {
+	case TransferLeader:
+		opts = append(opts, core.WithLeader(region.GetStorePeer(s.ToStore)))
+	case TransferLeaderToCandidates:
+		if len(s.ToStores) > 0 {
+			opts = append(opts, core.WithLeader(region.GetStorePeer(s.ToStores[0])))
+		}
+	case AddPeer:
+		opts = append(opts, core.WithAddPeer(&metapb.Peer{Id: s.PeerID, StoreId: s.ToStore, IsWitness: s.IsWitness}))
+	case AddPeerWithPriority:
+		opts = append(opts, core.WithAddPeer(&metapb.Peer{Id: s.PeerID, StoreId: s.ToStore, IsWitness: s.IsWitness}))
+	case AddLearner:
+		opts = append(opts, core.WithAddPeer(&metapb.Peer{Id: s.PeerID, StoreId: s.ToStore, Role: metapb.PeerRole_Learner, IsWitness: s.IsWitness}))
+	case PromoteLearner:
+		opts = append(opts, core.WithRole(s.PeerID, metapb.PeerRole_Voter))
+	case DemoteVoterToLearner:
+		opts = append(opts, core.WithRole(region.GetStorePeer(s.StoreID).GetId(), metapb.PeerRole_Learner))
+	case RemovePeer:
+		opts = append(opts, core.WithRemoveStorePeer(s.FromStore))
+	case RemoveLearners:
+		for _, storeID := range s.StoreIDs {
+			opts = append(opts, core.WithRemoveStorePeer(storeID))
+		}
+	case BecomeWitness:
+		opts = append(opts, withWitness(s.PeerID, true))
+	case BecomeNonWitness:
+		opts = append(opts, withWitness(s.PeerID, false))
+	case BatchSwitchWitness:
+		for _, w := range s.ToWitnesses {
+			opts = append(opts, withWitness(w.PeerID, true))
+		}
+		for _, nw := range s.ToNonWitnesses {
+			opts = append(opts, withWitness(nw.PeerID, false))
+		}
+	case ChangePeerV2Enter:
+		for _, pl := range s.PromoteLearners {
+			opts = append(opts, core.WithRole(pl.PeerID, metapb.PeerRole_IncomingVoter))
+		}
+		for _, dv := range s.DemoteVoters {
+			opts = append(opts, core.WithRole(dv.PeerID, metapb.PeerRole_DemotingVoter))
+		}
+	case ChangePeerV2Leave:
+		for _, pl := range s.PromoteLearners {
+			opts = append(opts, core.WithRole(pl.PeerID, metapb.PeerRole_Voter))
+		}
+		for _, dv := range s.DemoteVoters {
+			opts = append(opts, core.WithRole(dv.PeerID, metapb.PeerRole_Learner))
+		}
+	default:
+		return region
+	}
+	region = region.Clone(opts...)
+	if delta := step.ConfVerChanged(region); delta > 0 {
+		region = region.Clone(core.SetRegionConfVer(region.GetRegionEpoch().GetConfVer() + delta))
+	}
+	return region
+}
+
+func withWitness(peerID uint64, isWitness bool) core.RegionCreateOption {
+	return func(region *core.RegionInfo) {
+		for _, p := range region.GetPeers() {
+			if p.GetId() == peerID {
+				p.IsWitness = isWitness
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/pkg/core"
+)
+
+func newSimulateRegion(peers ...*metapb.Peer) *core.RegionInfo {
+	return core.NewRegionInfo(&metapb.Region{
+		Id:          1,
+		Peers:       peers,
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+	}, peers[0])
+}
+
+func TestSimulateStep(t *testing.T) {
+	re := require.New(t)
+	region := newSimulateRegion(&metapb.Peer{Id: 1, StoreId: 1}, &metapb.Peer{Id: 2, StoreId: 2})
+	steps := []OpStep{
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		TransferLeader{FromStore: 1, ToStore: 3},
+		RemovePeer{FromStore: 1, PeerID: 1},
+	}
+	op := NewTestOperator(1, region.GetRegionEpoch(), OpRegion|OpLeader, steps...)
+	var applied []OpStep
+	for i := 0; !op.IsEnd() && i < 10; i++ {
+		var step OpStep
+		region, step = op.SimulateStep(region)
+		re.NotNil(step)
+		applied = append(applied, step)
+	}
+	re.Equal(steps, applied)
+	re.Equal(SUCCESS, op.Status())
+	re.Nil(region.GetStorePeer(1))
+	re.Len(region.GetVoters(), 2)
+	re.Equal(uint64(3), region.GetLeader().GetStoreId())
+	re.Equal(uint64(1)+op.ConfVerChanged(region), region.GetRegionEpoch().GetConfVer())
+
+	// nothing to apply when the operator is ended.
+	newRegion, step := op.SimulateStep(region)
+	re.Nil(step)
+	re.Equal(region, newRegion)
+}
+
+func TestSimulateJointConsensus(t *testing.T) {
+	re := require.New(t)
+	region := newSimulateRegion(
+		&metapb.Peer{Id: 1, StoreId: 1},
+		&metapb.Peer{Id: 2, StoreId: 2},
+		&metapb.Peer{Id: 3, StoreId: 3, Role: metapb.PeerRole_Learner},
+	)
+	promote := []PromoteLearner{{ToStore: 3, PeerID: 3}}
+	demote := []DemoteVoter{{ToStore: 2, PeerID: 2}}
+	op := NewTestOperator(1, region.GetRegionEpoch(), OpRegion,
+		ChangePeerV2Enter{PromoteLearners: promote, DemoteVoters: demote},
+		ChangePeerV2Leave{PromoteLearners: promote, DemoteVoters: demote},
+	)
+	region, _ = op.SimulateStep(region)
+	re.Equal(metapb.PeerRole_IncomingVoter, region.GetStorePeer(3).GetRole())
+	re.Equal(metapb.PeerRole_DemotingVoter, region.GetStorePeer(2).GetRole())
+	region, _ = op.SimulateStep(region)
+	re.True(core.IsVoter(region.GetStorePeer(3)))
+	re.True(core.IsLearner(region.GetStorePeer(2)))
+	re.Equal(SUCCESS, op.Status())
+}
@@ -26,7 +26,7 @@ const (
 	// Status list
 	// Just created. Next status: {RUNNING, CANCELED, EXPIRED}.
 	CREATED OpStatus = iota
-	// Started and not finished. Next status: {SUCCESS, CANCELED, REPLACED, TIMEOUT}.
+	// Started and not finished. Next status: {PAUSED, SUCCESS, CANCELED, REPLACED, TIMEOUT}.
 	STARTED
 	// Followings are end status, i.e. no next status.
 	SUCCESS  // Finished successfully
@@ -34,14 +34,28 @@ const (
 	REPLACED // Replaced by a higher priority operator
 	EXPIRED  // Didn't start to run for too long
 	TIMEOUT  // Running for too long
+	// Started but paused. Next status: {STARTED, CANCELED, REPLACED, TIMEOUT}.
+	// It's placed after the end status so that the values of the existing status are not changed.
+	PAUSED
 	// Status list end
 	statusCount    // Total count of status
 	firstEndStatus = SUCCESS
+	lastEndStatus  = TIMEOUT
 )
 
 type transition [statusCount][statusCount]bool
 
 // Valid status transition
+//
+//	CREATED --> STARTED <--> PAUSED
+//	   |           |            |
+//	   v           v            v
+//	CANCELED    SUCCESS      CANCELED
+//	EXPIRED     CANCELED     REPLACED
+//	            REPLACED     TIMEOUT
+//	            TIMEOUT
+//
+// The CREATED operator is queued until it is started or it is expired, see Operator.IsQueued.
 var validTrans = transition{
 	CREATED: {
 		STARTED:  true,
@@ -49,11 +63,18 @@ var validTrans = transition{
 		EXPIRED:  true,
 	},
 	STARTED: {
+		PAUSED:   true,
 		SUCCESS:  true,
 		CANCELED: true,
 		REPLACED: true,
 		TIMEOUT:  true,
 	},
+	PAUSED: {
+		STARTED:  true,
+		CANCELED: true,
+		REPLACED: true,
+		TIMEOUT:  true,
+	},
 	SUCCESS:  {},
 	CANCELED: {},
 	REPLACED: {},
@@ -64,6 +85,7 @@ var validTrans = transition{
 var statusString = [statusCount]string{
 	CREATED:  "Created",
 	STARTED:  "Started",
+	PAUSED:   "Paused",
 	SUCCESS:  "Success",
 	CANCELED: "Canceled",
 	REPLACED: "Replaced",
@@ -77,6 +99,7 @@ var pdpbStatus = [statusCount]pdpb.OperatorStatus{
 	// FIXME: use a valid status
 	CREATED:  invalid,
 	STARTED:  pdpb.OperatorStatus_RUNNING,
+	PAUSED:   pdpb.OperatorStatus_RUNNING,
 	SUCCESS:  pdpb.OperatorStatus_SUCCESS,
 	CANCELED: pdpb.OperatorStatus_CANCEL,
 	REPLACED: pdpb.OperatorStatus_REPLACE,
@@ -87,7 +110,7 @@ var pdpbStatus = [statusCount]pdpb.OperatorStatus{
 
 // IsEndStatus checks whether s is an end status.
 func IsEndStatus(s OpStatus) bool {
-	return firstEndStatus <= s && s < statusCount
+	return firstEndStatus <= s && s <= lastEndStatus
 }
 
 // OpStatusToPDPB converts OpStatus to pdpb.OperatorStatus.
@@ -25,10 +25,28 @@ func TestIsEndStatus(t *testing.T) {
 	for st := OpStatus(0); st < firstEndStatus; st++ {
 		re.False(IsEndStatus(st))
 	}
-	for st := firstEndStatus; st < statusCount; st++ {
+	for st := firstEndStatus; st <= lastEndStatus; st++ {
 		re.True(IsEndStatus(st))
 	}
+	re.False(IsEndStatus(PAUSED))
 	for st := statusCount; st < statusCount+100; st++ {
 		re.False(IsEndStatus(st))
 	}
 }
+
+func TestStatusValue(t *testing.T) {
+	re := require.New(t)
+	// the status is serialized as a number, so the values must not be changed.
+	for st, v := range map[OpStatus]uint32{
+		CREATED:  0,
+		STARTED:  1,
+		SUCCESS:  2,
+		CANCELED: 3,
+		REPLACED: 4,
+		EXPIRED:  5,
+		TIMEOUT:  6,
+		PAUSED:   7,
+	} {
+		re.Equal(v, st, OpStatusToString(st))
+	}
+}
@@ -20,14 +20,16 @@ import (
 	"github.com/tikv/pd/pkg/utils/syncutil"
 )
 
-// Only record non-end status and one end status.
-type statusTimes [firstEndStatus + 1]time.Time
+// Record the reach time of each status, only one end status can be reached since it's terminal.
+type statusTimes [statusCount]time.Time
 
 // OpStatusTracker represents the status of an operator.
 type OpStatusTracker struct {
 	rw         syncutil.RWMutex
-	current    OpStatus    // Current status
-	reachTimes statusTimes // Time when reach the current status
+	current    OpStatus      // Current status
+	reachTimes statusTimes   // Time when reach the current status
+	paused     time.Duration // Total duration of being paused
+	onEnd      []func(OpStatus)
 }
 
 // NewOpStatusTracker creates an OpStatus.
@@ -60,38 +62,131 @@ func (trk *OpStatusTracker) ReachTimeOf(s OpStatus) time.Time {
 }
 
 func (trk *OpStatusTracker) getTime(s OpStatus) time.Time {
-	if s < firstEndStatus {
+	if s < statusCount {
 		return trk.reachTimes[s]
-	} else if trk.current == s {
-		return trk.reachTimes[firstEndStatus]
-	} else {
-		return time.Time{}
 	}
+	return time.Time{}
 }
 
 // To transfer the current status to dst if this transition is valid,
 // returns whether transferred.
 func (trk *OpStatusTracker) To(dst OpStatus) bool {
+	defer trk.fireEndCallbacks()
 	trk.rw.Lock()
 	defer trk.rw.Unlock()
 	return trk.toLocked(dst)
 }
 
+// ToWith is like To, but f is called with the lock held once transferred, so that the state
+// updated by f is visible to anyone who observes the new status.
+func (trk *OpStatusTracker) ToWith(dst OpStatus, f func()) bool {
+	defer trk.fireEndCallbacks()
+	trk.rw.Lock()
+	defer trk.rw.Unlock()
+	if !trk.toLocked(dst) {
+		return false
+	}
+	f()
+	return true
+}
+
+// OnEnd registers a callback which will be called once when reaching an end status.
+// If the current status is already an end status, the callback is called immediately.
+func (trk *OpStatusTracker) OnEnd(f func(OpStatus)) {
+	if f == nil {
+		return
+	}
+	trk.rw.Lock()
+	trk.onEnd = append(trk.onEnd, f)
+	trk.rw.Unlock()
+	trk.fireEndCallbacks()
+}
+
+// fireEndCallbacks calls the registered callbacks outside the lock if it is at an end status.
+// The callbacks are removed once taken, so each of them is called at most once.
+func (trk *OpStatusTracker) fireEndCallbacks() {
+	trk.rw.Lock()
+	if !IsEndStatus(trk.current) || len(trk.onEnd) == 0 {
+		trk.rw.Unlock()
+		return
+	}
+	st, callbacks := trk.current, trk.onEnd
+	trk.onEnd = nil
+	trk.rw.Unlock()
+	for _, f := range callbacks {
+		f(st)
+	}
+}
+
 func (trk *OpStatusTracker) toLocked(dst OpStatus) bool {
+	// the end status is terminal, it's checked with the lock held so that
+	// the transition can't be raced by another one.
+	if IsEndStatus(trk.current) {
+		return false
+	}
 	if dst < statusCount && validTrans[trk.current][dst] {
+		now := time.Now()
+		if trk.current == PAUSED && dst == STARTED {
+			// resume from paused, keep the start time and exclude the paused duration.
+			trk.paused += now.Sub(trk.reachTimes[PAUSED])
+			trk.current = dst
+			return true
+		}
 		trk.current = dst
-		trk.setTime(trk.current, time.Now())
+		trk.setTime(trk.current, now)
 		return true
 	}
 	return false
 }
 
-func (trk *OpStatusTracker) setTime(st OpStatus, t time.Time) {
-	if st < firstEndStatus {
-		trk.reachTimes[st] = t
-	} else {
-		trk.reachTimes[firstEndStatus] = t
+// PausedDuration returns the total duration of being paused, including the current pause.
+func (trk *OpStatusTracker) PausedDuration() time.Duration {
+	trk.rw.RLock()
+	defer trk.rw.RUnlock()
+	return trk.pausedDurationLocked()
+}
+
+func (trk *OpStatusTracker) pausedDurationLocked() time.Duration {
+	if trk.current == PAUSED {
+		return trk.paused + time.Since(trk.reachTimes[PAUSED])
 	}
+	return trk.paused
+}
+
+// DwellTime returns how long it stayed in the given status, which is the duration from reaching
+// the status to reaching the next one. For the current status, it returns the duration since reached.
+// The paused duration is counted in PAUSED rather than STARTED. If didn't reach the given status, return zero.
+func (trk *OpStatusTracker) DwellTime(s OpStatus) time.Duration {
+	trk.rw.RLock()
+	defer trk.rw.RUnlock()
+	reach := trk.getTime(s)
+	if reach.IsZero() {
+		return 0
+	}
+	switch s {
+	case PAUSED:
+		return trk.pausedDurationLocked()
+	case STARTED:
+		end := time.Now()
+		if IsEndStatus(trk.current) {
+			end = trk.getTime(trk.current)
+		}
+		return end.Sub(reach) - trk.pausedDurationLocked()
+	}
+	if s == trk.current {
+		return time.Since(reach)
+	}
+	var next time.Time
+	for st := OpStatus(0); st < statusCount; st++ {
+		if t := trk.getTime(st); st != s && !t.IsZero() && !t.Before(reach) && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next.Sub(reach)
+}
+
+func (trk *OpStatusTracker) setTime(st OpStatus, t time.Time) {
+	trk.reachTimes[st] = t
 }
 
 // IsEnd checks whether the current status is an end status.
@@ -103,6 +198,7 @@ func (trk *OpStatusTracker) IsEnd() bool {
 
 // CheckExpired checks if expired, and update the current status.
 func (trk *OpStatusTracker) CheckExpired(exp time.Duration) bool {
+	defer trk.fireEndCallbacks()
 	trk.rw.Lock()
 	defer trk.rw.Unlock()
 	if trk.current == CREATED {
@@ -117,11 +213,12 @@ func (trk *OpStatusTracker) CheckExpired(exp time.Duration) bool {
 
 // CheckTimeout returns true if timeout, and update the current status.
 func (trk *OpStatusTracker) CheckTimeout(duration time.Duration) bool {
+	defer trk.fireEndCallbacks()
 	trk.rw.Lock()
 	defer trk.rw.Unlock()
 	if trk.current == STARTED {
 		start := trk.getTime(STARTED)
-		if time.Since(start) < duration {
+		if time.Since(start)-trk.pausedDurationLocked() < duration {
 			return false
 		}
 		_ = trk.toLocked(TIMEOUT)
@@ -130,6 +227,19 @@ func (trk *OpStatusTracker) CheckTimeout(duration time.Duration) bool {
 	return trk.current == TIMEOUT
 }
 
+// TimeoutNow sets the started or paused status to TIMEOUT regardless of the running duration,
+// returns true if timeout.
+func (trk *OpStatusTracker) TimeoutNow() bool {
+	defer trk.fireEndCallbacks()
+	trk.rw.Lock()
+	defer trk.rw.Unlock()
+	if trk.current == STARTED || trk.current == PAUSED {
+		_ = trk.toLocked(TIMEOUT)
+		return true
+	}
+	return trk.current == TIMEOUT
+}
+
 // String implements fmt.Stringer.
 func (trk *OpStatusTracker) String() string {
 	trk.rw.RLock()
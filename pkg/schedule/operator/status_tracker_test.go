@@ -15,6 +15,8 @@
 package operator
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -77,7 +79,7 @@ func TestEndStatusTrans(t *testing.T) {
 	for st := OpStatus(0); st < statusCount; st++ {
 		allStatus = append(allStatus, st)
 	}
-	for from := firstEndStatus; from < statusCount; from++ {
+	for from := firstEndStatus; from <= lastEndStatus; from++ {
 		trk := NewOpStatusTracker()
 		trk.current = from
 		re.True(trk.IsEnd())
@@ -85,6 +87,18 @@ func TestEndStatusTrans(t *testing.T) {
 	}
 }
 
+func TestToWith(t *testing.T) {
+	re := require.New(t)
+	trk := NewOpStatusTracker()
+	var called int
+	re.True(trk.ToWith(CANCELED, func() { called++ }))
+	re.Equal(1, called)
+	re.Equal(CANCELED, trk.Status())
+	// f is not called if the transition is invalid.
+	re.False(trk.ToWith(CANCELED, func() { called++ }))
+	re.Equal(1, called)
+}
+
 func TestCheckExpired(t *testing.T) {
 	re := require.New(t)
 	{
@@ -141,6 +155,108 @@ func TestCheckStepTimeout(t *testing.T) {
 	}
 }
 
+func TestPauseAndResume(t *testing.T) {
+	re := require.New(t)
+	trk := NewOpStatusTracker()
+	checkInvalidTrans(re, &trk, PAUSED)
+	checkValidTrans(re, &trk, STARTED)
+	checkValidTrans(re, &trk, PAUSED)
+	checkInvalidTrans(re, &trk, SUCCESS, EXPIRED)
+	re.False(trk.IsEnd())
+
+	// paused duration is not counted for timeout.
+	start := time.Now().Add(-(SlowStepWaitTime + time.Second))
+	trk.reachTimes[STARTED] = start
+	trk.reachTimes[PAUSED] = time.Now().Add(-2 * time.Second)
+	re.False(trk.CheckTimeout(SlowStepWaitTime))
+	re.Equal(PAUSED, trk.Status())
+
+	// resume keeps the start time.
+	re.True(trk.To(STARTED))
+	re.Equal(start, trk.ReachTimeOf(STARTED))
+	re.GreaterOrEqual(trk.PausedDuration(), 2*time.Second)
+	re.False(trk.CheckTimeout(SlowStepWaitTime))
+	re.Equal(STARTED, trk.Status())
+	re.True(trk.CheckTimeout(SlowStepWaitTime - 2*time.Second))
+	re.Equal(TIMEOUT, trk.Status())
+
+	// the paused status can be timeout regardless of the running duration.
+	trk = NewOpStatusTracker()
+	re.False(trk.TimeoutNow())
+	re.True(trk.To(STARTED))
+	re.True(trk.To(PAUSED))
+	re.True(trk.TimeoutNow())
+	re.Equal(TIMEOUT, trk.Status())
+	re.True(trk.TimeoutNow())
+}
+
+func TestOnEnd(t *testing.T) {
+	re := require.New(t)
+	trk := NewOpStatusTracker()
+	var count int32
+	var status OpStatus
+	trk.OnEnd(func(st OpStatus) {
+		atomic.AddInt32(&count, 1)
+		status = st
+	})
+	re.True(trk.To(STARTED))
+	re.Zero(atomic.LoadInt32(&count))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trk.To(CANCELED)
+			trk.To(REPLACED)
+			trk.CheckTimeout(0)
+		}()
+	}
+	wg.Wait()
+	re.Equal(int32(1), atomic.LoadInt32(&count))
+	re.Equal(trk.Status(), status)
+
+	// register after ended.
+	trk.OnEnd(func(st OpStatus) {
+		atomic.AddInt32(&count, 1)
+	})
+	re.Equal(int32(2), atomic.LoadInt32(&count))
+
+	// status is readable in the callback.
+	trk = NewOpStatusTracker()
+	trk.OnEnd(func(OpStatus) {
+		re.True(trk.IsEnd())
+	})
+	re.True(trk.CheckExpired(0))
+}
+
+func TestDwellTime(t *testing.T) {
+	re := require.New(t)
+	trk := NewOpStatusTracker()
+	re.Zero(trk.DwellTime(STARTED))
+	re.Zero(trk.DwellTime(SUCCESS))
+
+	now := time.Now()
+	trk.reachTimes[CREATED] = now.Add(-10 * time.Second)
+	re.GreaterOrEqual(trk.DwellTime(CREATED), 10*time.Second)
+
+	re.True(trk.To(STARTED))
+	trk.reachTimes[STARTED] = now.Add(-7 * time.Second)
+	re.Equal(3*time.Second, trk.DwellTime(CREATED))
+
+	// the paused duration is not counted in STARTED.
+	re.True(trk.To(PAUSED))
+	trk.reachTimes[PAUSED] = now.Add(-5 * time.Second)
+	re.True(trk.To(STARTED))
+	re.GreaterOrEqual(trk.DwellTime(PAUSED), 5*time.Second)
+
+	re.True(trk.To(SUCCESS))
+	trk.reachTimes[firstEndStatus] = now.Add(-time.Second)
+	re.Equal(6*time.Second-trk.PausedDuration(), trk.DwellTime(STARTED))
+	re.GreaterOrEqual(trk.DwellTime(SUCCESS), time.Second)
+	re.Zero(trk.DwellTime(CANCELED))
+}
+
 func checkTimeOrder(re *require.Assertions, t1, t2, t3 time.Time) {
 	re.True(t1.Before(t2))
 	re.True(t3.After(t2))
@@ -20,6 +20,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/eraftpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
@@ -31,6 +32,7 @@ import (
 	"github.com/tikv/pd/pkg/schedule/hbstream"
 	"github.com/tikv/pd/pkg/utils/typeutil"
 	"go.uber.org/zap"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -48,6 +50,10 @@ const (
 	// SlowStepWaitTime is the duration that the OpStep may take.
 	// there are some steps that may take a long time, such as add peer, merge region etc.
 	SlowStepWaitTime = 10 * time.Minute
+	// metadataStepCost is the approximate cost of the OpStep which only changes the metadata of region,
+	// such as transfer leader, remove peer etc. The cost of the OpStep which needs to send snapshot
+	// is proportional to the region size(MB).
+	metadataStepCost int64 = 1
 )
 
 // OpStep describes the basic scheduling steps that can not be subdivided.
@@ -58,6 +64,8 @@ type OpStep interface {
 	CheckInProgress(ci *core.BasicCluster, config config.SharedConfigProvider, region *core.RegionInfo) error
 	Influence(opInfluence OpInfluence, region *core.RegionInfo)
 	Timeout(regionSize int64) time.Duration
+	ApproximateCost(regionSize int64) int64
+	Equal(other OpStep) bool
 	GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation
 }
 
@@ -126,6 +134,17 @@ func (tl TransferLeader) Timeout(regionSize int64) time.Duration {
 	return fastStepWaitDuration(regionSize)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (tl TransferLeader) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (tl TransferLeader) Equal(other OpStep) bool {
+	o, ok := other.(TransferLeader)
+	return ok && tl.FromStore == o.FromStore && tl.ToStore == o.ToStore && slices.Equal(tl.ToStores, o.ToStores)
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (tl TransferLeader) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	peers := make([]*metapb.Peer, 0, len(tl.ToStores))
@@ -140,6 +159,110 @@ func (tl TransferLeader) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *
 	}
 }
 
+// TransferLeaderToCandidates is an OpStep that transfers a region's leader to
+// one of the candidate stores, the target is decided by TiKV at execution time.
+type TransferLeaderToCandidates struct {
+	FromStore uint64
+	ToStores  []uint64
+}
+
+// ConfVerChanged returns the delta value for version increased by this step.
+func (tlc TransferLeaderToCandidates) ConfVerChanged(_ *core.RegionInfo) uint64 {
+	return 0 // transfer leader never change the conf version
+}
+
+func (tlc TransferLeaderToCandidates) String() string {
+	return fmt.Sprintf("transfer leader from store %v to one of stores %v", tlc.FromStore, tlc.ToStores)
+}
+
+// IsFinish checks if current step is finished.
+func (tlc TransferLeaderToCandidates) IsFinish(region *core.RegionInfo) bool {
+	leaderStoreID := region.GetLeader().GetStoreId()
+	for _, storeID := range tlc.ToStores {
+		if leaderStoreID == storeID {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckInProgress checks if the step is in the progress of advancing.
+// It returns nil if at least one of the candidates is able to become the leader.
+func (tlc TransferLeaderToCandidates) CheckInProgress(ci *core.BasicCluster, config config.SharedConfigProvider, region *core.RegionInfo) error {
+	if len(tlc.ToStores) == 0 {
+		return errors.New("no candidate store")
+	}
+	errList := make([]error, 0, len(tlc.ToStores))
+	for _, storeID := range tlc.ToStores {
+		peer := region.GetStorePeer(storeID)
+		if peer == nil {
+			errList = append(errList, errors.New("peer does not existed"))
+			continue
+		}
+		if core.IsLearner(peer) {
+			errList = append(errList, errors.New("peer already is a learner"))
+			continue
+		}
+		if err := validateStore(ci, config, storeID); err != nil {
+			errList = append(errList, err)
+			continue
+		}
+		return nil
+	}
+	return errors.Errorf("%v", errList)
+}
+
+// Influence calculates the store difference that current step makes.
+// Since the target store is unknown until the step is executed, the leader size
+// is shared by all candidates, and the leader count is attributed to the first candidate.
+func (tlc TransferLeaderToCandidates) Influence(opInfluence OpInfluence, region *core.RegionInfo) {
+	if len(tlc.ToStores) == 0 {
+		return
+	}
+	from := opInfluence.GetStoreInfluence(tlc.FromStore)
+	from.LeaderSize -= region.GetApproximateSize()
+	from.LeaderCount--
+
+	sharedSize := region.GetApproximateSize() / int64(len(tlc.ToStores))
+	for _, storeID := range tlc.ToStores {
+		opInfluence.GetStoreInfluence(storeID).LeaderSize += sharedSize
+	}
+	opInfluence.GetStoreInfluence(tlc.ToStores[0]).LeaderCount++
+}
+
+// Timeout returns duration that current step may take.
+func (tlc TransferLeaderToCandidates) Timeout(regionSize int64) time.Duration {
+	return fastStepWaitDuration(regionSize)
+}
+
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (tlc TransferLeaderToCandidates) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (tlc TransferLeaderToCandidates) Equal(other OpStep) bool {
+	o, ok := other.(TransferLeaderToCandidates)
+	return ok && tlc.FromStore == o.FromStore && slices.Equal(tlc.ToStores, o.ToStores)
+}
+
+// GetCmd returns the schedule command for heartbeat response.
+func (tlc TransferLeaderToCandidates) GetCmd(region *core.RegionInfo, _ bool) *hbstream.Operation {
+	if len(tlc.ToStores) == 0 {
+		return nil
+	}
+	peers := make([]*metapb.Peer, 0, len(tlc.ToStores))
+	for _, storeID := range tlc.ToStores {
+		peers = append(peers, region.GetStorePeer(storeID))
+	}
+	return &hbstream.Operation{
+		TransferLeader: &pdpb.TransferLeader{
+			Peer:  peers[0],
+			Peers: peers,
+		},
+	}
+}
+
 // AddPeer is an OpStep that adds a region peer.
 type AddPeer struct {
 	ToStore, PeerID uint64
@@ -210,6 +333,20 @@ func (ap AddPeer) Timeout(regionSize int64) time.Duration {
 	return slowStepWaitDuration(regionSize)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (ap AddPeer) ApproximateCost(regionSize int64) int64 {
+	if ap.IsLightWeight || ap.IsWitness {
+		return metadataStepCost
+	}
+	return snapshotStepCost(regionSize)
+}
+
+// Equal returns true if the given step is the same as this one.
+func (ap AddPeer) Equal(other OpStep) bool {
+	o, ok := other.(AddPeer)
+	return ok && ap == o
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (ap AddPeer) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	peer := region.GetStorePeer(ap.ToStore)
@@ -220,6 +357,44 @@ func (ap AddPeer) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstrea
 	return createResponse(addNode(ap.PeerID, ap.ToStore, ap.IsWitness), useConfChangeV2)
 }
 
+// SnapshotPriority indicates the priority of the snapshot generated by adding a peer.
+type SnapshotPriority int32
+
+const (
+	// SnapshotPriorityNormal is the default priority.
+	SnapshotPriorityNormal SnapshotPriority = iota
+	// SnapshotPriorityHigh means the snapshot should be scheduled ahead of the normal ones.
+	SnapshotPriorityHigh
+)
+
+func (p SnapshotPriority) String() string {
+	switch p {
+	case SnapshotPriorityNormal:
+		return "normal"
+	case SnapshotPriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("unknown(%d)", int32(p))
+}
+
+// AddPeerWithPriority is an OpStep that adds a region peer with the given snapshot priority.
+// It behaves the same as AddPeer except that the priority is recorded in the step. The
+// heartbeat response has no field for the priority yet, so the command is the same as AddPeer.
+type AddPeerWithPriority struct {
+	AddPeer
+	Priority SnapshotPriority
+}
+
+func (ap AddPeerWithPriority) String() string {
+	return fmt.Sprintf("%s with %s snapshot priority", ap.AddPeer.String(), ap.Priority)
+}
+
+// Equal returns true if the given step is the same as this one.
+func (ap AddPeerWithPriority) Equal(other OpStep) bool {
+	o, ok := other.(AddPeerWithPriority)
+	return ok && ap == o
+}
+
 // BecomeWitness is an OpStep that makes a peer become a witness.
 type BecomeWitness struct {
 	PeerID, StoreID uint64
@@ -274,6 +449,17 @@ func (bw BecomeWitness) Timeout(regionSize int64) time.Duration {
 	return fastStepWaitDuration(regionSize)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (bw BecomeWitness) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (bw BecomeWitness) Equal(other OpStep) bool {
+	o, ok := other.(BecomeWitness)
+	return ok && bw == o
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (bw BecomeWitness) GetCmd(_ *core.RegionInfo, _ bool) *hbstream.Operation {
 	return switchWitness(bw.PeerID, true)
@@ -342,6 +528,17 @@ func (bn BecomeNonWitness) Timeout(regionSize int64) time.Duration {
 	return slowStepWaitDuration(regionSize)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (bn BecomeNonWitness) ApproximateCost(regionSize int64) int64 {
+	return snapshotStepCost(regionSize)
+}
+
+// Equal returns true if the given step is the same as this one.
+func (bn BecomeNonWitness) Equal(other OpStep) bool {
+	o, ok := other.(BecomeNonWitness)
+	return ok && bn == o
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (bn BecomeNonWitness) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	return switchWitness(bn.PeerID, false)
@@ -426,6 +623,24 @@ func (bsw BatchSwitchWitness) Timeout(regionSize int64) time.Duration {
 	return slowStepWaitDuration(regionSize) * time.Duration(count)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (bsw BatchSwitchWitness) ApproximateCost(regionSize int64) int64 {
+	var cost int64
+	for _, w := range bsw.ToWitnesses {
+		cost += w.ApproximateCost(regionSize)
+	}
+	for _, nw := range bsw.ToNonWitnesses {
+		cost += nw.ApproximateCost(regionSize)
+	}
+	return cost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (bsw BatchSwitchWitness) Equal(other OpStep) bool {
+	o, ok := other.(BatchSwitchWitness)
+	return ok && slices.Equal(bsw.ToWitnesses, o.ToWitnesses) && slices.Equal(bsw.ToNonWitnesses, o.ToNonWitnesses)
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (bsw BatchSwitchWitness) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	switches := make([]*pdpb.SwitchWitness, 0, len(bsw.ToWitnesses)+len(bsw.ToNonWitnesses))
@@ -522,6 +737,20 @@ func (al AddLearner) Timeout(regionSize int64) time.Duration {
 	return slowStepWaitDuration(regionSize)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (al AddLearner) ApproximateCost(regionSize int64) int64 {
+	if al.IsLightWeight || al.IsWitness {
+		return metadataStepCost
+	}
+	return snapshotStepCost(regionSize)
+}
+
+// Equal returns true if the given step is the same as this one.
+func (al AddLearner) Equal(other OpStep) bool {
+	o, ok := other.(AddLearner)
+	return ok && al == o
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (al AddLearner) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	if region.GetStorePeer(al.ToStore) != nil {
@@ -581,11 +810,84 @@ func (pl PromoteLearner) Timeout(regionSize int64) time.Duration {
 	return fastStepWaitDuration(regionSize)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (pl PromoteLearner) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (pl PromoteLearner) Equal(other OpStep) bool {
+	o, ok := other.(PromoteLearner)
+	return ok && pl == o
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (pl PromoteLearner) GetCmd(_ *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	return createResponse(addNode(pl.PeerID, pl.ToStore, pl.IsWitness), useConfChangeV2)
 }
 
+// DemoteVoterToLearner is an OpStep that demotes a voter to a learner directly
+// without entering the joint state.
+type DemoteVoterToLearner struct {
+	StoreID uint64
+}
+
+// ConfVerChanged returns the delta value for version increased by this step.
+func (dl DemoteVoterToLearner) ConfVerChanged(region *core.RegionInfo) uint64 {
+	peer := region.GetStorePeer(dl.StoreID)
+	// the demoted peer may be removed later.
+	return typeutil.BoolToUint64(peer == nil || peer.GetRole() == metapb.PeerRole_Learner)
+}
+
+func (dl DemoteVoterToLearner) String() string {
+	return fmt.Sprintf("demote voter on store %v to learner", dl.StoreID)
+}
+
+// IsFinish checks if current step is finished.
+func (dl DemoteVoterToLearner) IsFinish(region *core.RegionInfo) bool {
+	return region.GetStoreLearner(dl.StoreID) != nil
+}
+
+// CheckInProgress checks if the step is in the progress of advancing.
+func (dl DemoteVoterToLearner) CheckInProgress(_ *core.BasicCluster, _ config.SharedConfigProvider, region *core.RegionInfo) error {
+	if region.GetStorePeer(dl.StoreID) == nil {
+		return errors.New("peer does not exist")
+	}
+	if dl.StoreID == region.GetLeader().GetStoreId() {
+		return errors.New("cannot demote leader peer")
+	}
+	return nil
+}
+
+// Influence calculates the store difference that current step makes.
+// The learner still holds the replica, so the region count and size of the store are not changed.
+func (dl DemoteVoterToLearner) Influence(_ OpInfluence, _ *core.RegionInfo) {}
+
+// Timeout returns duration that current step may take.
+func (dl DemoteVoterToLearner) Timeout(regionSize int64) time.Duration {
+	return fastStepWaitDuration(regionSize)
+}
+
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (dl DemoteVoterToLearner) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (dl DemoteVoterToLearner) Equal(other OpStep) bool {
+	o, ok := other.(DemoteVoterToLearner)
+	return ok && dl == o
+}
+
+// GetCmd returns the schedule command for heartbeat response.
+func (dl DemoteVoterToLearner) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
+	peer := region.GetStoreVoter(dl.StoreID)
+	if peer == nil {
+		return nil
+	}
+	return createResponse(addLearnerNode(peer.GetId(), dl.StoreID, peer.GetIsWitness()), useConfChangeV2)
+}
+
 // RemovePeer is an OpStep that removes a region peer.
 type RemovePeer struct {
 	FromStore, PeerID uint64
@@ -652,6 +954,17 @@ func (rp RemovePeer) Timeout(regionSize int64) time.Duration {
 	return fastStepWaitDuration(regionSize)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (rp RemovePeer) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (rp RemovePeer) Equal(other OpStep) bool {
+	o, ok := other.(RemovePeer)
+	return ok && rp == o
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (rp RemovePeer) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	return createResponse(&pdpb.ChangePeer{
@@ -660,6 +973,152 @@ func (rp RemovePeer) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbst
 	}, useConfChangeV2)
 }
 
+// RemoveLearners is an OpStep that removes the learners on the given stores.
+// The learners are removed one by one, and the step is finished when all of them are removed.
+type RemoveLearners struct {
+	StoreIDs []uint64
+}
+
+// ConfVerChanged returns the delta value for version increased by this step.
+func (rl RemoveLearners) ConfVerChanged(region *core.RegionInfo) uint64 {
+	var count uint64
+	for _, storeID := range rl.StoreIDs {
+		if region.GetStorePeer(storeID) == nil {
+			count++
+		}
+	}
+	return count
+}
+
+func (rl RemoveLearners) String() string {
+	return fmt.Sprintf("remove learners on stores %v", rl.StoreIDs)
+}
+
+// IsFinish checks if current step is finished.
+func (rl RemoveLearners) IsFinish(region *core.RegionInfo) bool {
+	for _, storeID := range rl.StoreIDs {
+		if region.GetStorePeer(storeID) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckInProgress checks if the step is in the progress of advancing.
+func (rl RemoveLearners) CheckInProgress(_ *core.BasicCluster, _ config.SharedConfigProvider, region *core.RegionInfo) error {
+	for _, storeID := range rl.StoreIDs {
+		if peer := region.GetStorePeer(storeID); peer != nil && peer.GetRole() != metapb.PeerRole_Learner {
+			return errors.Errorf("peer on store %d is not learner", storeID)
+		}
+	}
+	return nil
+}
+
+// Influence calculates the store difference that current step makes.
+func (rl RemoveLearners) Influence(opInfluence OpInfluence, region *core.RegionInfo) {
+	for _, storeID := range rl.StoreIDs {
+		peer := region.GetStorePeer(storeID)
+		if peer == nil {
+			continue
+		}
+		from := opInfluence.GetStoreInfluence(storeID)
+		regionSize := region.GetStorePeerApproximateSize(storeID)
+		from.RegionSize -= regionSize
+		from.RegionCount--
+		if peer.IsWitness {
+			from.WitnessCount--
+			continue
+		}
+		from.AdjustStepCost(storelimit.RemovePeer, regionSize)
+	}
+}
+
+// Timeout returns duration that current step may take.
+func (rl RemoveLearners) Timeout(regionSize int64) time.Duration {
+	return fastStepWaitDuration(regionSize) * time.Duration(len(rl.StoreIDs))
+}
+
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (rl RemoveLearners) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (rl RemoveLearners) Equal(other OpStep) bool {
+	o, ok := other.(RemoveLearners)
+	return ok && slices.Equal(rl.StoreIDs, o.StoreIDs)
+}
+
+// GetCmd returns the schedule command for heartbeat response.
+// Only one learner is removed in a command to avoid entering the joint state.
+func (rl RemoveLearners) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
+	for _, storeID := range rl.StoreIDs {
+		if peer := region.GetStorePeer(storeID); peer != nil {
+			return createResponse(&pdpb.ChangePeer{
+				ChangeType: eraftpb.ConfChangeType_RemoveNode,
+				Peer:       peer,
+			}, useConfChangeV2)
+		}
+	}
+	return nil
+}
+
+// WaitUntilNotHot is an OpStep that waits for the region to stop being hot, so that the
+// following steps don't worsen the latency of a hot region. It is finished once both the read
+// and the write bytes rate of the region are below MaxBytesRate, or MaxWait elapses since the
+// step started, which is checked by the operator since the step doesn't know when it started.
+type WaitUntilNotHot struct {
+	MaxWait      time.Duration
+	MaxBytesRate float64
+}
+
+// ConfVerChanged returns the delta value for version increased by this step.
+func (wh WaitUntilNotHot) ConfVerChanged(_ *core.RegionInfo) uint64 {
+	return 0 // waiting never changes the conf version
+}
+
+func (wh WaitUntilNotHot) String() string {
+	return fmt.Sprintf("wait until bytes rate is below %v for at most %v", wh.MaxBytesRate, wh.MaxWait)
+}
+
+// IsFinish checks if current step is finished.
+func (wh WaitUntilNotHot) IsFinish(region *core.RegionInfo) bool {
+	writeRate, _ := region.GetWriteRate()
+	readRate, _ := region.GetReadRate()
+	return writeRate < wh.MaxBytesRate && readRate < wh.MaxBytesRate
+}
+
+// CheckInProgress checks if the step is in the progress of advancing.
+func (wh WaitUntilNotHot) CheckInProgress(_ *core.BasicCluster, _ config.SharedConfigProvider, _ *core.RegionInfo) error {
+	return nil
+}
+
+// Influence calculates the store difference that current step makes.
+func (wh WaitUntilNotHot) Influence(_ OpInfluence, _ *core.RegionInfo) {}
+
+// Timeout returns duration that current step may take.
+// It is longer than MaxWait so that the operator can't time out before the waiting ends.
+func (wh WaitUntilNotHot) Timeout(regionSize int64) time.Duration {
+	return wh.MaxWait + fastStepWaitDuration(regionSize)
+}
+
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (wh WaitUntilNotHot) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (wh WaitUntilNotHot) Equal(other OpStep) bool {
+	o, ok := other.(WaitUntilNotHot)
+	return ok && wh == o
+}
+
+// GetCmd returns the schedule command for heartbeat response.
+// There is nothing to do for TiKV.
+func (wh WaitUntilNotHot) GetCmd(_ *core.RegionInfo, _ bool) *hbstream.Operation {
+	return nil
+}
+
 // MergeRegion is an OpStep that merge two regions.
 type MergeRegion struct {
 	FromRegion *metapb.Region
@@ -714,6 +1173,18 @@ func (mr MergeRegion) Timeout(regionSize int64) time.Duration {
 	return fastStepWaitDuration(regionSize) * 10
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (mr MergeRegion) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (mr MergeRegion) Equal(other OpStep) bool {
+	o, ok := other.(MergeRegion)
+	return ok && mr.IsPassive == o.IsPassive &&
+		proto.Equal(mr.FromRegion, o.FromRegion) && proto.Equal(mr.ToRegion, o.ToRegion)
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (mr MergeRegion) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	if mr.IsPassive {
@@ -768,6 +1239,18 @@ func (sr SplitRegion) Timeout(regionSize int64) time.Duration {
 	return fastStepWaitDuration(regionSize)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (sr SplitRegion) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (sr SplitRegion) Equal(other OpStep) bool {
+	o, ok := other.(SplitRegion)
+	return ok && bytes.Equal(sr.StartKey, o.StartKey) && bytes.Equal(sr.EndKey, o.EndKey) &&
+		sr.Policy == o.Policy && slices.EqualFunc(sr.SplitKeys, o.SplitKeys, bytes.Equal)
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (sr SplitRegion) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	return &hbstream.Operation{
@@ -940,6 +1423,17 @@ func (cpe ChangePeerV2Enter) Timeout(regionSize int64) time.Duration {
 	return fastStepWaitDuration(regionSize) * time.Duration(count)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (cpe ChangePeerV2Enter) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (cpe ChangePeerV2Enter) Equal(other OpStep) bool {
+	o, ok := other.(ChangePeerV2Enter)
+	return ok && slices.Equal(cpe.PromoteLearners, o.PromoteLearners) && slices.Equal(cpe.DemoteVoters, o.DemoteVoters)
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (cpe ChangePeerV2Enter) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	if !useConfChangeV2 {
@@ -1080,6 +1574,17 @@ func (cpl ChangePeerV2Leave) Timeout(regionSize int64) time.Duration {
 	return fastStepWaitDuration(regionSize) * time.Duration(count)
 }
 
+// ApproximateCost returns the approximate IO cost that current step may take.
+func (cpl ChangePeerV2Leave) ApproximateCost(_ int64) int64 {
+	return metadataStepCost
+}
+
+// Equal returns true if the given step is the same as this one.
+func (cpl ChangePeerV2Leave) Equal(other OpStep) bool {
+	o, ok := other.(ChangePeerV2Leave)
+	return ok && slices.Equal(cpl.PromoteLearners, o.PromoteLearners) && slices.Equal(cpl.DemoteVoters, o.DemoteVoters)
+}
+
 // GetCmd returns the schedule command for heartbeat response.
 func (cpl ChangePeerV2Leave) GetCmd(region *core.RegionInfo, useConfChangeV2 bool) *hbstream.Operation {
 	if !useConfChangeV2 {
@@ -1111,6 +1616,13 @@ func slowStepWaitDuration(regionSize int64) time.Duration {
 	return wait
 }
 
+func snapshotStepCost(regionSize int64) int64 {
+	if regionSize < metadataStepCost {
+		return metadataStepCost
+	}
+	return regionSize
+}
+
 func fastStepWaitDuration(regionSize int64) time.Duration {
 	seconds := int64(DefaultFastExecutorRate * float64(regionSize))
 	wait := time.Duration(seconds) * time.Second
@@ -0,0 +1,90 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+// StepBuilder is used to create operators from the given steps with named arguments. Usage:
+//
+//	op, err := NewStepBuilder(desc, brief).
+//	            Region(regionID, epoch).
+//	            Kind(OpLeader).
+//	            Size(size).
+//	            AddStep(TransferLeader{FromStore: 1, ToStore: 2}).
+//	            Build()
+//
+// Unlike the Builder, it doesn't generate the steps from the region and the cluster, the steps
+// are kept as they are added. It's the same as NewOperator except that the steps are validated.
+type StepBuilder struct {
+	desc, brief     string
+	regionID        uint64
+	regionEpoch     *metapb.RegionEpoch
+	kind            OpKind
+	approximateSize int64
+	steps           []OpStep
+}
+
+// NewStepBuilder creates a StepBuilder.
+func NewStepBuilder(desc, brief string) *StepBuilder {
+	return &StepBuilder{desc: desc, brief: brief}
+}
+
+// Region sets the region ID and the region epoch of the operator.
+func (b *StepBuilder) Region(regionID uint64, epoch *metapb.RegionEpoch) *StepBuilder {
+	b.regionID, b.regionEpoch = regionID, epoch
+	return b
+}
+
+// Kind sets the kind of the operator.
+func (b *StepBuilder) Kind(kind OpKind) *StepBuilder {
+	b.kind = kind
+	return b
+}
+
+// Size sets the approximate size of the region.
+func (b *StepBuilder) Size(size int64) *StepBuilder {
+	b.approximateSize = size
+	return b
+}
+
+// AddStep appends a step to the operator.
+func (b *StepBuilder) AddStep(step OpStep) *StepBuilder {
+	b.steps = append(b.steps, step)
+	return b
+}
+
+// Build creates the operator. It returns an error if the region is not set, there is no step
+// or the steps don't form a coherent sequence.
+func (b *StepBuilder) Build() (*Operator, error) {
+	if b.regionID == 0 {
+		return nil, errors.New("region is not set")
+	}
+	if len(b.steps) == 0 {
+		return nil, errors.New("no step")
+	}
+	for i, step := range b.steps {
+		if step == nil {
+			return nil, errors.Errorf("step %d is nil", i)
+		}
+	}
+	op := NewOperator(b.desc, b.brief, b.regionID, b.regionEpoch, b.kind, b.approximateSize, b.steps...)
+	if err := op.Validate(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
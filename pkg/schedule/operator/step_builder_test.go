@@ -0,0 +1,65 @@
+// Copyright 2024 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package operator
+
+import (
+	"testing"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/pd/pkg/core/constant"
+)
+
+func TestStepBuilder(t *testing.T) {
+	re := require.New(t)
+	epoch := &metapb.RegionEpoch{ConfVer: 1, Version: 1}
+	steps := []OpStep{
+		AddLearner{ToStore: 3, PeerID: 3},
+		PromoteLearner{ToStore: 3, PeerID: 3},
+		RemovePeer{FromStore: 2},
+	}
+	op, err := NewStepBuilder("test", "test brief").
+		Region(1, epoch).
+		Kind(OpRegion | OpAdmin).
+		Size(10).
+		AddStep(steps[0]).
+		AddStep(steps[1]).
+		AddStep(steps[2]).
+		Build()
+	re.NoError(err)
+	expected := NewOperator("test", "test brief", 1, epoch, OpRegion|OpAdmin, 10, steps...)
+	re.Equal(expected.Desc(), op.Desc())
+	re.Equal(expected.Brief(), op.Brief())
+	re.Equal(expected.RegionID(), op.RegionID())
+	re.Equal(expected.RegionEpoch(), op.RegionEpoch())
+	re.Equal(expected.Kind(), op.Kind())
+	re.Equal(expected.ApproximateSize, op.ApproximateSize)
+	re.Equal(expected.Timeout(), op.Timeout())
+	re.Equal(constant.Urgent, op.GetPriorityLevel())
+	re.Equal(steps, op.steps)
+
+	_, err = NewStepBuilder("test", "test").Kind(OpLeader).AddStep(TransferLeader{FromStore: 1, ToStore: 2}).Build()
+	re.Error(err)
+	_, err = NewStepBuilder("test", "test").Region(1, epoch).Kind(OpLeader).Build()
+	re.Error(err)
+	_, err = NewStepBuilder("test", "test").Region(1, epoch).Kind(OpLeader).AddStep(nil).Build()
+	re.Error(err)
+	// the step is invalid since the peer has been removed.
+	_, err = NewStepBuilder("test", "test").Region(1, epoch).Kind(OpRegion).
+		AddStep(RemovePeer{FromStore: 2}).
+		AddStep(PromoteLearner{ToStore: 2, PeerID: 2}).
+		Build()
+	re.Error(err)
+}
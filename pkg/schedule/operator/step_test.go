@@ -17,8 +17,10 @@ package operator
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/tikv/pd/pkg/core"
@@ -106,6 +108,59 @@ func (suite *operatorStepTestSuite) TestTransferLeader() {
 	suite.check(re, step, "transfer leader from store 1 to store 9", testCases)
 }
 
+func (suite *operatorStepTestSuite) TestTransferLeaderToCandidates() {
+	re := suite.Require()
+	step := TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2, 9}}
+	testCases := []testCase{
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+				{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+				{Id: 9, StoreId: 9, Role: metapb.PeerRole_Voter},
+			},
+			0,
+			false,
+			re.NoError,
+		},
+		{
+			[]*metapb.Peer{
+				{Id: 9, StoreId: 9, Role: metapb.PeerRole_Voter},
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+				{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+			},
+			0,
+			true,
+			re.NoError,
+		},
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+				{Id: 2, StoreId: 2, Role: metapb.PeerRole_Learner},
+				{Id: 9, StoreId: 9, Role: metapb.PeerRole_Voter},
+			},
+			0,
+			false,
+			re.Error,
+		},
+	}
+	suite.check(re, step, "transfer leader from store 1 to one of stores [2 9]", testCases)
+
+	peers := []*metapb.Peer{
+		{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+		{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+		{Id: 3, StoreId: 3, Role: metapb.PeerRole_Voter},
+	}
+	region := core.NewRegionInfo(&metapb.Region{Id: 1, Peers: peers}, peers[0], core.SetApproximateSize(60))
+	influence := *NewOpInfluence()
+	TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2, 3}}.Influence(influence, region)
+	re.Equal(int64(-60), influence.GetStoreInfluence(1).LeaderSize)
+	re.Equal(int64(-1), influence.GetStoreInfluence(1).LeaderCount)
+	re.Equal(int64(30), influence.GetStoreInfluence(2).LeaderSize)
+	re.Equal(int64(1), influence.GetStoreInfluence(2).LeaderCount)
+	re.Equal(int64(30), influence.GetStoreInfluence(3).LeaderSize)
+	re.Zero(influence.GetStoreInfluence(3).LeaderCount)
+}
+
 func (suite *operatorStepTestSuite) TestAddPeer() {
 	re := suite.Require()
 	step := AddPeer{ToStore: 2, PeerID: 2}
@@ -144,6 +199,108 @@ func (suite *operatorStepTestSuite) TestAddPeer() {
 	suite.check(re, step, "add peer 9 on store 9", testCases)
 }
 
+func (suite *operatorStepTestSuite) TestAddPeerWithPriority() {
+	re := suite.Require()
+	step := AddPeerWithPriority{AddPeer: AddPeer{ToStore: 2, PeerID: 2}, Priority: SnapshotPriorityHigh}
+	testCases := []testCase{
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+			},
+			0,
+			false,
+			re.NoError,
+		},
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+				{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+			},
+			1,
+			true,
+			re.NoError,
+		},
+	}
+	suite.check(re, step, "add peer 2 on store 2 with high snapshot priority", testCases)
+
+	region := core.NewRegionInfo(&metapb.Region{Id: 1, Peers: []*metapb.Peer{{Id: 1, StoreId: 1}}}, nil)
+	for _, useConfChangeV2 := range []bool{false, true} {
+		re.Equal(AddPeer{ToStore: 2, PeerID: 2}.GetCmd(region, useConfChangeV2), step.GetCmd(region, useConfChangeV2))
+	}
+}
+
+func (suite *operatorStepTestSuite) TestEqual() {
+	re := suite.Require()
+	testCases := []struct {
+		step, same, diff OpStep
+	}{
+		{
+			TransferLeader{FromStore: 1, ToStore: 2, ToStores: []uint64{2, 3}},
+			TransferLeader{FromStore: 1, ToStore: 2, ToStores: []uint64{2, 3}},
+			TransferLeader{FromStore: 1, ToStore: 2, ToStores: []uint64{3}},
+		},
+		{
+			TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2, 3}},
+			TransferLeaderToCandidates{FromStore: 1, ToStores: []uint64{2, 3}},
+			TransferLeader{FromStore: 1, ToStores: []uint64{2, 3}},
+		},
+		{
+			AddPeer{ToStore: 2, PeerID: 2},
+			AddPeer{ToStore: 2, PeerID: 2},
+			AddPeerWithPriority{AddPeer: AddPeer{ToStore: 2, PeerID: 2}},
+		},
+		{
+			AddPeerWithPriority{AddPeer: AddPeer{ToStore: 2, PeerID: 2}, Priority: SnapshotPriorityHigh},
+			AddPeerWithPriority{AddPeer: AddPeer{ToStore: 2, PeerID: 2}, Priority: SnapshotPriorityHigh},
+			AddPeerWithPriority{AddPeer: AddPeer{ToStore: 2, PeerID: 2}},
+		},
+		{
+			AddLearner{ToStore: 2, PeerID: 2, SendStore: 1},
+			AddLearner{ToStore: 2, PeerID: 2, SendStore: 1},
+			AddLearner{ToStore: 2, PeerID: 2, SendStore: 3},
+		},
+		{
+			DemoteVoterToLearner{StoreID: 2},
+			DemoteVoterToLearner{StoreID: 2},
+			DemoteVoterToLearner{StoreID: 3},
+		},
+		{
+			RemovePeer{FromStore: 2, PeerID: 2},
+			RemovePeer{FromStore: 2, PeerID: 2},
+			RemovePeer{FromStore: 2, PeerID: 2, IsDownStore: true},
+		},
+		{
+			RemoveLearners{StoreIDs: []uint64{2, 3}},
+			RemoveLearners{StoreIDs: []uint64{2, 3}},
+			RemoveLearners{StoreIDs: []uint64{2}},
+		},
+		{
+			MergeRegion{FromRegion: &metapb.Region{Id: 1}, ToRegion: &metapb.Region{Id: 2}},
+			MergeRegion{FromRegion: &metapb.Region{Id: 1}, ToRegion: &metapb.Region{Id: 2}},
+			MergeRegion{FromRegion: &metapb.Region{Id: 1}, ToRegion: &metapb.Region{Id: 3}},
+		},
+		{
+			SplitRegion{Policy: pdpb.CheckPolicy_USEKEY, SplitKeys: [][]byte{[]byte("a")}},
+			SplitRegion{Policy: pdpb.CheckPolicy_USEKEY, SplitKeys: [][]byte{[]byte("a")}},
+			SplitRegion{Policy: pdpb.CheckPolicy_USEKEY, SplitKeys: [][]byte{[]byte("b")}},
+		},
+		{
+			BatchSwitchWitness{ToWitnesses: []BecomeWitness{{PeerID: 2, StoreID: 2}}},
+			BatchSwitchWitness{ToWitnesses: []BecomeWitness{{PeerID: 2, StoreID: 2}}},
+			BatchSwitchWitness{ToNonWitnesses: []BecomeNonWitness{{PeerID: 2, StoreID: 2}}},
+		},
+		{
+			ChangePeerV2Enter{PromoteLearners: []PromoteLearner{{ToStore: 2, PeerID: 2}}},
+			ChangePeerV2Enter{PromoteLearners: []PromoteLearner{{ToStore: 2, PeerID: 2}}},
+			ChangePeerV2Leave{PromoteLearners: []PromoteLearner{{ToStore: 2, PeerID: 2}}},
+		},
+	}
+	for _, testCase := range testCases {
+		re.True(testCase.step.Equal(testCase.same), testCase.step.String())
+		re.False(testCase.step.Equal(testCase.diff), testCase.step.String())
+	}
+}
+
 func (suite *operatorStepTestSuite) TestAddLearner() {
 	re := suite.Require()
 	step := AddLearner{ToStore: 2, PeerID: 2}
@@ -182,6 +339,149 @@ func (suite *operatorStepTestSuite) TestAddLearner() {
 	suite.check(re, step, "add learner peer 9 on store 9", testCases)
 }
 
+func (suite *operatorStepTestSuite) TestDemoteVoterToLearner() {
+	re := suite.Require()
+	step := DemoteVoterToLearner{StoreID: 2}
+	testCases := []testCase{
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+				{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+			},
+			0,
+			false,
+			re.NoError,
+		},
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+				{Id: 2, StoreId: 2, Role: metapb.PeerRole_Learner},
+			},
+			1,
+			true,
+			re.NoError,
+		},
+		{ // the demoted peer has been removed.
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+			},
+			1,
+			false,
+			re.Error,
+		},
+		{ // cannot demote the leader.
+			[]*metapb.Peer{
+				{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+			},
+			0,
+			false,
+			re.Error,
+		},
+	}
+	suite.check(re, step, "demote voter on store 2 to learner", testCases)
+
+	peers := []*metapb.Peer{
+		{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+		{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+	}
+	region := core.NewRegionInfo(&metapb.Region{Id: 1, Peers: peers}, peers[0], core.SetApproximateSize(10))
+	influence := *NewOpInfluence()
+	step.Influence(influence, region)
+	re.Empty(influence.StoresInfluence)
+	cmd := step.GetCmd(region, false)
+	re.Equal(addLearnerNode(2, 2, false), cmd.ChangePeer)
+}
+
+func (suite *operatorStepTestSuite) TestRemoveLearners() {
+	re := suite.Require()
+	step := RemoveLearners{StoreIDs: []uint64{2, 3}}
+	testCases := []testCase{
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+				{Id: 2, StoreId: 2, Role: metapb.PeerRole_Learner},
+				{Id: 3, StoreId: 3, Role: metapb.PeerRole_Learner},
+			},
+			0,
+			false,
+			re.NoError,
+		},
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+				{Id: 3, StoreId: 3, Role: metapb.PeerRole_Learner},
+			},
+			1,
+			false,
+			re.NoError,
+		},
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+			},
+			2,
+			true,
+			re.NoError,
+		},
+		{
+			[]*metapb.Peer{
+				{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+				{Id: 2, StoreId: 2, Role: metapb.PeerRole_Voter},
+			},
+			1,
+			false,
+			re.Error,
+		},
+	}
+	suite.check(re, step, "remove learners on stores [2 3]", testCases)
+
+	peers := []*metapb.Peer{
+		{Id: 1, StoreId: 1, Role: metapb.PeerRole_Voter},
+		{Id: 2, StoreId: 2, Role: metapb.PeerRole_Learner},
+		{Id: 3, StoreId: 3, Role: metapb.PeerRole_Learner},
+	}
+	region := core.NewRegionInfo(&metapb.Region{Id: 1, Peers: peers}, peers[0], core.SetApproximateSize(10))
+	influence := *NewOpInfluence()
+	step.Influence(influence, region)
+	for _, storeID := range step.StoreIDs {
+		re.Equal(int64(-1), influence.GetStoreInfluence(storeID).RegionCount)
+		re.Equal(int64(-10), influence.GetStoreInfluence(storeID).RegionSize)
+	}
+	re.Zero(influence.GetStoreInfluence(1).RegionCount)
+
+	// the learners are removed one by one.
+	re.Equal(peers[1], step.GetCmd(region, false).ChangePeer.GetPeer())
+	region = region.Clone(core.WithRemoveStorePeer(2))
+	re.Equal(peers[2], step.GetCmd(region, false).ChangePeer.GetPeer())
+	region = region.Clone(core.WithRemoveStorePeer(3))
+	re.Nil(step.GetCmd(region, false))
+}
+
+func (suite *operatorStepTestSuite) TestWaitUntilNotHot() {
+	re := suite.Require()
+	step := WaitUntilNotHot{MaxWait: time.Minute, MaxBytesRate: 100}
+	peers := []*metapb.Peer{{Id: 1, StoreId: 1}, {Id: 2, StoreId: 2}}
+	region := core.NewRegionInfo(&metapb.Region{Id: 1, Peers: peers}, peers[0], core.SetReportInterval(0, 10))
+	re.True(step.IsFinish(region))
+	re.Zero(step.ConfVerChanged(region))
+	re.NoError(step.CheckInProgress(suite.cluster.GetBasicCluster(), suite.cluster.GetSharedConfig(), region))
+	re.Equal("wait until bytes rate is below 100 for at most 1m0s", step.String())
+	re.Nil(step.GetCmd(region, false))
+	re.Greater(step.Timeout(10), step.MaxWait)
+	re.Equal(metadataStepCost, step.ApproximateCost(10))
+	re.True(step.Equal(WaitUntilNotHot{MaxWait: time.Minute, MaxBytesRate: 100}))
+	re.False(step.Equal(WaitUntilNotHot{MaxWait: time.Second, MaxBytesRate: 100}))
+
+	re.False(step.IsFinish(region.Clone(core.SetWrittenBytes(1000))))
+	re.False(step.IsFinish(region.Clone(core.SetReadBytes(1000))))
+	re.True(step.IsFinish(region.Clone(core.SetReadBytes(999), core.SetWrittenBytes(999))))
+
+	influence := *NewOpInfluence()
+	step.Influence(influence, region)
+	re.Empty(influence.StoresInfluence)
+}
+
 func (suite *operatorStepTestSuite) TestChangePeerV2Enter() {
 	re := suite.Require()
 	cpe := ChangePeerV2Enter{
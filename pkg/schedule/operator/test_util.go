@@ -36,6 +36,15 @@ func ApplyOperatorStep(region *core.RegionInfo, op *Operator) *core.RegionInfo {
 				StoreId: s.ToStore,
 			}
 			region = region.Clone(core.WithAddPeer(peer))
+		case AddPeerWithPriority:
+			if region.GetStorePeer(s.ToStore) != nil {
+				panic("Add peer that exists")
+			}
+			peer := &metapb.Peer{
+				Id:      s.PeerID,
+				StoreId: s.ToStore,
+			}
+			region = region.Clone(core.WithAddPeer(peer))
 		case RemovePeer:
 			if region.GetStorePeer(s.FromStore) == nil {
 				panic("Remove peer that doesn't exist")
@@ -44,6 +53,13 @@ func ApplyOperatorStep(region *core.RegionInfo, op *Operator) *core.RegionInfo {
 				panic("Cannot remove the leader peer")
 			}
 			region = region.Clone(core.WithRemoveStorePeer(s.FromStore))
+		case RemoveLearners:
+			for _, storeID := range s.StoreIDs {
+				if region.GetStoreLearner(storeID) == nil {
+					panic("Remove learner that doesn't exist")
+				}
+				region = region.Clone(core.WithRemoveStorePeer(storeID))
+			}
 		case AddLearner:
 			if region.GetStorePeer(s.ToStore) != nil {
 				panic("Add learner that exists")
@@ -63,6 +79,12 @@ func ApplyOperatorStep(region *core.RegionInfo, op *Operator) *core.RegionInfo {
 				StoreId: s.ToStore,
 			}
 			region = region.Clone(core.WithRemoveStorePeer(s.ToStore), core.WithAddPeer(peer))
+		case DemoteVoterToLearner:
+			peer := region.GetStoreVoter(s.StoreID)
+			if peer == nil {
+				panic("Demote voter that doesn't exist")
+			}
+			region = region.Clone(core.WithRole(peer.GetId(), metapb.PeerRole_Learner))
 		default:
 			panic("Unknown operator step")
 		}
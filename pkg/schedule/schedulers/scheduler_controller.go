@@ -358,6 +358,9 @@ func (c *Controller) runScheduler(s *ScheduleController) {
 				continue
 			}
 			if op := s.Schedule(diagnosable); len(op) > 0 {
+				for _, o := range op {
+					o.SetSource(s.Scheduler.GetName())
+				}
 				added := c.opController.AddWaitingOperator(op...)
 				log.Debug("add operator", zap.Int("added", added), zap.Int("total", len(op)), zap.String("scheduler", s.Scheduler.GetName()))
 			}
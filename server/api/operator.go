@@ -171,7 +171,8 @@ func (h *operatorHandler) DeleteOperatorByRegion(w http.ResponseWriter, r *http.
 
 // @Tags     operator
 // @Summary  lists the finished operators since the given timestamp in second.
-// @Param    from  query  integer  false  "From Unix timestamp"
+// @Param    from    query  integer  false  "From Unix timestamp"
+// @Param    object  query  bool     false  "Whether to return as versioned JSON object."
 // @Produce  json
 // @Success  200  {object}  []operator.OpRecord
 // @Failure  400  {string}  string  "The request is invalid."
@@ -189,10 +190,15 @@ func (h *operatorHandler) GetOperatorRecords(w http.ResponseWriter, r *http.Requ
 			return
 		}
 	}
+	_, objectFlag := r.URL.Query()["object"]
 	records, err := h.GetRecords(from)
 	if err != nil {
 		h.r.JSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	h.r.JSON(w, http.StatusOK, records)
+	if objectFlag {
+		h.r.JSON(w, http.StatusOK, operator.VersionedRecords(records))
+	} else {
+		h.r.JSON(w, http.StatusOK, records)
+	}
 }